@@ -10,10 +10,13 @@ import (
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/danbruder/skyline/internal/api"
+	"github.com/danbruder/skyline/internal/backup"
 	"github.com/danbruder/skyline/internal/config"
 	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/internal/deploy"
 	"github.com/danbruder/skyline/internal/proxy"
 	"github.com/danbruder/skyline/internal/supervisor"
 	"github.com/danbruder/skyline/pkg/errors"
@@ -32,6 +35,10 @@ func main() {
 	// Define subcommands
 	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
 	serveConfigPath := serveCmd.String("config", defaultConfigPath, "Path to configuration file")
+	pruneCmd := flag.NewFlagSet("cache-prune", flag.ExitOnError)
+	pruneConfigPath := pruneCmd.String("config", defaultConfigPath, "Path to configuration file")
+	pruneMaxAge := pruneCmd.Duration("max-age", deploy.DefaultCacheMaxAge, "Remove compiled packages unused for longer than this")
+	pruneAll := pruneCmd.Bool("all", false, "Also remove downloaded modules and crates")
 
 	// Check if any command-line arguments are provided
 	if len(os.Args) < 2 {
@@ -46,6 +53,9 @@ func main() {
 		runServer(*serveConfigPath)
 	case "setup":
 		runSetup(false)
+	case "cache-prune":
+		pruneCmd.Parse(os.Args[2:])
+		runCachePrune(*pruneConfigPath, *pruneMaxAge, *pruneAll)
 	case "version":
 		fmt.Println("Skyline Deployment Platform v0.1.0")
 	case "help":
@@ -67,10 +77,11 @@ func printUsage() {
 	fmt.Println("\nUsage:")
 	fmt.Println("  skyline [command] [options]")
 	fmt.Println("\nAvailable Commands:")
-	fmt.Println("  serve     Start the Skyline server")
-	fmt.Println("  setup     Prepare the host for running Skyline")
-	fmt.Println("  version   Print the version information")
-	fmt.Println("  help      Show this help message")
+	fmt.Println("  serve        Start the Skyline server")
+	fmt.Println("  setup        Prepare the host for running Skyline")
+	fmt.Println("  cache-prune  Remove unused entries from the build caches")
+	fmt.Println("  version      Print the version information")
+	fmt.Println("  help         Show this help message")
 	fmt.Println("\nOptions:")
 	fmt.Println("  -config   Path to configuration file (default: config.yaml)")
 	fmt.Println("\nUse \"skyline [command] --help\" for more information about a command.")
@@ -160,8 +171,69 @@ func runServer(configPath string) {
 	}
 	defer proxyManager.Stop()
 
+	// Initialize backup manager
+	backupManager := backup.NewBackupManager(backup.BackupConfig{
+		LitestreamPath:    cfg.Backup.LitestreamPath,
+		LitestreamConfig:  cfg.Backup.LitestreamConfig,
+		BackupDestination: cfg.Backup.BackupDestination,
+		S3Bucket:          cfg.Backup.S3Bucket,
+		S3Region:          cfg.Backup.S3Region,
+		S3Endpoint:        cfg.Backup.S3Endpoint,
+		S3AccessKeyID:     cfg.Backup.S3AccessKeyID,
+		S3AccessKey:       cfg.Backup.S3AccessKey,
+		SyncInterval:      cfg.Backup.SyncInterval,
+		RetentionPolicy:   cfg.Backup.RetentionPolicy,
+	}, logger, eventBus)
+	if err := backupManager.Start(); err != nil {
+		logger.Fatalf("Failed to start backup manager: %v", err)
+	}
+	defer backupManager.Stop()
+
+	// Initialize deployment pipeline
+	fetcher := deploy.NewGitHubFetcher(deploy.SourceFetchConfig{
+		SourceDir:    cfg.Deploy.SourceDir,
+		FetchTimeout: cfg.Deploy.FetchTimeout,
+		GitHubToken:  cfg.GitHub.Token,
+		Credentials: []deploy.GitCredential{
+			gitCredential(deploy.ProviderGitLab, cfg.GitLab),
+			gitCredential(deploy.ProviderGitea, cfg.Gitea),
+			gitCredential(deploy.ProviderBitbucket, cfg.Bitbucket),
+		},
+	}, standardLogger)
+	builder := newBuilder(cfg, standardLogger)
+	for _, warning := range builder.SandboxWarnings() {
+		logger.Printf("WARNING: %s", warning)
+	}
+	deployer := deploy.NewDeployer(deploy.DeployConfig{
+		AppsDir:         cfg.Supervisor.AppsDir,
+		DataDir:         cfg.Deploy.DataDir,
+		DeployTimeout:   cfg.Deploy.DeployTimeout,
+		BackupDatabases: cfg.Backup.S3Bucket != "",
+		KeepReleases:    cfg.Deploy.KeepReleases,
+		HealthTimeout:   cfg.Deploy.HealthTimeout,
+		DrainTimeout:    cfg.Deploy.DrainTimeout,
+	}, standardLogger, database, sup, proxyManager, backupManager)
+	deployKeys, err := deploy.NewDeployKeys(deploy.DeployKeyConfig{
+		SecretKeyFile:  cfg.Deploy.SecretKeyFile,
+		KnownHostsFile: cfg.Deploy.KnownHosts,
+	}, standardLogger, database)
+	if err != nil {
+		logger.Fatalf("Failed to initialize deploy keys: %v", err)
+	}
+	pipeline := deploy.NewPipeline(deploy.PipelineConfig{
+		SourceDir:  cfg.Deploy.SourceDir,
+		BuildDir:   cfg.Deploy.BuildDir,
+		Timeout:    cfg.Deploy.Timeout,
+		MaxBuilds:  cfg.Deploy.MaxBuilds,
+		KeepBuilds: cfg.Deploy.KeepReleases,
+		Secrets:    append(cfg.GitHosts.Secrets(), cfg.Backup.S3AccessKey),
+	}, standardLogger, database, eventBus, fetcher, builder, deployer, deployKeys)
+	if err := pipeline.RecoverDeployments(ctx); err != nil {
+		logger.Fatalf("Failed to recover deployments: %v", err)
+	}
+
 	// Initialize API server
-	apiServer := api.NewServer(cfg.API, logger, database, eventBus)
+	apiServer := api.NewServer(cfg.API, cfg.GitHosts, logger, database, eventBus, pipeline, deployer, sup)
 	go func() {
 		if err := apiServer.Start(); err != nil {
 			logger.Printf("API server error: %v", err)
@@ -185,6 +257,57 @@ func runServer(configPath string) {
 	logger.Println("Deployment platform stopped")
 }
 
+// gitCredential returns the credential of a git provider
+func gitCredential(provider string, host config.GitHostConfig) deploy.GitCredential {
+	return deploy.GitCredential{
+		Provider: provider,
+		URL:      host.URL,
+		Username: host.Username,
+		Token:    host.Token,
+	}
+}
+
+// newBuilder creates the builder of the deployment pipeline
+func newBuilder(cfg *config.Config, logger errors.Logger) *deploy.Builder {
+	return deploy.NewBuilder(deploy.BuildConfig{
+		OutputDir:      cfg.Deploy.BuildDir,
+		CacheDir:       cfg.Deploy.CacheDir,
+		BuildpacksDir:  cfg.Deploy.BuildpacksDir,
+		WheelCacheDir:  cfg.Deploy.WheelCacheDir,
+		PythonIndexURL: cfg.Deploy.PythonIndexURL,
+		BuildTimeout:   cfg.Deploy.BuildTimeout,
+		Sandbox: deploy.SandboxConfig{
+			User:         cfg.Deploy.Sandbox.User,
+			AllowRoot:    cfg.Deploy.Sandbox.AllowRoot,
+			HomeDir:      cfg.Deploy.Sandbox.HomeDir,
+			CgroupDir:    cfg.Deploy.Sandbox.CgroupDir,
+			CPUs:         cfg.Deploy.Sandbox.CPUs,
+			MemoryMB:     cfg.Deploy.Sandbox.MemoryMB,
+			MaxProcesses: cfg.Deploy.Sandbox.MaxProcesses,
+			Offline:      cfg.Deploy.Sandbox.Offline,
+			PassEnv:      cfg.Deploy.Sandbox.PassEnv,
+		},
+	}, logger)
+}
+
+// runCachePrune removes unused entries from the build caches
+func runCachePrune(configPath string, maxAge time.Duration, all bool) {
+	logger := log.New(os.Stdout, "[skyline] ", log.LstdFlags)
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		logger.Fatalf("Failed to load config: %v", err)
+	}
+
+	builder := newBuilder(cfg, errors.NewStandardLogger(logger))
+	result, err := builder.PruneCaches(context.Background(), maxAge, all)
+	if err != nil {
+		logger.Fatalf("Failed to prune build caches: %v", err)
+	}
+
+	logger.Printf("Removed %d files (%.1f MB) from the build caches", result.Files, float64(result.Bytes)/(1024*1024))
+}
+
 const setupScript = `#!/bin/bash
 set -e
 
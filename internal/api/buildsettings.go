@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	buildTagPattern = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+	buildEnvPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// BuildSettingsRequest is the request body for configuring how an app is
+// built. Unset settings fall back to what the builder detects.
+type BuildSettingsRequest struct {
+	MainPackage string            `json:"main_package,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	LDFlags     string            `json:"ldflags,omitempty"`
+	GoFlags     string            `json:"goflags,omitempty"`
+	CGOEnabled  *bool             `json:"cgo_enabled,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+func (s *Server) handleGetBuildSettings(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	settings, err := s.db.GetBuildSettings(r.Context(), appID)
+	if err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	s.respond(w, r, settings, http.StatusOK)
+}
+
+func (s *Server) handleSetBuildSettings(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	// Get app
+	if _, err := s.db.GetApp(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	// Parse request
+	var req BuildSettingsRequest
+	if err := s.decodeJSON(r, &req); err != nil {
+		s.respondError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if err := validateBuildSettings(req); err != nil {
+		s.respondError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	settings := &db.BuildSettings{
+		AppID:       appID,
+		MainPackage: req.MainPackage,
+		Tags:        req.Tags,
+		LDFlags:     req.LDFlags,
+		GoFlags:     req.GoFlags,
+		CGOEnabled:  req.CGOEnabled,
+		Env:         req.Env,
+	}
+
+	if err := s.db.SetBuildSettings(r.Context(), settings); err != nil {
+		s.respondError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.respond(w, r, settings, http.StatusOK)
+}
+
+func (s *Server) handleDeleteBuildSettings(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	if err := s.db.DeleteBuildSettings(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.respond(w, r, nil, http.StatusNoContent)
+}
+
+// validateBuildSettings checks build settings before they are stored
+func validateBuildSettings(req BuildSettingsRequest) error {
+	if req.MainPackage != "" && !filepath.IsLocal(filepath.FromSlash(req.MainPackage)) {
+		return fmt.Errorf("main_package must be a path inside the repository, e.g. ./cmd/server")
+	}
+
+	for _, tag := range req.Tags {
+		if !buildTagPattern.MatchString(tag) {
+			return fmt.Errorf("invalid build tag %q", tag)
+		}
+	}
+
+	for name := range req.Env {
+		if !buildEnvPattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) handleGetDeployKey(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	key, err := s.db.GetDeployKey(r.Context(), appID)
+	if err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	s.respond(w, r, key, http.StatusOK)
+}
+
+// handleRotateDeployKey generates a new deploy key for an app. The old key
+// stops working once it is removed from the repository.
+func (s *Server) handleRotateDeployKey(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	// Get app
+	if _, err := s.db.GetApp(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	key, err := s.pipeline.GenerateDeployKey(r.Context(), appID)
+	if err != nil {
+		s.respondError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.respond(w, r, key, http.StatusCreated)
+}
+
+func (s *Server) handleDeleteDeployKey(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	if err := s.db.DeleteDeployKey(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.respond(w, r, nil, http.StatusNoContent)
+}
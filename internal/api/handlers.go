@@ -2,7 +2,6 @@ package api
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,10 +9,12 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/danbruder/skyline/internal/db"
-	"github.com/danbruder/skyline/pkg/events"
+	"github.com/danbruder/skyline/internal/supervisor"
+	"github.com/danbruder/skyline/pkg/errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
@@ -33,12 +34,31 @@ type DeployRequest struct {
 	Branch    string `json:"branch,omitempty"`
 }
 
-// GitHub webhook event types
+// RollbackRequest is the request body for rolling back an app
+type RollbackRequest struct {
+	DeploymentID string `json:"deployment_id,omitempty"`
+}
+
+// Deployment log streaming settings
 const (
-	GithubEventPush = "push"
-	GithubEventPing = "ping"
+	maxLogLines     = 1000
+	logPollInterval = 500 * time.Millisecond
 )
 
+// AppStatusResponse is the response body for the app status endpoints
+type AppStatusResponse struct {
+	AppID     string    `json:"app_id"`
+	Status    string    `json:"status"` // running, stopped, crashed
+	PID       int       `json:"pid,omitempty"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	Uptime    string    `json:"uptime,omitempty"`
+	Port      int       `json:"port,omitempty"`
+	Restarts  int       `json:"restarts"`
+
+	// Health is only reported for apps with a health check
+	Health *supervisor.HealthStatus `json:"health,omitempty"`
+}
+
 // Complete the handler implementations in server.go
 
 func (s *Server) handleDeployApp(w http.ResponseWriter, r *http.Request) {
@@ -51,9 +71,9 @@ func (s *Server) handleDeployApp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse deploy request
+	// Parse deploy request (an empty body deploys the branch head)
 	var req DeployRequest
-	if err := s.decodeJSON(r, &req); err != nil {
+	if err := s.decodeJSON(r, &req); err != nil && err != io.EOF {
 		s.respondError(w, r, err, http.StatusBadRequest)
 		return
 	}
@@ -73,93 +93,253 @@ func (s *Server) handleDeployApp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Start deployment in background
-	go s.performDeploy(app, deployment)
+	// Queue deployment behind any running deployment of the app
+	s.logger.Printf("Queueing deployment %s for app %s", deployment.ID, app.ID)
+	s.pipeline.Enqueue(deployment)
 
 	s.respond(w, r, deployment, http.StatusAccepted)
 }
 
-func (s *Server) handleStartApp(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleRollbackApp(w http.ResponseWriter, r *http.Request) {
 	appID := chi.URLParam(r, "appID")
 
 	// Get app
-	app, err := s.db.GetApp(r.Context(), appID)
+	if _, err := s.db.GetApp(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	// Parse rollback request (an empty body rolls back to the previous release)
+	var req RollbackRequest
+	if err := s.decodeJSON(r, &req); err != nil && err != io.EOF {
+		s.respondError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	deployment, err := s.pipeline.CreateRollback(r.Context(), appID, req.DeploymentID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, errors.ErrRecordNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errors.ErrInvalidData), errors.Is(err, errors.ErrResourceUnavailable):
+			status = http.StatusConflict
+		}
+		s.respondError(w, r, err, status)
+		return
+	}
+
+	// Queue rollback behind any running deployment of the app
+	s.logger.Printf("Queueing rollback %s of app %s to release %s", deployment.ID, appID, deployment.ReleaseID)
+	s.pipeline.Enqueue(deployment)
+
+	s.respond(w, r, deployment, http.StatusAccepted)
+}
+
+func (s *Server) handleGetBuildQueue(w http.ResponseWriter, r *http.Request) {
+	s.respond(w, r, s.pipeline.BuildQueue(), http.StatusOK)
+}
+
+func (s *Server) handleGetDeployment(w http.ResponseWriter, r *http.Request) {
+	deploymentID := chi.URLParam(r, "deploymentID")
+
+	deployment, err := s.db.GetDeployment(r.Context(), deploymentID)
+	if err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	// Rollbacks install the artifact of the release they return to
+	provenance, err := s.db.GetProvenance(r.Context(), deployment.ID)
+	if errors.Is(err, errors.ErrRecordNotFound) && deployment.ReleaseID != "" && deployment.ReleaseID != deployment.ID {
+		provenance, err = s.db.GetProvenance(r.Context(), deployment.ReleaseID)
+	}
+	if err != nil && !errors.Is(err, errors.ErrRecordNotFound) {
+		s.respondError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.respond(w, r, struct {
+		*db.Deployment
+		Provenance *db.Provenance `json:"provenance,omitempty"`
+	}{deployment, provenance}, http.StatusOK)
+}
+
+func (s *Server) handleCancelDeployment(w http.ResponseWriter, r *http.Request) {
+	deploymentID := chi.URLParam(r, "deploymentID")
+
+	// Get deployment
+	if _, err := s.db.GetDeployment(r.Context(), deploymentID); err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	if err := s.pipeline.CancelDeployment(deploymentID); err != nil {
+		s.respondError(w, r, err, http.StatusConflict)
+		return
+	}
+
+	// Running deployments are marked cancelled once the pipeline stops
+	deployment, err := s.db.GetDeployment(r.Context(), deploymentID)
 	if err != nil {
+		s.respondError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.respond(w, r, deployment, http.StatusAccepted)
+}
+
+func (s *Server) handleGetDeploymentLogs(w http.ResponseWriter, r *http.Request) {
+	deploymentID := chi.URLParam(r, "deploymentID")
+
+	// Get deployment
+	if _, err := s.db.GetDeployment(r.Context(), deploymentID); err != nil {
 		s.respondError(w, r, err, http.StatusNotFound)
 		return
 	}
 
-	// TODO: Implement app start with the supervisor
-	// For MVP, we'll just update the status
-	app.Status = "running"
-	app.UpdatedAt = time.Now()
+	// Parse query parameters
+	after := 0
+	if afterParam := r.URL.Query().Get("after"); afterParam != "" {
+		if parsed, err := strconv.Atoi(afterParam); err == nil && parsed > 0 {
+			after = parsed
+		}
+	}
 
-	if err := s.db.UpdateApp(r.Context(), app); err != nil {
+	if isEventStream(r) {
+		s.streamDeploymentLogs(w, r, deploymentID, after)
+		return
+	}
+
+	lines, err := s.db.ListDeploymentLogs(r.Context(), deploymentID, after, maxLogLines)
+	if err != nil {
 		s.respondError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	s.respond(w, r, app, http.StatusOK)
+	s.respond(w, r, lines, http.StatusOK)
 }
 
-func (s *Server) handleStopApp(w http.ResponseWriter, r *http.Request) {
+// streamDeploymentLogs follows the log of a deployment as server-sent events
+// until the deployment has ended and every line has been sent
+func (s *Server) streamDeploymentLogs(w http.ResponseWriter, r *http.Request, deploymentID string, after int) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.respondError(w, r, fmt.Errorf("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	// Streams outlive the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Read the status before the lines so no line written before the
+		// deployment ended is missed
+		deployment, err := s.db.GetDeployment(r.Context(), deploymentID)
+		if err != nil {
+			return
+		}
+
+		lines, err := s.db.ListDeploymentLogs(r.Context(), deploymentID, after, maxLogLines)
+		if err != nil {
+			return
+		}
+
+		for _, line := range lines {
+			data, err := json.Marshal(line)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", line.Seq, data)
+			after = line.Seq
+		}
+
+		if len(lines) < maxLogLines && deploymentEnded(deployment.Status) {
+			data, _ := json.Marshal(deployment)
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) handleStartApp(w http.ResponseWriter, r *http.Request) {
 	appID := chi.URLParam(r, "appID")
 
 	// Get app
-	app, err := s.db.GetApp(r.Context(), appID)
-	if err != nil {
+	if _, err := s.db.GetApp(r.Context(), appID); err != nil {
 		s.respondError(w, r, err, http.StatusNotFound)
 		return
 	}
 
-	// TODO: Implement app stop with the supervisor
-	// For MVP, we'll just update the status
-	app.Status = "stopped"
-	app.UpdatedAt = time.Now()
+	if err := s.deployer.Start(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusConflict)
+		return
+	}
 
-	if err := s.db.UpdateApp(r.Context(), app); err != nil {
-		s.respondError(w, r, err, http.StatusInternalServerError)
+	s.respondAppStatus(w, r, appID)
+}
+
+func (s *Server) handleStopApp(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	// Get app
+	if _, err := s.db.GetApp(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	if err := s.deployer.Stop(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusConflict)
 		return
 	}
 
-	s.respond(w, r, app, http.StatusOK)
+	s.respondAppStatus(w, r, appID)
 }
 
 func (s *Server) handleRestartApp(w http.ResponseWriter, r *http.Request) {
 	appID := chi.URLParam(r, "appID")
 
 	// Get app
-	app, err := s.db.GetApp(r.Context(), appID)
-	if err != nil {
+	if _, err := s.db.GetApp(r.Context(), appID); err != nil {
 		s.respondError(w, r, err, http.StatusNotFound)
 		return
 	}
 
-	// TODO: Implement app restart with the supervisor
-	// For MVP, we'll just update the status
-	app.Status = "running"
-	app.UpdatedAt = time.Now()
-
-	if err := s.db.UpdateApp(r.Context(), app); err != nil {
-		s.respondError(w, r, err, http.StatusInternalServerError)
+	if err := s.deployer.Restart(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusConflict)
 		return
 	}
 
-	s.respond(w, r, app, http.StatusOK)
+	s.respondAppStatus(w, r, appID)
 }
 
 func (s *Server) handleGetAppStatus(w http.ResponseWriter, r *http.Request) {
 	appID := chi.URLParam(r, "appID")
 
 	// Get app
-	app, err := s.db.GetApp(r.Context(), appID)
-	if err != nil {
+	if _, err := s.db.GetApp(r.Context(), appID); err != nil {
 		s.respondError(w, r, err, http.StatusNotFound)
 		return
 	}
 
-	// Return status
-	s.respond(w, r, map[string]string{"status": app.Status}, http.StatusOK)
+	s.respondAppStatus(w, r, appID)
 }
 
 func (s *Server) handleGetAppLogs(w http.ResponseWriter, r *http.Request) {
@@ -191,119 +371,44 @@ func (s *Server) handleGetAppLogs(w http.ResponseWriter, r *http.Request) {
 	s.respond(w, r, map[string]string{"logs": logs}, http.StatusOK)
 }
 
-func (s *Server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
-	// Verify webhook signature if secret is set
-	// TODO: Implement proper verification
-
-	// Get event type
-	eventType := r.Header.Get("X-GitHub-Event")
-	if eventType == "" {
-		s.respondError(w, r, fmt.Errorf("missing X-GitHub-Event header"), http.StatusBadRequest)
-		return
-	}
-
-	// Read body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		s.respondError(w, r, err, http.StatusInternalServerError)
-		return
-	}
-	r.Body = io.NopCloser(bytes.NewBuffer(body))
-
-	// Handle different events
-	switch eventType {
-	case GithubEventPing:
-		s.respond(w, r, map[string]string{"status": "pong"}, http.StatusOK)
-		return
-	case GithubEventPush:
-		var pushEvent struct {
-			Ref        string `json:"ref"`
-			Repository struct {
-				HTMLURL string `json:"html_url"`
-			} `json:"repository"`
-			HeadCommit struct {
-				ID string `json:"id"`
-			} `json:"head_commit"`
-		}
-
-		if err := json.Unmarshal(body, &pushEvent); err != nil {
-			s.respondError(w, r, err, http.StatusBadRequest)
-			return
-		}
-
-		// Extract branch from ref (refs/heads/master -> master)
-		branch := pushEvent.Ref
-		if len(branch) > 11 && branch[:11] == "refs/heads/" {
-			branch = branch[11:]
-		}
+// Helper methods
 
-		// Find apps that use this repository and branch
-		apps, err := s.db.ListApps(r.Context())
-		if err != nil {
-			s.respondError(w, r, err, http.StatusInternalServerError)
-			return
-		}
+// isEventStream reports whether the client asked to follow a stream
+func isEventStream(r *http.Request) bool {
+	follow := r.URL.Query().Get("follow")
+	return follow == "true" || follow == "1" ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
 
-		for _, app := range apps {
-			if app.RepoURL == pushEvent.Repository.HTMLURL && app.Branch == branch {
-				// Create deployment
-				deployID := uuid.New().String()
-				deployment := &db.Deployment{
-					ID:        deployID,
-					AppID:     app.ID,
-					CommitSHA: pushEvent.HeadCommit.ID,
-					Status:    "pending",
-					StartedAt: time.Now(),
-				}
+// deploymentEnded reports whether a deployment status is final
+func deploymentEnded(status string) bool {
+	return status != "pending" && status != "in_progress"
+}
 
-				if err := s.db.CreateDeployment(r.Context(), deployment); err != nil {
-					s.logger.Printf("Error creating deployment: %v", err)
-					continue
-				}
+// respondAppStatus responds with the process state the supervisor reports
+// for an app
+func (s *Server) respondAppStatus(w http.ResponseWriter, r *http.Request, appID string) {
+	status := AppStatusResponse{
+		AppID:  appID,
+		Status: "stopped",
+	}
 
-				// Start deployment in background
-				go s.performDeploy(app, deployment)
-			}
+	if proc, err := s.supervisor.GetProcessInfo(appID); err == nil {
+		status.Status = proc.Status
+		status.Restarts = proc.Restarts
+		if proc.Status == "running" {
+			status.PID = proc.Cmd.Process.Pid
+			status.StartedAt = proc.StartTime
+			status.Uptime = time.Since(proc.StartTime).Round(time.Second).String()
+			status.Port = proc.Port
 		}
-
-		s.respond(w, r, map[string]string{"status": "processing"}, http.StatusOK)
-		return
-	default:
-		s.respond(w, r, map[string]string{"status": "ignored", "event": eventType}, http.StatusOK)
 	}
-}
 
-// Helper methods
+	if health, err := s.supervisor.GetHealth(appID); err == nil {
+		status.Health = &health
+	}
 
-func (s *Server) performDeploy(app *db.App, deployment *db.Deployment) {
-	ctx := context.Background()
-	s.logger.Printf("Starting deployment %s for app %s", deployment.ID, app.ID)
-
-	// Update deployment status
-	deployment.Status = "in_progress"
-	s.db.UpdateDeployment(ctx, deployment)
-
-	// TODO: Implement actual deployment logic
-	// For MVP, we'll just simulate a successful deployment
-	time.Sleep(2 * time.Second)
-
-	// Update deployment status
-	deployment.Status = "success"
-	deployment.EndedAt = time.Now()
-	s.db.UpdateDeployment(ctx, deployment)
-
-	// Update app status
-	app.Status = "running"
-	app.LastDeploy = time.Now()
-	app.UpdatedAt = time.Now()
-	s.db.UpdateApp(ctx, app)
-
-	// Publish event
-	s.eventBus.Publish(events.Event{
-		Type:    events.AppDeployed,
-		AppID:   app.ID,
-		Message: fmt.Sprintf("App %s deployed successfully", app.Name),
-	})
+	s.respond(w, r, status, http.StatusOK)
 }
 
 func (s *Server) readLastLines(filePath string, lineCount int) (string, error) {
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/internal/supervisor"
+	"github.com/go-chi/chi/v5"
+)
+
+// HealthCheckRequest is the request body for configuring the health check
+// of an app. Unset settings fall back to their defaults.
+type HealthCheckRequest struct {
+	Type               string `json:"type"` // http, tcp
+	Path               string `json:"path,omitempty"`
+	ExpectedStatus     int    `json:"expected_status,omitempty"`
+	IntervalSeconds    int    `json:"interval_seconds,omitempty"`
+	TimeoutSeconds     int    `json:"timeout_seconds,omitempty"`
+	FailureThreshold   int    `json:"failure_threshold,omitempty"`
+	GracePeriodSeconds int    `json:"grace_period_seconds,omitempty"`
+}
+
+func (s *Server) handleGetHealthCheck(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	check, err := s.db.GetHealthCheck(r.Context(), appID)
+	if err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	s.respond(w, r, check, http.StatusOK)
+}
+
+func (s *Server) handleSetHealthCheck(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	// Get app
+	if _, err := s.db.GetApp(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	// Parse request
+	var req HealthCheckRequest
+	if err := s.decodeJSON(r, &req); err != nil {
+		s.respondError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Type != "" && req.Type != db.HealthCheckHTTP && req.Type != db.HealthCheckTCP {
+		s.respondError(w, r, fmt.Errorf("type must be %q or %q", db.HealthCheckHTTP, db.HealthCheckTCP), http.StatusBadRequest)
+		return
+	}
+	if req.IntervalSeconds < 0 || req.TimeoutSeconds < 0 || req.FailureThreshold < 0 ||
+		req.GracePeriodSeconds < 0 || req.ExpectedStatus < 0 {
+		s.respondError(w, r, fmt.Errorf("health check settings must not be negative"), http.StatusBadRequest)
+		return
+	}
+
+	// Store the effective settings
+	config := supervisor.HealthCheck{
+		Type:             req.Type,
+		Path:             req.Path,
+		ExpectedStatus:   req.ExpectedStatus,
+		Interval:         time.Duration(req.IntervalSeconds) * time.Second,
+		Timeout:          time.Duration(req.TimeoutSeconds) * time.Second,
+		FailureThreshold: req.FailureThreshold,
+		GracePeriod:      time.Duration(req.GracePeriodSeconds) * time.Second,
+	}.WithDefaults()
+
+	check := &db.HealthCheck{
+		AppID:              appID,
+		Type:               config.Type,
+		Path:               config.Path,
+		ExpectedStatus:     config.ExpectedStatus,
+		IntervalSeconds:    int(config.Interval / time.Second),
+		TimeoutSeconds:     int(config.Timeout / time.Second),
+		FailureThreshold:   config.FailureThreshold,
+		GracePeriodSeconds: int(config.GracePeriod / time.Second),
+	}
+
+	if err := s.db.SetHealthCheck(r.Context(), check); err != nil {
+		s.respondError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.supervisor.SetHealthCheck(appID, healthCheckConfig(check))
+
+	s.respond(w, r, check, http.StatusOK)
+}
+
+func (s *Server) handleDeleteHealthCheck(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	if err := s.db.DeleteHealthCheck(r.Context(), appID); err != nil {
+		s.respondError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.supervisor.RemoveHealthCheck(appID)
+
+	s.respond(w, r, nil, http.StatusNoContent)
+}
+
+// loadHealthChecks hands the stored health checks of all apps to the
+// supervisor
+func (s *Server) loadHealthChecks(ctx context.Context) error {
+	checks, err := s.db.ListHealthChecks(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, check := range checks {
+		s.supervisor.SetHealthCheck(check.AppID, healthCheckConfig(check))
+	}
+
+	return nil
+}
+
+// healthCheckConfig converts a stored health check to supervisor settings
+func healthCheckConfig(check *db.HealthCheck) supervisor.HealthCheck {
+	return supervisor.HealthCheck{
+		Type:             check.Type,
+		Path:             check.Path,
+		ExpectedStatus:   check.ExpectedStatus,
+		Interval:         time.Duration(check.IntervalSeconds) * time.Second,
+		Timeout:          time.Duration(check.TimeoutSeconds) * time.Second,
+		FailureThreshold: check.FailureThreshold,
+		GracePeriod:      time.Duration(check.GracePeriodSeconds) * time.Second,
+	}
+}
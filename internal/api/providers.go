@@ -0,0 +1,234 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/danbruder/skyline/internal/deploy"
+)
+
+// Webhook event types
+const (
+	GithubEventPush    = "push"
+	GithubEventPing    = "ping"
+	GitlabEventPush    = "Push Hook"
+	GiteaEventPush     = "push"
+	BitbucketEventPush = "repo:push"
+	BitbucketEventPing = "diagnostics:ping"
+)
+
+// zeroCommitSHA is the commit a branch is pushed to when it is deleted
+const zeroCommitSHA = "0000000000000000000000000000000000000000"
+
+// webhookProvider describes how a git provider delivers webhooks
+type webhookProvider struct {
+	Name           string
+	EventHeader    string
+	DeliveryHeader string
+	SecretHeader   string
+	// Verify checks a delivery against the webhook secret
+	Verify func(secret string, header http.Header, body []byte) bool
+	// Parse returns the branches an event updates, or why it is ignored
+	Parse func(event string, payload []byte) ([]deploy.WebhookEvent, string, error)
+}
+
+// webhookProviders are the providers webhooks are received of, by name
+var webhookProviders = map[string]*webhookProvider{
+	deploy.ProviderGitHub: {
+		Name:           deploy.ProviderGitHub,
+		EventHeader:    "X-GitHub-Event",
+		DeliveryHeader: "X-GitHub-Delivery",
+		SecretHeader:   "X-Hub-Signature-256 signature",
+		Verify: func(secret string, header http.Header, body []byte) bool {
+			return verifyGitHubSignature(secret, body, header.Get("X-Hub-Signature-256"))
+		},
+		Parse: parseGitHubEvent,
+	},
+	deploy.ProviderGitLab: {
+		Name:           deploy.ProviderGitLab,
+		EventHeader:    "X-Gitlab-Event",
+		DeliveryHeader: "X-Gitlab-Event-UUID",
+		SecretHeader:   "X-Gitlab-Token",
+		Verify: func(secret string, header http.Header, body []byte) bool {
+			return verifyToken(secret, header.Get("X-Gitlab-Token"))
+		},
+		Parse: parseGitLabEvent,
+	},
+	deploy.ProviderGitea: {
+		Name:           deploy.ProviderGitea,
+		EventHeader:    "X-Gitea-Event",
+		DeliveryHeader: "X-Gitea-Delivery",
+		SecretHeader:   "X-Gitea-Signature signature",
+		Verify: func(secret string, header http.Header, body []byte) bool {
+			return verifyHMAC(secret, body, header.Get("X-Gitea-Signature"))
+		},
+		Parse: parseGiteaEvent,
+	},
+	deploy.ProviderBitbucket: {
+		Name:           deploy.ProviderBitbucket,
+		EventHeader:    "X-Event-Key",
+		DeliveryHeader: "X-Request-UUID",
+		SecretHeader:   "X-Hub-Signature signature",
+		Verify: func(secret string, header http.Header, body []byte) bool {
+			return verifyGitHubSignature(secret, body, header.Get("X-Hub-Signature"))
+		},
+		Parse: parseBitbucketEvent,
+	},
+}
+
+// parseGitHubEvent parses a GitHub delivery
+func parseGitHubEvent(event string, payload []byte) ([]deploy.WebhookEvent, string, error) {
+	switch event {
+	case GithubEventPing:
+		return nil, "pong", nil
+	case GithubEventPush:
+		return parseGitHubPush(payload)
+	}
+	return nil, fmt.Sprintf("event %s is not handled", event), nil
+}
+
+// parseGiteaEvent parses a Gitea delivery, whose push payload follows GitHub's
+func parseGiteaEvent(event string, payload []byte) ([]deploy.WebhookEvent, string, error) {
+	if event == GiteaEventPush {
+		return parseGitHubPush(payload)
+	}
+	return nil, fmt.Sprintf("event %s is not handled", event), nil
+}
+
+// parseGitHubPush parses the push payload of GitHub and Gitea
+func parseGitHubPush(payload []byte) ([]deploy.WebhookEvent, string, error) {
+	var push struct {
+		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Repository struct {
+			HTMLURL string `json:"html_url"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &push); err != nil {
+		return nil, "", err
+	}
+
+	return branchUpdate(push.Repository.HTMLURL, push.Ref, push.After)
+}
+
+// parseGitLabEvent parses a GitLab delivery
+func parseGitLabEvent(event string, payload []byte) ([]deploy.WebhookEvent, string, error) {
+	if event != GitlabEventPush {
+		return nil, fmt.Sprintf("event %s is not handled", event), nil
+	}
+
+	var push struct {
+		Ref     string `json:"ref"`
+		After   string `json:"after"`
+		Project struct {
+			WebURL string `json:"web_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(payload, &push); err != nil {
+		return nil, "", err
+	}
+
+	return branchUpdate(push.Project.WebURL, push.Ref, push.After)
+}
+
+// parseBitbucketEvent parses a Bitbucket Cloud delivery. A push may update
+// several branches.
+func parseBitbucketEvent(event string, payload []byte) ([]deploy.WebhookEvent, string, error) {
+	switch event {
+	case BitbucketEventPing:
+		return nil, "pong", nil
+	case BitbucketEventPush:
+	default:
+		return nil, fmt.Sprintf("event %s is not handled", event), nil
+	}
+
+	var push struct {
+		Push struct {
+			Changes []struct {
+				New *struct {
+					Type   string `json:"type"`
+					Name   string `json:"name"`
+					Target struct {
+						Hash string `json:"hash"`
+					} `json:"target"`
+				} `json:"new"`
+			} `json:"changes"`
+		} `json:"push"`
+		Repository struct {
+			Links struct {
+				HTML struct {
+					Href string `json:"href"`
+				} `json:"html"`
+			} `json:"links"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &push); err != nil {
+		return nil, "", err
+	}
+
+	var events []deploy.WebhookEvent
+	for _, change := range push.Push.Changes {
+		// Deleted branches have no new state
+		if change.New == nil || change.New.Type != "branch" {
+			continue
+		}
+		events = append(events, deploy.WebhookEvent{
+			RepoURL:   push.Repository.Links.HTML.Href,
+			Branch:    change.New.Name,
+			CommitSHA: change.New.Target.Hash,
+		})
+	}
+	if len(events) == 0 {
+		return nil, "push updates no branch", nil
+	}
+
+	return events, "", nil
+}
+
+// branchUpdate returns the event of a push to a ref, unless the ref is not a
+// branch or the push deleted it
+func branchUpdate(repoURL, ref, commit string) ([]deploy.WebhookEvent, string, error) {
+	branch, ok := strings.CutPrefix(ref, "refs/heads/")
+	if !ok {
+		return nil, fmt.Sprintf("ref %s is not a branch", ref), nil
+	}
+	if commit == zeroCommitSHA {
+		return nil, fmt.Sprintf("branch %s was deleted", branch), nil
+	}
+
+	return []deploy.WebhookEvent{{RepoURL: repoURL, Branch: branch, CommitSHA: commit}}, "", nil
+}
+
+// verifyGitHubSignature checks an X-Hub-Signature-256 header against the
+// HMAC-SHA256 of the body
+func verifyGitHubSignature(secret string, body []byte, signature string) bool {
+	const prefix = "sha256="
+	if !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+
+	return verifyHMAC(secret, body, strings.TrimPrefix(signature, prefix))
+}
+
+// verifyHMAC checks a hex-encoded HMAC-SHA256 of the body
+func verifyHMAC(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// verifyToken checks a secret token sent with a delivery
+func verifyToken(secret, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(token)) == 1
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/danbruder/skyline/internal/deploy"
+)
+
+func TestVerifyWebhookDeliveries(t *testing.T) {
+	secret := "webhook-secret"
+	body := []byte(`{"ref":"refs/heads/main"}`)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		provider string
+		header   http.Header
+		expected bool
+	}{
+		{deploy.ProviderGitLab, http.Header{"X-Gitlab-Token": {secret}}, true},
+		{deploy.ProviderGitLab, http.Header{"X-Gitlab-Token": {"wrong"}}, false},
+		{deploy.ProviderGitLab, http.Header{}, false},
+		{deploy.ProviderGitea, http.Header{"X-Gitea-Signature": {signature}}, true},
+		{deploy.ProviderGitea, http.Header{"X-Gitea-Signature": {"sha256=" + signature}}, false},
+		{deploy.ProviderBitbucket, http.Header{"X-Hub-Signature": {"sha256=" + signature}}, true},
+		{deploy.ProviderBitbucket, http.Header{"X-Hub-Signature-256": {"sha256=" + signature}}, false},
+		{deploy.ProviderGitHub, http.Header{"X-Hub-Signature-256": {"sha256=" + signature}}, true},
+	}
+
+	for _, tt := range tests {
+		if got := webhookProviders[tt.provider].Verify(secret, tt.header, body); got != tt.expected {
+			t.Errorf("%s Verify(%v) = %v, want %v", tt.provider, tt.header, got, tt.expected)
+		}
+	}
+}
+
+func TestParseWebhookEvents(t *testing.T) {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+
+	tests := []struct {
+		name        string
+		provider    string
+		event       string
+		payload     string
+		want        []deploy.WebhookEvent
+		wantIgnored bool
+		wantErr     bool
+	}{
+		{
+			name:     "GitHub push",
+			provider: deploy.ProviderGitHub,
+			event:    GithubEventPush,
+			payload:  `{"ref":"refs/heads/main","after":"` + sha + `","repository":{"html_url":"https://github.com/acme/app"}}`,
+			want:     []deploy.WebhookEvent{{RepoURL: "https://github.com/acme/app", Branch: "main", CommitSHA: sha}},
+		},
+		{
+			name:        "GitHub ping",
+			provider:    deploy.ProviderGitHub,
+			event:       GithubEventPing,
+			payload:     `{}`,
+			wantIgnored: true,
+		},
+		{
+			name:        "GitHub tag push",
+			provider:    deploy.ProviderGitHub,
+			event:       GithubEventPush,
+			payload:     `{"ref":"refs/tags/v1.0.0","after":"` + sha + `"}`,
+			wantIgnored: true,
+		},
+		{
+			name:     "GitLab push",
+			provider: deploy.ProviderGitLab,
+			event:    GitlabEventPush,
+			payload:  `{"object_kind":"push","ref":"refs/heads/main","after":"` + sha + `","project":{"web_url":"https://gitlab.com/acme/app"}}`,
+			want:     []deploy.WebhookEvent{{RepoURL: "https://gitlab.com/acme/app", Branch: "main", CommitSHA: sha}},
+		},
+		{
+			name:        "GitLab branch deletion",
+			provider:    deploy.ProviderGitLab,
+			event:       GitlabEventPush,
+			payload:     `{"ref":"refs/heads/feature","after":"` + zeroCommitSHA + `","project":{"web_url":"https://gitlab.com/acme/app"}}`,
+			wantIgnored: true,
+		},
+		{
+			name:        "GitLab merge request",
+			provider:    deploy.ProviderGitLab,
+			event:       "Merge Request Hook",
+			payload:     `{}`,
+			wantIgnored: true,
+		},
+		{
+			name:     "Gitea push",
+			provider: deploy.ProviderGitea,
+			event:    GiteaEventPush,
+			payload:  `{"ref":"refs/heads/develop","after":"` + sha + `","repository":{"html_url":"https://git.example.com/acme/app"}}`,
+			want:     []deploy.WebhookEvent{{RepoURL: "https://git.example.com/acme/app", Branch: "develop", CommitSHA: sha}},
+		},
+		{
+			name:     "Bitbucket push of several branches",
+			provider: deploy.ProviderBitbucket,
+			event:    BitbucketEventPush,
+			payload: `{"push":{"changes":[
+				{"new":{"type":"branch","name":"main","target":{"hash":"` + sha + `"}}},
+				{"new":null},
+				{"new":{"type":"tag","name":"v1","target":{"hash":"` + sha + `"}}},
+				{"new":{"type":"branch","name":"staging","target":{"hash":"` + sha + `"}}}
+			]},"repository":{"links":{"html":{"href":"https://bitbucket.org/acme/app"}}}}`,
+			want: []deploy.WebhookEvent{
+				{RepoURL: "https://bitbucket.org/acme/app", Branch: "main", CommitSHA: sha},
+				{RepoURL: "https://bitbucket.org/acme/app", Branch: "staging", CommitSHA: sha},
+			},
+		},
+		{
+			name:        "Bitbucket branch deletion",
+			provider:    deploy.ProviderBitbucket,
+			event:       BitbucketEventPush,
+			payload:     `{"push":{"changes":[{"new":null}]}}`,
+			wantIgnored: true,
+		},
+		{
+			name:     "Invalid payload",
+			provider: deploy.ProviderGitea,
+			event:    GiteaEventPush,
+			payload:  `{"ref":`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ignored, err := webhookProviders[tt.provider].Parse(tt.event, []byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (ignored != "") != tt.wantIgnored {
+				t.Errorf("Parse() ignored = %q, wantIgnored %v", ignored, tt.wantIgnored)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
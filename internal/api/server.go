@@ -11,6 +11,8 @@ import (
 
 	"github.com/danbruder/skyline/internal/config"
 	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/internal/deploy"
+	"github.com/danbruder/skyline/internal/supervisor"
 	"github.com/danbruder/skyline/pkg/events"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -18,16 +20,29 @@ import (
 
 // Server is the API server
 type Server struct {
-	cfg      config.APIConfig
-	logger   *log.Logger
-	router   *chi.Mux
-	db       *db.Database
-	eventBus *events.EventBus
-	server   *http.Server
+	cfg        config.APIConfig
+	gitHosts   config.GitHosts
+	logger     *log.Logger
+	router     *chi.Mux
+	db         *db.Database
+	eventBus   *events.EventBus
+	pipeline   *deploy.Pipeline
+	deployer   *deploy.Deployer
+	supervisor *supervisor.Supervisor
+	server     *http.Server
 }
 
 // NewServer creates a new API server
-func NewServer(cfg config.APIConfig, logger *log.Logger, database *db.Database, eventBus *events.EventBus) *Server {
+func NewServer(
+	cfg config.APIConfig,
+	gitHosts config.GitHosts,
+	logger *log.Logger,
+	database *db.Database,
+	eventBus *events.EventBus,
+	pipeline *deploy.Pipeline,
+	deployer *deploy.Deployer,
+	sup *supervisor.Supervisor,
+) *Server {
 	if cfg.ReadTimeout == 0 {
 		cfg.ReadTimeout = 10 * time.Second
 	}
@@ -36,17 +51,21 @@ func NewServer(cfg config.APIConfig, logger *log.Logger, database *db.Database,
 	}
 
 	s := &Server{
-		cfg:      cfg,
-		logger:   logger,
-		db:       database,
-		eventBus: eventBus,
-		router:   chi.NewRouter(),
+		cfg:        cfg,
+		gitHosts:   gitHosts,
+		logger:     logger,
+		db:         database,
+		eventBus:   eventBus,
+		pipeline:   pipeline,
+		deployer:   deployer,
+		supervisor: sup,
+		router:     chi.NewRouter(),
 	}
 
 	// Set up middleware
 	s.router.Use(middleware.Logger)
 	s.router.Use(middleware.Recoverer)
-	s.router.Use(middleware.Timeout(60 * time.Second))
+	s.router.Use(timeout(60 * time.Second))
 
 	// API routes
 	s.router.Route("/api", func(r chi.Router) {
@@ -60,18 +79,45 @@ func NewServer(cfg config.APIConfig, logger *log.Logger, database *db.Database,
 					r.Put("/", s.handleUpdateApp)
 					r.Delete("/", s.handleDeleteApp)
 					r.Post("/deploy", s.handleDeployApp)
+					r.Post("/rollback", s.handleRollbackApp)
 					r.Post("/start", s.handleStartApp)
 					r.Post("/stop", s.handleStopApp)
 					r.Post("/restart", s.handleRestartApp)
 					r.Get("/status", s.handleGetAppStatus)
+					r.Get("/healthcheck", s.handleGetHealthCheck)
+					r.Put("/healthcheck", s.handleSetHealthCheck)
+					r.Delete("/healthcheck", s.handleDeleteHealthCheck)
+					r.Get("/build-settings", s.handleGetBuildSettings)
+					r.Put("/build-settings", s.handleSetBuildSettings)
+					r.Delete("/build-settings", s.handleDeleteBuildSettings)
+					r.Get("/deploy-key", s.handleGetDeployKey)
+					r.Post("/deploy-key", s.handleRotateDeployKey)
+					r.Delete("/deploy-key", s.handleDeleteDeployKey)
 					r.Get("/logs", s.handleGetAppLogs)
 					r.Get("/deployments", s.handleListDeployments)
 					r.Get("/backups", s.handleListBackups)
 				})
 			})
 
-			// GitHub webhooks
-			r.Post("/webhooks/github", s.handleGitHubWebhook)
+			// Deployments
+			r.Route("/deployments/{deploymentID}", func(r chi.Router) {
+				r.Get("/", s.handleGetDeployment)
+				r.Post("/cancel", s.handleCancelDeployment)
+				r.Get("/logs", s.handleGetDeploymentLogs)
+			})
+
+			// Builds running and waiting for a worker
+			r.Get("/builds", s.handleGetBuildQueue)
+
+			// Webhooks
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Post("/github", s.handleWebhook(webhookProviders[deploy.ProviderGitHub]))
+				r.Post("/gitlab", s.handleWebhook(webhookProviders[deploy.ProviderGitLab]))
+				r.Post("/gitea", s.handleWebhook(webhookProviders[deploy.ProviderGitea]))
+				r.Post("/bitbucket", s.handleWebhook(webhookProviders[deploy.ProviderBitbucket]))
+				r.Get("/deliveries", s.handleListWebhookDeliveries)
+				r.Post("/deliveries/{deliveryID}/redeliver", s.handleRedeliverWebhook)
+			})
 		})
 	})
 
@@ -92,6 +138,11 @@ func NewServer(cfg config.APIConfig, logger *log.Logger, database *db.Database,
 
 // Start starts the API server
 func (s *Server) Start() error {
+	// Resume health checks of existing apps
+	if err := s.loadHealthChecks(context.Background()); err != nil {
+		s.logger.Printf("Failed to load health checks: %v", err)
+	}
+
 	addr := fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.Port)
 	s.logger.Printf("Starting API server on %s", addr)
 
@@ -120,6 +171,21 @@ func (s *Server) Stop() {
 
 // Helper functions
 
+// timeout applies a request timeout to everything except event streams,
+// which stay open for as long as the client follows them
+func timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		withTimeout := middleware.Timeout(d)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isEventStream(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			withTimeout.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (s *Server) respond(w http.ResponseWriter, r *http.Request, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -187,6 +253,11 @@ func (s *Server) handleCreateApp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Every app gets a deploy key to add to its repository if it is private
+	if _, err := s.pipeline.GenerateDeployKey(r.Context(), app.ID); err != nil {
+		s.logger.Printf("Error generating deploy key of app %s: %v", app.ID, err)
+	}
+
 	// Publish event
 	s.eventBus.Publish(events.Event{
 		Type:    events.AppDeployed,
@@ -249,6 +320,13 @@ func (s *Server) handleDeleteApp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.supervisor.RemoveHealthCheck(appID)
+
+	// Remove the build artifacts kept for later deployments of the app
+	if err := s.pipeline.RemoveBuilds(r.Context(), appID); err != nil {
+		s.logger.Printf("Error removing builds of app %s: %v", appID, err)
+	}
+
 	s.respond(w, r, nil, http.StatusNoContent)
 }
 
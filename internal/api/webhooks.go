@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/internal/deploy"
+	"github.com/danbruder/skyline/pkg/errors"
+	"github.com/go-chi/chi/v5"
+)
+
+// Webhook delivery outcomes
+const (
+	DeliveryRejected = "rejected"
+	DeliveryIgnored  = "ignored"
+	DeliveryNoMatch  = "no_match"
+	DeliveryDeployed = "deployed"
+	DeliveryFailed   = "failed"
+)
+
+// handleWebhook receives the webhook deliveries of a git provider
+func (s *Server) handleWebhook(provider *webhookProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		delivery := &db.WebhookDelivery{
+			Provider:   provider.Name,
+			DeliveryID: r.Header.Get(provider.DeliveryHeader),
+			Event:      r.Header.Get(provider.EventHeader),
+		}
+
+		// Get event type
+		if delivery.Event == "" {
+			s.respondError(w, r, fmt.Errorf("missing %s header", provider.EventHeader), http.StatusBadRequest)
+			return
+		}
+
+		// Read body
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.respondError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Verify the delivery; a provider without a secret cannot verify
+		// anything, so its deliveries are rejected rather than trusted
+		secret := s.webhookSecret(provider.Name)
+		if secret == "" || !provider.Verify(secret, r.Header, body) {
+			delivery.Outcome = DeliveryRejected
+			delivery.Message = fmt.Sprintf("invalid %s", provider.SecretHeader)
+			if secret == "" {
+				delivery.Message = fmt.Sprintf("no webhook_secret is configured for %s", provider.Name)
+			}
+			s.recordDelivery(r.Context(), delivery)
+
+			s.respondError(w, r, errors.ErrUnauthorized, http.StatusUnauthorized)
+			return
+		}
+
+		delivery.Payload = string(body)
+		s.processDelivery(r.Context(), provider, delivery)
+		s.recordDelivery(r.Context(), delivery)
+
+		s.respond(w, r, delivery, http.StatusOK)
+	}
+}
+
+// webhookSecret returns the webhook secret of a provider
+func (s *Server) webhookSecret(provider string) string {
+	switch provider {
+	case deploy.ProviderGitHub:
+		return s.gitHosts.GitHub.WebhookSecret
+	case deploy.ProviderGitLab:
+		return s.gitHosts.GitLab.WebhookSecret
+	case deploy.ProviderGitea:
+		return s.gitHosts.Gitea.WebhookSecret
+	case deploy.ProviderBitbucket:
+		return s.gitHosts.Bitbucket.WebhookSecret
+	}
+	return ""
+}
+
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if parsed, err := strconv.Atoi(limitParam); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	deliveries, err := s.db.ListWebhookDeliveries(r.Context(), limit)
+	if err != nil {
+		s.respondError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.respond(w, r, deliveries, http.StatusOK)
+}
+
+func (s *Server) handleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	deliveryID := chi.URLParam(r, "deliveryID")
+
+	original, err := s.db.GetWebhookDelivery(r.Context(), deliveryID)
+	if err != nil {
+		s.respondError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	// Rejected deliveries never passed verification, so their payload is
+	// not trusted and not stored
+	if original.Outcome == DeliveryRejected || original.Payload == "" {
+		s.respondError(w, r, fmt.Errorf("delivery %s cannot be redelivered", deliveryID), http.StatusConflict)
+		return
+	}
+
+	provider, ok := webhookProviders[original.Provider]
+	if !ok {
+		s.respondError(w, r, fmt.Errorf("delivery %s is of unknown provider %q", deliveryID, original.Provider), http.StatusConflict)
+		return
+	}
+
+	delivery := &db.WebhookDelivery{
+		Provider:     original.Provider,
+		DeliveryID:   original.DeliveryID,
+		Event:        original.Event,
+		Payload:      original.Payload,
+		RedeliveryOf: original.ID,
+	}
+	s.processDelivery(r.Context(), provider, delivery)
+	s.recordDelivery(r.Context(), delivery)
+
+	s.respond(w, r, delivery, http.StatusOK)
+}
+
+// processDelivery handles a verified delivery of a git provider and fills in
+// its outcome
+func (s *Server) processDelivery(ctx context.Context, provider *webhookProvider, delivery *db.WebhookDelivery) {
+	pushes, ignored, err := provider.Parse(delivery.Event, []byte(delivery.Payload))
+	if err != nil {
+		delivery.Outcome = DeliveryFailed
+		delivery.Message = fmt.Sprintf("invalid %s payload: %v", delivery.Event, err)
+		return
+	}
+	if ignored != "" {
+		delivery.Outcome = DeliveryIgnored
+		delivery.Message = ignored
+		return
+	}
+
+	// A delivery records the first branch it updates
+	delivery.RepoURL = pushes[0].RepoURL
+	delivery.Ref = "refs/heads/" + pushes[0].Branch
+	delivery.CommitSHA = pushes[0].CommitSHA
+
+	// Trigger deployments for apps that use this repository and branch
+	var branches []string
+	for _, push := range pushes {
+		push.Provider = provider.Name
+		push.Type = delivery.Event
+		deployments, err := s.pipeline.ProcessWebhook(ctx, push)
+		if err != nil {
+			delivery.Outcome = DeliveryFailed
+			delivery.Message = err.Error()
+			return
+		}
+
+		for _, deployment := range deployments {
+			delivery.DeploymentIDs = append(delivery.DeploymentIDs, deployment.ID)
+		}
+		branches = append(branches, push.Branch)
+	}
+
+	if len(delivery.DeploymentIDs) == 0 {
+		delivery.Outcome = DeliveryNoMatch
+		delivery.Message = fmt.Sprintf("no app deploys %s from branch %s", delivery.RepoURL, strings.Join(branches, ", "))
+		return
+	}
+
+	delivery.Outcome = DeliveryDeployed
+	delivery.Message = fmt.Sprintf("triggered %d deployment(s)", len(delivery.DeploymentIDs))
+}
+
+// recordDelivery stores a webhook delivery, logging rather than failing the
+// request when it cannot be stored
+func (s *Server) recordDelivery(ctx context.Context, delivery *db.WebhookDelivery) {
+	if delivery.DeploymentIDs == nil {
+		delivery.DeploymentIDs = make([]string, 0)
+	}
+
+	if err := s.db.CreateWebhookDelivery(ctx, delivery); err != nil {
+		s.logger.Printf("Error recording webhook delivery %s: %v", delivery.DeliveryID, err)
+	}
+}
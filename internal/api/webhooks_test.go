@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danbruder/skyline/internal/config"
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/internal/deploy"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+func TestVerifyGitHubSignature(t *testing.T) {
+	secret := "webhook-secret"
+	body := []byte(`{"ref":"refs/heads/main"}`)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	valid := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		signature string
+		body      []byte
+		expected  bool
+	}{
+		{
+			name:      "Valid signature",
+			signature: valid,
+			body:      body,
+			expected:  true,
+		},
+		{
+			name:      "Tampered body",
+			signature: valid,
+			body:      []byte(`{"ref":"refs/heads/evil"}`),
+			expected:  false,
+		},
+		{
+			name:      "Missing prefix",
+			signature: hex.EncodeToString(mac.Sum(nil)),
+			body:      body,
+			expected:  false,
+		},
+		{
+			name:      "Not hex",
+			signature: "sha256=zzzz",
+			body:      body,
+			expected:  false,
+		},
+		{
+			name:      "Empty signature",
+			signature: "",
+			body:      body,
+			expected:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyGitHubSignature(secret, tt.body, tt.signature); got != tt.expected {
+				t.Errorf("verifyGitHubSignature() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWebhookRequiresSecret(t *testing.T) {
+	database, err := db.New(context.Background(), filepath.Join(t.TempDir(), "skyline.db"),
+		errors.NewStandardLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	s := &Server{
+		gitHosts: config.GitHosts{GitLab: config.GitHostConfig{WebhookSecret: "webhook-secret"}},
+		logger:   log.New(io.Discard, "", 0),
+		db:       database,
+	}
+
+	body := `{"ref":"refs/heads/main"}`
+	mac := hmac.New(sha256.New, nil)
+	mac.Write([]byte(body))
+
+	tests := []struct {
+		provider string
+		header   http.Header
+		message  string
+	}{
+		{
+			provider: deploy.ProviderGitHub,
+			header: http.Header{
+				"X-Github-Event":      {"push"},
+				"X-Hub-Signature-256": {"sha256=" + hex.EncodeToString(mac.Sum(nil))},
+			},
+			message: "no webhook_secret is configured for github",
+		},
+		{
+			provider: deploy.ProviderGitLab,
+			header:   http.Header{"X-Gitlab-Event": {"Push Hook"}},
+			message:  "invalid X-Gitlab-Token",
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/webhooks/"+tt.provider, strings.NewReader(body))
+		req.Header = tt.header
+		rec := httptest.NewRecorder()
+
+		s.handleWebhook(webhookProviders[tt.provider])(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s delivery got status %d, want %d", tt.provider, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	deliveries, err := database.ListWebhookDeliveries(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("Failed to list deliveries: %v", err)
+	}
+	if len(deliveries) != len(tests) {
+		t.Fatalf("Recorded %d deliveries, want %d", len(deliveries), len(tests))
+	}
+	for _, delivery := range deliveries {
+		for _, tt := range tests {
+			if delivery.Provider == tt.provider && (delivery.Outcome != DeliveryRejected || delivery.Message != tt.message) {
+				t.Errorf("%s delivery recorded as %s: %q, want %s: %q",
+					tt.provider, delivery.Outcome, delivery.Message, DeliveryRejected, tt.message)
+			}
+		}
+	}
+}
@@ -16,7 +16,25 @@ type Config struct {
 	Proxy      ProxyConfig      `yaml:"proxy"`
 	Supervisor SupervisorConfig `yaml:"supervisor"`
 	Backup     BackupConfig     `yaml:"backup"`
-	GitHub     GitHubConfig     `yaml:"github"`
+	Deploy     DeployConfig     `yaml:"deploy"`
+	GitHosts   `yaml:",inline"`
+}
+
+// GitHosts contains the settings of the git providers apps are fetched from
+type GitHosts struct {
+	GitHub    GitHubConfig  `yaml:"github"`
+	GitLab    GitHostConfig `yaml:"gitlab"`
+	Gitea     GitHostConfig `yaml:"gitea"`
+	Bitbucket GitHostConfig `yaml:"bitbucket"`
+}
+
+// Secrets returns the tokens and secrets of the git providers
+func (h GitHosts) Secrets() []string {
+	secrets := []string{h.GitHub.Token, h.GitHub.WebhookSecret}
+	for _, host := range []GitHostConfig{h.GitLab, h.Gitea, h.Bitbucket} {
+		secrets = append(secrets, host.Token, host.WebhookSecret)
+	}
+	return secrets
 }
 
 // ServerConfig contains server configuration
@@ -49,6 +67,7 @@ type ProxyConfig struct {
 	AdminAPIPort  int           `yaml:"admin_api_port"`
 	AdminAPIAddr  string        `yaml:"admin_api_addr"`
 	ReloadTimeout time.Duration `yaml:"reload_timeout"`
+	StaticMaxAge  time.Duration `yaml:"static_max_age"`
 }
 
 // SupervisorConfig contains supervisor configuration
@@ -75,6 +94,50 @@ type BackupConfig struct {
 // GitHubConfig contains GitHub configuration
 type GitHubConfig struct {
 	WebhookSecret string `yaml:"webhook_secret"`
+	Token         string `yaml:"token"`
+}
+
+// GitHostConfig contains the settings of a git provider other than GitHub
+type GitHostConfig struct {
+	URL           string `yaml:"url"` // Base URL of a self-hosted instance; defaults to the hosted service
+	WebhookSecret string `yaml:"webhook_secret"`
+	Username      string `yaml:"username"` // User name tokens are sent with; defaults to the provider's
+	Token         string `yaml:"token"`
+}
+
+// DeployConfig contains deployment pipeline configuration
+type DeployConfig struct {
+	SourceDir      string        `yaml:"source_dir"`
+	BuildDir       string        `yaml:"build_dir"`
+	CacheDir       string        `yaml:"cache_dir"`
+	BuildpacksDir  string        `yaml:"buildpacks_dir"`
+	WheelCacheDir  string        `yaml:"wheel_cache_dir"`
+	PythonIndexURL string        `yaml:"python_index_url"`
+	DataDir        string        `yaml:"data_dir"`
+	Timeout        time.Duration `yaml:"timeout"`
+	BuildTimeout   time.Duration `yaml:"build_timeout"`
+	MaxBuilds      int           `yaml:"max_builds"`
+	FetchTimeout   time.Duration `yaml:"fetch_timeout"`
+	DeployTimeout  time.Duration `yaml:"deploy_timeout"`
+	KeepReleases   int           `yaml:"keep_releases"`
+	HealthTimeout  time.Duration `yaml:"health_timeout"`
+	DrainTimeout   time.Duration `yaml:"drain_timeout"`
+	SecretKeyFile  string        `yaml:"secret_key_file"`
+	KnownHosts     string        `yaml:"known_hosts_file"`
+	Sandbox        SandboxConfig `yaml:"sandbox"`
+}
+
+// SandboxConfig contains settings that confine builds
+type SandboxConfig struct {
+	User         string   `yaml:"user"`
+	AllowRoot    bool     `yaml:"allow_root"`
+	HomeDir      string   `yaml:"home_dir"`
+	CgroupDir    string   `yaml:"cgroup_dir"`
+	CPUs         float64  `yaml:"cpus"`
+	MemoryMB     int      `yaml:"memory_mb"`
+	MaxProcesses int      `yaml:"max_processes"`
+	Offline      bool     `yaml:"offline"`
+	PassEnv      []string `yaml:"pass_env"`
 }
 
 // Load loads configuration from a file
@@ -129,6 +192,39 @@ func Load(path string) (*Config, error) {
 	if config.Backup.RetentionPolicy == "" {
 		config.Backup.RetentionPolicy = "24h"
 	}
+	if config.Deploy.SourceDir == "" {
+		config.Deploy.SourceDir = "data/source"
+	}
+	if config.Deploy.BuildDir == "" {
+		config.Deploy.BuildDir = "data/builds"
+	}
+	if config.Deploy.CacheDir == "" {
+		config.Deploy.CacheDir = "data/build-cache"
+	}
+	if config.Deploy.BuildpacksDir == "" {
+		config.Deploy.BuildpacksDir = "data/buildpacks"
+	}
+	if config.Deploy.WheelCacheDir == "" {
+		config.Deploy.WheelCacheDir = "data/wheels"
+	}
+	if config.Deploy.Sandbox.HomeDir == "" {
+		config.Deploy.Sandbox.HomeDir = "data/build-home"
+	}
+	if config.Deploy.DataDir == "" {
+		config.Deploy.DataDir = "data/app-data"
+	}
+	if config.Deploy.SecretKeyFile == "" {
+		config.Deploy.SecretKeyFile = "data/system/secret.key"
+	}
+	if config.Deploy.KnownHosts == "" {
+		config.Deploy.KnownHosts = "data/system/known_hosts"
+	}
+	if config.Deploy.Timeout == 0 {
+		config.Deploy.Timeout = 15 * time.Minute
+	}
+	if config.Deploy.KeepReleases == 0 {
+		config.Deploy.KeepReleases = 5
+	}
 
 	return config, nil
 }
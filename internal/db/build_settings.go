@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// BuildSettings are per-app settings for building an app. Empty settings
+// fall back to what the builder detects.
+type BuildSettings struct {
+	AppID       string            `json:"app_id"`
+	MainPackage string            `json:"main_package,omitempty"` // Go main package, e.g. ./cmd/server
+	Tags        []string          `json:"tags,omitempty"`         // Go build tags
+	LDFlags     string            `json:"ldflags,omitempty"`      // Go linker flags
+	GoFlags     string            `json:"goflags,omitempty"`      // GOFLAGS of the build
+	CGOEnabled  *bool             `json:"cgo_enabled,omitempty"`  // Unset enables cgo only for apps that need it
+	Env         map[string]string `json:"env,omitempty"`          // Build-time environment variables
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// SetBuildSettings creates or replaces the build settings of an app
+func (d *Database) SetBuildSettings(ctx context.Context, settings *BuildSettings) error {
+	fields := errors.FieldMap{"app_id": settings.AppID}
+
+	settings.UpdatedAt = time.Now()
+
+	tags, err := json.Marshal(settings.Tags)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize build tags")
+	}
+	env, err := json.Marshal(settings.Env)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize build environment")
+	}
+
+	var cgoEnabled sql.NullBool
+	if settings.CGOEnabled != nil {
+		cgoEnabled = sql.NullBool{Bool: *settings.CGOEnabled, Valid: true}
+	}
+
+	_, err = d.sql.ExecContext(ctx, `
+		INSERT INTO build_settings (app_id, main_package, tags, ldflags, goflags,
+			cgo_enabled, env, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (app_id) DO UPDATE SET
+			main_package = excluded.main_package,
+			tags = excluded.tags,
+			ldflags = excluded.ldflags,
+			goflags = excluded.goflags,
+			cgo_enabled = excluded.cgo_enabled,
+			env = excluded.env,
+			updated_at = excluded.updated_at
+	`, settings.AppID, settings.MainPackage, string(tags), settings.LDFlags, settings.GoFlags,
+		cgoEnabled, string(env), settings.UpdatedAt)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to save build settings")
+		d.logger.Error(ctx, wrappedErr, "Build settings save failed", fields)
+		return wrappedErr
+	}
+
+	d.logger.Info(ctx, "Build settings saved successfully", fields)
+	return nil
+}
+
+// GetBuildSettings retrieves the build settings of an app
+func (d *Database) GetBuildSettings(ctx context.Context, appID string) (*BuildSettings, error) {
+	fields := errors.FieldMap{"app_id": appID}
+
+	row, err := d.sql.QueryRowContext(ctx, `
+		SELECT main_package, tags, ldflags, goflags, cgo_enabled, env, updated_at
+		FROM build_settings WHERE app_id = ?
+	`, appID)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to query build settings")
+		d.logger.Error(ctx, wrappedErr, "Build settings retrieval failed", fields)
+		return nil, wrappedErr
+	}
+
+	settings := &BuildSettings{AppID: appID}
+
+	var tags, env string
+	var cgoEnabled sql.NullBool
+	err = row.Scan(
+		&settings.MainPackage, &tags, &settings.LDFlags, &settings.GoFlags,
+		&cgoEnabled, &env, &settings.UpdatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			wrappedErr := errors.Wrap(errors.ErrRecordNotFound, "build settings not found")
+			d.logger.Debug(ctx, "Build settings not found", fields)
+			return nil, wrappedErr
+		}
+
+		wrappedErr := errors.Wrap(err, "failed to scan build settings row")
+		d.logger.Error(ctx, wrappedErr, "Build settings data scan failed", fields)
+		return nil, wrappedErr
+	}
+
+	if err := json.Unmarshal([]byte(tags), &settings.Tags); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to parse build tags")
+		d.logger.Error(ctx, wrappedErr, "Build settings data scan failed", fields)
+		return nil, wrappedErr
+	}
+	if err := json.Unmarshal([]byte(env), &settings.Env); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to parse build environment")
+		d.logger.Error(ctx, wrappedErr, "Build settings data scan failed", fields)
+		return nil, wrappedErr
+	}
+	if cgoEnabled.Valid {
+		settings.CGOEnabled = &cgoEnabled.Bool
+	}
+
+	return settings, nil
+}
+
+// DeleteBuildSettings removes the build settings of an app
+func (d *Database) DeleteBuildSettings(ctx context.Context, appID string) error {
+	fields := errors.FieldMap{"app_id": appID}
+
+	_, err := d.sql.ExecContext(ctx, `DELETE FROM build_settings WHERE app_id = ?`, appID)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to delete build settings")
+		d.logger.Error(ctx, wrappedErr, "Build settings deletion failed", fields)
+		return wrappedErr
+	}
+
+	d.logger.Info(ctx, "Build settings deleted successfully", fields)
+	return nil
+}
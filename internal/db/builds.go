@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/errors"
+	"github.com/google/uuid"
+)
+
+// Build represents a build artifact produced for a commit
+type Build struct {
+	ID           string    `json:"id"`
+	RepoURL      string    `json:"repo_url"`
+	CommitSHA    string    `json:"commit_sha"`
+	SettingsHash string    `json:"settings_hash"`
+	Type         string    `json:"type"`
+	ArtifactPath string    `json:"artifact_path"`
+	Checksum     string    `json:"checksum"` // SHA-256 of the artifact
+	Result       string    `json:"-"`        // Serialized build result
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateBuild records a build artifact
+func (d *Database) CreateBuild(ctx context.Context, build *Build) error {
+	// Generate ID if not provided
+	if build.ID == "" {
+		build.ID = uuid.New().String()
+	}
+
+	// Set timestamps if not provided
+	if build.CreatedAt.IsZero() {
+		build.CreatedAt = time.Now()
+	}
+
+	fields := errors.FieldMap{
+		"build_id":   build.ID,
+		"repo_url":   build.RepoURL,
+		"commit_sha": build.CommitSHA,
+	}
+
+	_, err := d.sql.ExecContext(ctx, `
+		INSERT INTO builds (id, repo_url, commit_sha, settings_hash, app_type, artifact_path,
+			checksum, result, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, build.ID, build.RepoURL, build.CommitSHA, build.SettingsHash, build.Type,
+		build.ArtifactPath, build.Checksum, build.Result, build.CreatedAt)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to insert build")
+		d.logger.Error(ctx, wrappedErr, "Build creation failed", fields)
+		return wrappedErr
+	}
+
+	d.logger.Info(ctx, "Build recorded successfully", fields)
+	return nil
+}
+
+// FindBuild retrieves the most recent build for a repository, commit and
+// build settings
+func (d *Database) FindBuild(ctx context.Context, repoURL, commitSHA, settingsHash string) (*Build, error) {
+	fields := errors.FieldMap{
+		"repo_url":      repoURL,
+		"commit_sha":    commitSHA,
+		"settings_hash": settingsHash,
+	}
+
+	row, err := d.sql.QueryRowContext(ctx, `
+		SELECT id, app_type, artifact_path, checksum, result, created_at
+		FROM builds WHERE repo_url = ? AND commit_sha = ? AND settings_hash = ?
+		ORDER BY created_at DESC LIMIT 1
+	`, repoURL, commitSHA, settingsHash)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to query build")
+		d.logger.Error(ctx, wrappedErr, "Build retrieval failed", fields)
+		return nil, wrappedErr
+	}
+
+	build := &Build{RepoURL: repoURL, CommitSHA: commitSHA, SettingsHash: settingsHash}
+
+	err = row.Scan(
+		&build.ID, &build.Type, &build.ArtifactPath, &build.Checksum,
+		&build.Result, &build.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			wrappedErr := errors.Wrap(errors.ErrRecordNotFound, "build not found")
+			d.logger.Debug(ctx, "Build not found", fields)
+			return nil, wrappedErr
+		}
+
+		wrappedErr := errors.Wrap(err, "failed to scan build row")
+		d.logger.Error(ctx, wrappedErr, "Build data scan failed", fields)
+		return nil, wrappedErr
+	}
+
+	d.logger.Debug(ctx, "Build retrieved successfully", fields)
+	return build, nil
+}
+
+// DeleteBuild removes the record of a build artifact
+func (d *Database) DeleteBuild(ctx context.Context, id string) error {
+	fields := errors.FieldMap{"build_id": id}
+
+	_, err := d.sql.ExecContext(ctx, `DELETE FROM builds WHERE id = ?`, id)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to delete build")
+		d.logger.Error(ctx, wrappedErr, "Build deletion failed", fields)
+		return wrappedErr
+	}
+
+	d.logger.Debug(ctx, "Build deleted successfully", fields)
+	return nil
+}
@@ -150,6 +150,25 @@ func (s *SQL) Migrate(ctx context.Context) error {
 		return wrappedErr
 	}
 
+	// Create deployment_logs table
+	_, err = tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS deployment_logs (
+			deployment_id TEXT NOT NULL,
+			seq INTEGER NOT NULL,
+			stream TEXT NOT NULL,
+			line TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (deployment_id, seq),
+			FOREIGN KEY (deployment_id) REFERENCES deployments(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		tx.Rollback()
+		wrappedErr := errors.Wrap(err, "failed to create deployment_logs table")
+		s.logger.Error(ctx, wrappedErr, "Migration failed", fields)
+		return wrappedErr
+	}
+
 	// Create backups table
 	_, err = tx.ExecContext(ctx, `
 		CREATE TABLE IF NOT EXISTS backups (
@@ -169,6 +188,62 @@ func (s *SQL) Migrate(ctx context.Context) error {
 		return wrappedErr
 	}
 
+	// Create webhook_deliveries table
+	_, err = tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			delivery_id TEXT NOT NULL,
+			event TEXT NOT NULL,
+			repo_url TEXT NOT NULL,
+			ref TEXT NOT NULL,
+			commit_sha TEXT NOT NULL,
+			outcome TEXT NOT NULL,
+			message TEXT,
+			deployment_ids TEXT,
+			payload TEXT,
+			redelivery_of TEXT,
+			received_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		tx.Rollback()
+		wrappedErr := errors.Wrap(err, "failed to create webhook_deliveries table")
+		s.logger.Error(ctx, wrappedErr, "Migration failed", fields)
+		return wrappedErr
+	}
+
+	// Create builds table
+	_, err = tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS builds (
+			id TEXT PRIMARY KEY,
+			repo_url TEXT NOT NULL,
+			commit_sha TEXT NOT NULL,
+			settings_hash TEXT NOT NULL,
+			app_type TEXT NOT NULL,
+			artifact_path TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			result TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		tx.Rollback()
+		wrappedErr := errors.Wrap(err, "failed to create builds table")
+		s.logger.Error(ctx, wrappedErr, "Migration failed", fields)
+		return wrappedErr
+	}
+
+	// Create index on build keys for artifact lookups
+	_, err = tx.ExecContext(ctx, `
+		CREATE INDEX IF NOT EXISTS idx_builds_key ON builds(repo_url, commit_sha, settings_hash)
+	`)
+	if err != nil {
+		tx.Rollback()
+		wrappedErr := errors.Wrap(err, "failed to create index")
+		s.logger.Error(ctx, wrappedErr, "Migration failed", fields)
+		return wrappedErr
+	}
+
 	// Create index on app_id in deployments for faster lookups
 	_, err = tx.ExecContext(ctx, `
 		CREATE INDEX IF NOT EXISTS idx_deployments_app_id ON deployments(app_id)
@@ -191,6 +266,108 @@ func (s *SQL) Migrate(ctx context.Context) error {
 		return wrappedErr
 	}
 
+	// Create health_checks table
+	_, err = tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS health_checks (
+			app_id TEXT PRIMARY KEY,
+			type TEXT NOT NULL,
+			path TEXT,
+			expected_status INTEGER,
+			interval_seconds INTEGER NOT NULL,
+			timeout_seconds INTEGER NOT NULL,
+			failure_threshold INTEGER NOT NULL,
+			grace_period_seconds INTEGER NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (app_id) REFERENCES apps(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		tx.Rollback()
+		wrappedErr := errors.Wrap(err, "failed to create health_checks table")
+		s.logger.Error(ctx, wrappedErr, "Migration failed", fields)
+		return wrappedErr
+	}
+
+	// Create build_provenance table
+	_, err = tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS build_provenance (
+			deployment_id TEXT PRIMARY KEY,
+			build_id TEXT NOT NULL,
+			commit_sha TEXT NOT NULL,
+			app_type TEXT NOT NULL,
+			toolchain TEXT NOT NULL,
+			build_flags TEXT NOT NULL,
+			duration_ms INTEGER NOT NULL,
+			artifact_sha256 TEXT NOT NULL,
+			artifact_size INTEGER NOT NULL,
+			reused BOOLEAN NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (deployment_id) REFERENCES deployments(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		tx.Rollback()
+		wrappedErr := errors.Wrap(err, "failed to create build_provenance table")
+		s.logger.Error(ctx, wrappedErr, "Migration failed", fields)
+		return wrappedErr
+	}
+
+	// Create build_settings table
+	_, err = tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS build_settings (
+			app_id TEXT PRIMARY KEY,
+			main_package TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT 'null',
+			ldflags TEXT NOT NULL DEFAULT '',
+			goflags TEXT NOT NULL DEFAULT '',
+			cgo_enabled BOOLEAN,
+			env TEXT NOT NULL DEFAULT 'null',
+			updated_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (app_id) REFERENCES apps(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		tx.Rollback()
+		wrappedErr := errors.Wrap(err, "failed to create build_settings table")
+		s.logger.Error(ctx, wrappedErr, "Migration failed", fields)
+		return wrappedErr
+	}
+
+	// Create deploy_keys table
+	_, err = tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS deploy_keys (
+			app_id TEXT PRIMARY KEY,
+			public_key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			encrypted_private_key BLOB NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (app_id) REFERENCES apps(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		tx.Rollback()
+		wrappedErr := errors.Wrap(err, "failed to create deploy_keys table")
+		s.logger.Error(ctx, wrappedErr, "Migration failed", fields)
+		return wrappedErr
+	}
+
+	// Add columns introduced after the tables were first created
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"deployments", "type", "TEXT NOT NULL DEFAULT 'deploy'"},
+		{"deployments", "release_id", "TEXT NOT NULL DEFAULT ''"},
+		{"webhook_deliveries", "provider", "TEXT NOT NULL DEFAULT 'github'"},
+	}
+	for _, c := range columns {
+		if err := addColumn(ctx, tx, c.table, c.column, c.definition); err != nil {
+			tx.Rollback()
+			wrappedErr := errors.Wrap(err, fmt.Sprintf("failed to add %s.%s column", c.table, c.column))
+			s.logger.Error(ctx, wrappedErr, "Migration failed", fields)
+			return wrappedErr
+		}
+	}
+
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
 		wrappedErr := errors.Wrap(err, "failed to commit transaction")
@@ -283,6 +460,38 @@ func (s *SQL) ExecContext(ctx context.Context, query string, args ...interface{}
 	return result, nil
 }
 
+// addColumn adds a column to a table unless it already exists
+func addColumn(ctx context.Context, tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name, kind string
+			notNull    int
+			dflt       sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 // Helper method to clean query strings for logging
 func cleanQueryForLog(query string) string {
 	// Replace newlines with spaces and collapse multiple spaces
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/errors"
+	"github.com/google/uuid"
+)
+
+// WebhookDelivery represents a received webhook delivery
+type WebhookDelivery struct {
+	ID            string    `json:"id"`
+	Provider      string    `json:"provider"` // github, gitlab, gitea or bitbucket
+	DeliveryID    string    `json:"delivery_id"`
+	Event         string    `json:"event"`
+	RepoURL       string    `json:"repo_url"`
+	Ref           string    `json:"ref"`
+	CommitSHA     string    `json:"commit_sha"`
+	Outcome       string    `json:"outcome"` // rejected, ignored, no_match, deployed, failed
+	Message       string    `json:"message"`
+	DeploymentIDs []string  `json:"deployment_ids"`
+	Payload       string    `json:"-"`
+	RedeliveryOf  string    `json:"redelivery_of,omitempty"`
+	ReceivedAt    time.Time `json:"received_at"`
+}
+
+// CreateWebhookDelivery records a webhook delivery
+func (d *Database) CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	// Generate ID if not provided
+	if delivery.ID == "" {
+		delivery.ID = uuid.New().String()
+	}
+
+	// Set timestamps if not provided
+	if delivery.ReceivedAt.IsZero() {
+		delivery.ReceivedAt = time.Now()
+	}
+
+	fields := errors.FieldMap{"delivery_id": delivery.DeliveryID, "webhook_delivery_id": delivery.ID}
+
+	_, err := d.sql.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (id, provider, delivery_id, event, repo_url, ref, commit_sha, outcome,
+			message, deployment_ids, payload, redelivery_of, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, delivery.ID, delivery.Provider, delivery.DeliveryID, delivery.Event, delivery.RepoURL, delivery.Ref,
+		delivery.CommitSHA, delivery.Outcome, delivery.Message,
+		strings.Join(delivery.DeploymentIDs, ","), delivery.Payload, delivery.RedeliveryOf,
+		delivery.ReceivedAt)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to insert webhook delivery")
+		d.logger.Error(ctx, wrappedErr, "Webhook delivery creation failed", fields)
+		return wrappedErr
+	}
+
+	d.logger.Debug(ctx, "Webhook delivery recorded", fields)
+	return nil
+}
+
+// GetWebhookDelivery retrieves a webhook delivery by ID
+func (d *Database) GetWebhookDelivery(ctx context.Context, id string) (*WebhookDelivery, error) {
+	fields := errors.FieldMap{"webhook_delivery_id": id}
+
+	row, err := d.sql.QueryRowContext(ctx, `
+		SELECT id, provider, delivery_id, event, repo_url, ref, commit_sha, outcome,
+			message, deployment_ids, payload, redelivery_of, received_at
+		FROM webhook_deliveries WHERE id = ?
+	`, id)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to query webhook delivery")
+		d.logger.Error(ctx, wrappedErr, "Webhook delivery retrieval failed", fields)
+		return nil, wrappedErr
+	}
+
+	delivery, err := scanWebhookDelivery(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			wrappedErr := errors.Wrap(errors.ErrRecordNotFound, "webhook delivery not found")
+			d.logger.Debug(ctx, "Webhook delivery not found", fields)
+			return nil, wrappedErr
+		}
+
+		wrappedErr := errors.Wrap(err, "failed to scan webhook delivery row")
+		d.logger.Error(ctx, wrappedErr, "Webhook delivery data scan failed", fields)
+		return nil, wrappedErr
+	}
+
+	return delivery, nil
+}
+
+// ListWebhookDeliveries lists the most recent webhook deliveries
+func (d *Database) ListWebhookDeliveries(ctx context.Context, limit int) ([]*WebhookDelivery, error) {
+	fields := errors.FieldMap{"limit": limit}
+
+	rows, err := d.sql.QueryContext(ctx, `
+		SELECT id, provider, delivery_id, event, repo_url, ref, commit_sha, outcome,
+			message, deployment_ids, payload, redelivery_of, received_at
+		FROM webhook_deliveries ORDER BY received_at DESC LIMIT ?
+	`, limit)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to query webhook deliveries")
+		d.logger.Error(ctx, wrappedErr, "Webhook deliveries listing failed", fields)
+		return nil, wrappedErr
+	}
+	defer rows.Close()
+
+	deliveries := make([]*WebhookDelivery, 0)
+
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			wrappedErr := errors.Wrap(err, "failed to scan webhook delivery row")
+			d.logger.Error(ctx, wrappedErr, "Webhook delivery scan failed", fields)
+			return nil, wrappedErr
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		wrappedErr := errors.Wrap(err, "error iterating webhook deliveries")
+		d.logger.Error(ctx, wrappedErr, "Webhook deliveries iteration failed", fields)
+		return nil, wrappedErr
+	}
+
+	d.logger.Debug(ctx, "Webhook deliveries listed successfully",
+		errors.WithField(fields, "count", len(deliveries)))
+	return deliveries, nil
+}
+
+// scanWebhookDelivery scans a webhook delivery from a row
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	var message, deploymentIDs, payload, redeliveryOf sql.NullString
+
+	if err := row.Scan(
+		&delivery.ID, &delivery.Provider, &delivery.DeliveryID, &delivery.Event, &delivery.RepoURL, &delivery.Ref,
+		&delivery.CommitSHA, &delivery.Outcome, &message, &deploymentIDs, &payload,
+		&redeliveryOf, &delivery.ReceivedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	delivery.Message = message.String
+	delivery.Payload = payload.String
+	delivery.RedeliveryOf = redeliveryOf.String
+	delivery.DeploymentIDs = make([]string, 0)
+	if deploymentIDs.String != "" {
+		delivery.DeploymentIDs = strings.Split(deploymentIDs.String, ",")
+	}
+
+	return delivery, nil
+}
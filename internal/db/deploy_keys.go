@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// DeployKey is the SSH key an app's repository is fetched with. The private
+// key is stored encrypted and never leaves the server.
+type DeployKey struct {
+	AppID               string    `json:"app_id"`
+	PublicKey           string    `json:"public_key"`  // authorized_keys line to add to the repository
+	Fingerprint         string    `json:"fingerprint"` // SHA256 fingerprint as shown by ssh-keygen -l
+	EncryptedPrivateKey []byte    `json:"-"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// SetDeployKey creates or replaces the deploy key of an app
+func (d *Database) SetDeployKey(ctx context.Context, key *DeployKey) error {
+	fields := errors.FieldMap{"app_id": key.AppID, "fingerprint": key.Fingerprint}
+
+	key.CreatedAt = time.Now()
+
+	_, err := d.sql.ExecContext(ctx, `
+		INSERT INTO deploy_keys (app_id, public_key, fingerprint, encrypted_private_key, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (app_id) DO UPDATE SET
+			public_key = excluded.public_key,
+			fingerprint = excluded.fingerprint,
+			encrypted_private_key = excluded.encrypted_private_key,
+			created_at = excluded.created_at
+	`, key.AppID, key.PublicKey, key.Fingerprint, key.EncryptedPrivateKey, key.CreatedAt)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to save deploy key")
+		d.logger.Error(ctx, wrappedErr, "Deploy key save failed", fields)
+		return wrappedErr
+	}
+
+	d.logger.Info(ctx, "Deploy key saved successfully", fields)
+	return nil
+}
+
+// GetDeployKey retrieves the deploy key of an app
+func (d *Database) GetDeployKey(ctx context.Context, appID string) (*DeployKey, error) {
+	fields := errors.FieldMap{"app_id": appID}
+
+	row, err := d.sql.QueryRowContext(ctx, `
+		SELECT public_key, fingerprint, encrypted_private_key, created_at
+		FROM deploy_keys WHERE app_id = ?
+	`, appID)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to query deploy key")
+		d.logger.Error(ctx, wrappedErr, "Deploy key retrieval failed", fields)
+		return nil, wrappedErr
+	}
+
+	key := &DeployKey{AppID: appID}
+	err = row.Scan(&key.PublicKey, &key.Fingerprint, &key.EncryptedPrivateKey, &key.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			wrappedErr := errors.Wrap(errors.ErrRecordNotFound, "deploy key not found")
+			d.logger.Debug(ctx, "Deploy key not found", fields)
+			return nil, wrappedErr
+		}
+
+		wrappedErr := errors.Wrap(err, "failed to scan deploy key row")
+		d.logger.Error(ctx, wrappedErr, "Deploy key data scan failed", fields)
+		return nil, wrappedErr
+	}
+
+	return key, nil
+}
+
+// DeleteDeployKey removes the deploy key of an app
+func (d *Database) DeleteDeployKey(ctx context.Context, appID string) error {
+	fields := errors.FieldMap{"app_id": appID}
+
+	_, err := d.sql.ExecContext(ctx, `DELETE FROM deploy_keys WHERE app_id = ?`, appID)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to delete deploy key")
+		d.logger.Error(ctx, wrappedErr, "Deploy key deletion failed", fields)
+		return wrappedErr
+	}
+
+	d.logger.Info(ctx, "Deploy key deleted successfully", fields)
+	return nil
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// DeploymentLogLine represents a single line of deployment output
+type DeploymentLogLine struct {
+	DeploymentID string    `json:"deployment_id"`
+	Seq          int       `json:"seq"`
+	Stream       string    `json:"stream"` // stdout, stderr, system
+	Line         string    `json:"line"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AppendDeploymentLog stores a line of deployment output
+func (d *Database) AppendDeploymentLog(ctx context.Context, line *DeploymentLogLine) error {
+	fields := errors.FieldMap{"deployment_id": line.DeploymentID, "seq": line.Seq}
+
+	// Set timestamps if not provided
+	if line.CreatedAt.IsZero() {
+		line.CreatedAt = time.Now()
+	}
+
+	_, err := d.sql.ExecContext(ctx, `
+		INSERT INTO deployment_logs (deployment_id, seq, stream, line, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, line.DeploymentID, line.Seq, line.Stream, line.Line, line.CreatedAt)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to insert deployment log line")
+		d.logger.Error(ctx, wrappedErr, "Deployment log append failed", fields)
+		return wrappedErr
+	}
+
+	return nil
+}
+
+// ListDeploymentLogs lists the output lines of a deployment after a sequence
+// number, in order
+func (d *Database) ListDeploymentLogs(ctx context.Context, deploymentID string, afterSeq, limit int) ([]*DeploymentLogLine, error) {
+	fields := errors.FieldMap{"deployment_id": deploymentID, "after_seq": afterSeq}
+
+	rows, err := d.sql.QueryContext(ctx, `
+		SELECT seq, stream, line, created_at
+		FROM deployment_logs WHERE deployment_id = ? AND seq > ?
+		ORDER BY seq LIMIT ?
+	`, deploymentID, afterSeq, limit)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to query deployment logs")
+		d.logger.Error(ctx, wrappedErr, "Deployment logs listing failed", fields)
+		return nil, wrappedErr
+	}
+	defer rows.Close()
+
+	lines := make([]*DeploymentLogLine, 0)
+
+	for rows.Next() {
+		line := &DeploymentLogLine{DeploymentID: deploymentID}
+
+		if err := rows.Scan(&line.Seq, &line.Stream, &line.Line, &line.CreatedAt); err != nil {
+			wrappedErr := errors.Wrap(err, "failed to scan deployment log row")
+			d.logger.Error(ctx, wrappedErr, "Deployment log scan failed", fields)
+			return nil, wrappedErr
+		}
+
+		lines = append(lines, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		wrappedErr := errors.Wrap(err, "error iterating deployment logs")
+		d.logger.Error(ctx, wrappedErr, "Deployment logs iteration failed", fields)
+		return nil, wrappedErr
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// Health check types
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+)
+
+// HealthCheck represents the health check configuration of an app
+type HealthCheck struct {
+	AppID              string    `json:"app_id"`
+	Type               string    `json:"type"` // http, tcp
+	Path               string    `json:"path,omitempty"`
+	ExpectedStatus     int       `json:"expected_status,omitempty"`
+	IntervalSeconds    int       `json:"interval_seconds"`
+	TimeoutSeconds     int       `json:"timeout_seconds"`
+	FailureThreshold   int       `json:"failure_threshold"`
+	GracePeriodSeconds int       `json:"grace_period_seconds"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// SetHealthCheck creates or replaces the health check of an app
+func (d *Database) SetHealthCheck(ctx context.Context, check *HealthCheck) error {
+	fields := errors.FieldMap{"app_id": check.AppID, "type": check.Type}
+
+	check.UpdatedAt = time.Now()
+
+	_, err := d.sql.ExecContext(ctx, `
+		INSERT INTO health_checks (app_id, type, path, expected_status, interval_seconds,
+			timeout_seconds, failure_threshold, grace_period_seconds, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (app_id) DO UPDATE SET
+			type = excluded.type,
+			path = excluded.path,
+			expected_status = excluded.expected_status,
+			interval_seconds = excluded.interval_seconds,
+			timeout_seconds = excluded.timeout_seconds,
+			failure_threshold = excluded.failure_threshold,
+			grace_period_seconds = excluded.grace_period_seconds,
+			updated_at = excluded.updated_at
+	`, check.AppID, check.Type, check.Path, check.ExpectedStatus, check.IntervalSeconds,
+		check.TimeoutSeconds, check.FailureThreshold, check.GracePeriodSeconds, check.UpdatedAt)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to save health check")
+		d.logger.Error(ctx, wrappedErr, "Health check save failed", fields)
+		return wrappedErr
+	}
+
+	d.logger.Info(ctx, "Health check saved successfully", fields)
+	return nil
+}
+
+// GetHealthCheck retrieves the health check of an app
+func (d *Database) GetHealthCheck(ctx context.Context, appID string) (*HealthCheck, error) {
+	fields := errors.FieldMap{"app_id": appID}
+
+	row, err := d.sql.QueryRowContext(ctx, `
+		SELECT type, path, expected_status, interval_seconds, timeout_seconds,
+			failure_threshold, grace_period_seconds, updated_at
+		FROM health_checks WHERE app_id = ?
+	`, appID)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to query health check")
+		d.logger.Error(ctx, wrappedErr, "Health check retrieval failed", fields)
+		return nil, wrappedErr
+	}
+
+	check := &HealthCheck{AppID: appID}
+
+	var path sql.NullString
+	var expectedStatus sql.NullInt64
+	err = row.Scan(
+		&check.Type, &path, &expectedStatus, &check.IntervalSeconds, &check.TimeoutSeconds,
+		&check.FailureThreshold, &check.GracePeriodSeconds, &check.UpdatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			wrappedErr := errors.Wrap(errors.ErrRecordNotFound, "health check not found")
+			d.logger.Debug(ctx, "Health check not found", fields)
+			return nil, wrappedErr
+		}
+
+		wrappedErr := errors.Wrap(err, "failed to scan health check row")
+		d.logger.Error(ctx, wrappedErr, "Health check data scan failed", fields)
+		return nil, wrappedErr
+	}
+
+	check.Path = path.String
+	check.ExpectedStatus = int(expectedStatus.Int64)
+
+	return check, nil
+}
+
+// ListHealthChecks lists the health checks of all apps
+func (d *Database) ListHealthChecks(ctx context.Context) ([]*HealthCheck, error) {
+	fields := errors.FieldMap{}
+
+	rows, err := d.sql.QueryContext(ctx, `
+		SELECT app_id, type, path, expected_status, interval_seconds, timeout_seconds,
+			failure_threshold, grace_period_seconds, updated_at
+		FROM health_checks
+	`)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to query health checks")
+		d.logger.Error(ctx, wrappedErr, "Health checks listing failed", fields)
+		return nil, wrappedErr
+	}
+	defer rows.Close()
+
+	checks := make([]*HealthCheck, 0)
+
+	for rows.Next() {
+		check := &HealthCheck{}
+
+		var path sql.NullString
+		var expectedStatus sql.NullInt64
+		if err := rows.Scan(
+			&check.AppID, &check.Type, &path, &expectedStatus, &check.IntervalSeconds,
+			&check.TimeoutSeconds, &check.FailureThreshold, &check.GracePeriodSeconds, &check.UpdatedAt,
+		); err != nil {
+			wrappedErr := errors.Wrap(err, "failed to scan health check row")
+			d.logger.Error(ctx, wrappedErr, "Health check scan failed", fields)
+			return nil, wrappedErr
+		}
+
+		check.Path = path.String
+		check.ExpectedStatus = int(expectedStatus.Int64)
+		checks = append(checks, check)
+	}
+
+	if err := rows.Err(); err != nil {
+		wrappedErr := errors.Wrap(err, "error iterating health checks")
+		d.logger.Error(ctx, wrappedErr, "Health checks iteration failed", fields)
+		return nil, wrappedErr
+	}
+
+	return checks, nil
+}
+
+// DeleteHealthCheck removes the health check of an app
+func (d *Database) DeleteHealthCheck(ctx context.Context, appID string) error {
+	fields := errors.FieldMap{"app_id": appID}
+
+	_, err := d.sql.ExecContext(ctx, `DELETE FROM health_checks WHERE app_id = ?`, appID)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to delete health check")
+		d.logger.Error(ctx, wrappedErr, "Health check deletion failed", fields)
+		return wrappedErr
+	}
+
+	d.logger.Info(ctx, "Health check deleted successfully", fields)
+	return nil
+}
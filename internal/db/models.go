@@ -32,12 +32,20 @@ type EnvVar struct {
 	Value string `json:"value"`
 }
 
+// Deployment types
+const (
+	DeploymentTypeDeploy   = "deploy"
+	DeploymentTypeRollback = "rollback"
+)
+
 // Deployment represents a deployment of an app
 type Deployment struct {
 	ID        string    `json:"id"`
 	AppID     string    `json:"app_id"`
+	Type      string    `json:"type"` // deploy, rollback
+	ReleaseID string    `json:"release_id"`
 	CommitSHA string    `json:"commit_sha"`
-	Status    string    `json:"status"` // pending, success, failed
+	Status    string    `json:"status"` // pending, in_progress, success, failed, cancelled, superseded
 	Logs      string    `json:"logs"`
 	StartedAt time.Time `json:"started_at"`
 	EndedAt   time.Time `json:"ended_at"`
@@ -416,11 +424,19 @@ func (d *Database) CreateDeployment(ctx context.Context, deployment *Deployment)
 		deployment.Status = "pending"
 	}
 
+	// Deploys produce a release of their own, rollbacks reuse one
+	if deployment.Type == "" {
+		deployment.Type = DeploymentTypeDeploy
+	}
+	if deployment.ReleaseID == "" && deployment.Type == DeploymentTypeDeploy {
+		deployment.ReleaseID = deployment.ID
+	}
+
 	_, err := d.sql.ExecContext(ctx, `
-		INSERT INTO deployments (id, app_id, commit_sha, status, logs, started_at, ended_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, deployment.ID, deployment.AppID, deployment.CommitSHA, deployment.Status,
-		deployment.Logs, deployment.StartedAt, deployment.EndedAt)
+		INSERT INTO deployments (id, app_id, type, release_id, commit_sha, status, logs, started_at, ended_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, deployment.ID, deployment.AppID, deployment.Type, deployment.ReleaseID, deployment.CommitSHA,
+		deployment.Status, deployment.Logs, deployment.StartedAt, deployment.EndedAt)
 
 	if err != nil {
 		wrappedErr := errors.Wrap(err, "failed to insert deployment")
@@ -469,7 +485,7 @@ func (d *Database) GetDeployment(ctx context.Context, id string) (*Deployment, e
 	fields := errors.FieldMap{"deployment_id": id}
 
 	row, err := d.sql.QueryRowContext(ctx, `
-		SELECT app_id, commit_sha, status, logs, started_at, ended_at
+		SELECT app_id, type, release_id, commit_sha, status, logs, started_at, ended_at
 		FROM deployments WHERE id = ?
 	`, id)
 
@@ -482,8 +498,8 @@ func (d *Database) GetDeployment(ctx context.Context, id string) (*Deployment, e
 	deployment := &Deployment{ID: id}
 
 	err = row.Scan(
-		&deployment.AppID, &deployment.CommitSHA, &deployment.Status,
-		&deployment.Logs, &deployment.StartedAt, &deployment.EndedAt,
+		&deployment.AppID, &deployment.Type, &deployment.ReleaseID, &deployment.CommitSHA,
+		&deployment.Status, &deployment.Logs, &deployment.StartedAt, &deployment.EndedAt,
 	)
 
 	if err != nil {
@@ -507,7 +523,7 @@ func (d *Database) ListDeployments(ctx context.Context, appID string) ([]*Deploy
 	fields := errors.FieldMap{"app_id": appID}
 
 	rows, err := d.sql.QueryContext(ctx, `
-		SELECT id, commit_sha, status, logs, started_at, ended_at
+		SELECT id, type, release_id, commit_sha, status, logs, started_at, ended_at
 		FROM deployments WHERE app_id = ? ORDER BY started_at DESC
 	`, appID)
 
@@ -524,8 +540,8 @@ func (d *Database) ListDeployments(ctx context.Context, appID string) ([]*Deploy
 		deployment := &Deployment{AppID: appID}
 
 		if err := rows.Scan(
-			&deployment.ID, &deployment.CommitSHA, &deployment.Status,
-			&deployment.Logs, &deployment.StartedAt, &deployment.EndedAt,
+			&deployment.ID, &deployment.Type, &deployment.ReleaseID, &deployment.CommitSHA,
+			&deployment.Status, &deployment.Logs, &deployment.StartedAt, &deployment.EndedAt,
 		); err != nil {
 			wrappedErr := errors.Wrap(err, "failed to scan deployment row")
 			d.logger.Error(ctx, wrappedErr, "Deployment scan failed", fields)
@@ -546,6 +562,34 @@ func (d *Database) ListDeployments(ctx context.Context, appID string) ([]*Deploy
 	return deployments, nil
 }
 
+// FailUnfinishedDeployments marks deployments that are still pending or in
+// progress as failed. Deployments only run in the process that queued them,
+// so at startup these were interrupted by a restart and will never finish.
+func (d *Database) FailUnfinishedDeployments(ctx context.Context, logs string) (int64, error) {
+	fields := errors.FieldMap{}
+
+	result, err := d.sql.ExecContext(ctx, `
+		UPDATE deployments SET status = 'failed', logs = ?, ended_at = ?
+		WHERE status IN ('pending', 'in_progress')
+	`, logs, time.Now())
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to update unfinished deployments")
+		d.logger.Error(ctx, wrappedErr, "Unfinished deployments update failed", fields)
+		return 0, wrappedErr
+	}
+
+	failed, err := result.RowsAffected()
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to get rows affected")
+		d.logger.Error(ctx, wrappedErr, "Rows affected check failed", fields)
+		return 0, wrappedErr
+	}
+
+	d.logger.Debug(ctx, "Unfinished deployments failed", errors.WithField(fields, "count", failed))
+	return failed, nil
+}
+
 // CreateBackup creates a new backup
 func (d *Database) CreateBackup(ctx context.Context, backup *Backup) error {
 	fields := errors.FieldMap{"app_id": backup.AppID, "backup_id": backup.ID}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// Provenance records how the code a deployment installed was built, so it
+// can be proven which code is running
+type Provenance struct {
+	DeploymentID   string    `json:"deployment_id"`
+	BuildID        string    `json:"build_id"`
+	CommitSHA      string    `json:"commit_sha"`
+	AppType        string    `json:"app_type"`
+	Toolchain      string    `json:"toolchain"`   // e.g. go version go1.21.0 linux/amd64
+	BuildFlags     []string  `json:"build_flags"` // Flags and settings the compiler ran with
+	DurationMS     int64     `json:"duration_ms"`
+	ArtifactSHA256 string    `json:"artifact_sha256"`
+	ArtifactSize   int64     `json:"artifact_size"`
+	Reused         bool      `json:"reused"` // The artifact of an earlier build was deployed
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// CreateProvenance records the provenance of a deployment
+func (d *Database) CreateProvenance(ctx context.Context, provenance *Provenance) error {
+	fields := errors.FieldMap{
+		"deployment_id": provenance.DeploymentID,
+		"build_id":      provenance.BuildID,
+	}
+
+	if provenance.CreatedAt.IsZero() {
+		provenance.CreatedAt = time.Now()
+	}
+
+	flags, err := json.Marshal(provenance.BuildFlags)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize build flags")
+	}
+
+	_, err = d.sql.ExecContext(ctx, `
+		INSERT INTO build_provenance (deployment_id, build_id, commit_sha, app_type, toolchain,
+			build_flags, duration_ms, artifact_sha256, artifact_size, reused, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, provenance.DeploymentID, provenance.BuildID, provenance.CommitSHA, provenance.AppType,
+		provenance.Toolchain, string(flags), provenance.DurationMS, provenance.ArtifactSHA256,
+		provenance.ArtifactSize, provenance.Reused, provenance.CreatedAt)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to insert build provenance")
+		d.logger.Error(ctx, wrappedErr, "Build provenance creation failed", fields)
+		return wrappedErr
+	}
+
+	d.logger.Info(ctx, "Build provenance recorded successfully", fields)
+	return nil
+}
+
+// GetProvenance retrieves the provenance of a deployment
+func (d *Database) GetProvenance(ctx context.Context, deploymentID string) (*Provenance, error) {
+	fields := errors.FieldMap{"deployment_id": deploymentID}
+
+	row, err := d.sql.QueryRowContext(ctx, `
+		SELECT build_id, commit_sha, app_type, toolchain, build_flags, duration_ms,
+			artifact_sha256, artifact_size, reused, created_at
+		FROM build_provenance WHERE deployment_id = ?
+	`, deploymentID)
+
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to query build provenance")
+		d.logger.Error(ctx, wrappedErr, "Build provenance retrieval failed", fields)
+		return nil, wrappedErr
+	}
+
+	provenance := &Provenance{DeploymentID: deploymentID}
+
+	var flags string
+	err = row.Scan(
+		&provenance.BuildID, &provenance.CommitSHA, &provenance.AppType, &provenance.Toolchain,
+		&flags, &provenance.DurationMS, &provenance.ArtifactSHA256, &provenance.ArtifactSize,
+		&provenance.Reused, &provenance.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			wrappedErr := errors.Wrap(errors.ErrRecordNotFound, "build provenance not found")
+			d.logger.Debug(ctx, "Build provenance not found", fields)
+			return nil, wrappedErr
+		}
+
+		wrappedErr := errors.Wrap(err, "failed to scan build provenance row")
+		d.logger.Error(ctx, wrappedErr, "Build provenance data scan failed", fields)
+		return nil, wrappedErr
+	}
+
+	if err := json.Unmarshal([]byte(flags), &provenance.BuildFlags); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to parse build flags")
+		d.logger.Error(ctx, wrappedErr, "Build provenance data scan failed", fields)
+		return nil, wrappedErr
+	}
+
+	return provenance, nil
+}
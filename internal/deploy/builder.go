@@ -2,47 +2,69 @@ package deploy
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/danbruder/skyline/internal/db"
 	"github.com/danbruder/skyline/pkg/errors"
 )
 
 // AppBuilder defines the interface for building applications
 type AppBuilder interface {
-	DetectAndBuild(ctx context.Context, sourceDir, outputDir string) (BuildResult, error)
+	DetectAndBuild(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error)
+	SettingsHash(settings db.BuildSettings) string
 }
 
 // BuildResult contains information about the built application
 type BuildResult struct {
-	Type        string            // go, rust, etc.
-	BinaryPath  string            // Path to the built binary
-	Environment map[string]string // Environment variables needed to run the app
-	Port        int               // Default port the app listens on
-	HasDatabase bool              // Whether the app uses a database
-	HasStatic   bool              // Whether the app has static assets
-	StaticDir   string            // Path to static assets directory
+	Type        string            `json:"type"`         // go, rust, etc.
+	BinaryPath  string            `json:"binary_path"`  // Path to the built binary
+	AppDir      string            `json:"app_dir"`      // Directory deployed as a whole instead of a binary
+	Command     []string          `json:"command"`      // Command that starts the app in AppDir, e.g. node server.js
+	BinDirs     []string          `json:"bin_dirs"`     // Directories in AppDir added to PATH, e.g. node_modules/.bin
+	SiteDir     string            `json:"site_dir"`     // Static site served by the proxy, without a process
+	Environment map[string]string `json:"environment"`  // Environment variables needed to run the app
+	Port        int               `json:"port"`         // Default port the app listens on
+	HasDatabase bool              `json:"has_database"` // Whether the app uses a database
+	HasStatic   bool              `json:"has_static"`   // Whether the app has static assets
+	StaticDir   string            `json:"static_dir"`   // Path to static assets directory
+	Manifest    *Manifest         `json:"manifest"`     // Settings declared in skyline.yml
+	Detections  []Detection       `json:"detections"`   // How the runtime settings were determined
+	Commit      string            `json:"commit"`       // Commit the app was built from
+	Toolchain   string            `json:"toolchain"`    // Compiler version, e.g. go version go1.21.0 linux/amd64
+	BuildFlags  []string          `json:"build_flags"`  // Flags and settings the compiler ran with
+	Duration    time.Duration     `json:"duration"`     // How long the build took
 }
 
 // BuildConfig contains configuration for the builder
 type BuildConfig struct {
-	GoBinary      string
-	RustBinary    string
-	CargoBinary   string
-	BuildTimeout  time.Duration
-	OutputDir     string
-	EnableCaching bool
-	EnvVars       map[string]string
+	GoBinary       string
+	RustBinary     string
+	CargoBinary    string
+	PythonBinary   string
+	PythonIndexURL string // Package index used instead of PyPI, e.g. a local mirror
+	WheelCacheDir  string // Wheels kept across builds so Python apps build offline
+	BuildTimeout   time.Duration
+	OutputDir      string
+	CacheDir       string // Module and compiler caches shared by builds
+	BuildpacksDir  string
+	EnableCaching  bool
+	EnvVars        map[string]string
+	Sandbox        SandboxConfig
 }
 
 // Builder implements AppBuilder
 type Builder struct {
-	config BuildConfig
-	logger errors.Logger
+	config   BuildConfig
+	logger   errors.Logger
+	builders []LanguageBuilder
 }
 
 // NewBuilder creates a new Builder
@@ -57,24 +79,62 @@ func NewBuilder(config BuildConfig, logger errors.Logger) *Builder {
 	if config.CargoBinary == "" {
 		config.CargoBinary = "cargo"
 	}
+	if config.PythonBinary == "" {
+		config.PythonBinary = "python3"
+	}
 	if config.BuildTimeout == 0 {
 		config.BuildTimeout = 10 * time.Minute
 	}
 	if config.OutputDir == "" {
 		config.OutputDir = "data/builds"
 	}
+	if config.CacheDir == "" {
+		config.CacheDir = filepath.Join(filepath.Dir(config.OutputDir), "build-cache")
+	}
+	if dir, err := filepath.Abs(config.CacheDir); err == nil {
+		config.CacheDir = dir
+	}
 	if config.EnvVars == nil {
 		config.EnvVars = make(map[string]string)
 	}
+	if config.Sandbox.HomeDir == "" {
+		config.Sandbox.HomeDir = filepath.Join(filepath.Dir(config.OutputDir), "build-home")
+	}
+	if home, err := filepath.Abs(config.Sandbox.HomeDir); err == nil {
+		config.Sandbox.HomeDir = home
+	}
+	if config.Sandbox.CgroupDir == "" {
+		config.Sandbox.CgroupDir = "/sys/fs/cgroup/skyline-builds"
+	}
+	if config.Sandbox.CPUs == 0 {
+		config.Sandbox.CPUs = defaultBuildCPUs
+	}
+	if config.Sandbox.MemoryMB == 0 {
+		config.Sandbox.MemoryMB = defaultBuildMemoryMB
+	}
+	if config.Sandbox.MaxProcesses == 0 {
+		config.Sandbox.MaxProcesses = defaultBuildMaxProcesses
+	}
 
-	return &Builder{
+	b := &Builder{
 		config: config,
 		logger: logger,
 	}
+
+	// Built-in stacks, in detection order
+	b.Register(&goBuilder{b})
+	b.Register(&rustBuilder{b})
+	b.Register(&nodeBuilder{b})
+	b.Register(&pythonBuilder{b})
+	b.Register(&staticBuilder{b})
+
+	return b
 }
 
-// DetectAndBuild detects the application type and builds it
-func (b *Builder) DetectAndBuild(ctx context.Context, sourceDir, outputID string) (BuildResult, error) {
+// DetectAndBuild detects the application type and builds it. Settings
+// declared in the manifest take precedence over detection, and the build
+// settings of the app take precedence over the manifest.
+func (b *Builder) DetectAndBuild(ctx context.Context, sourceDir, outputID string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
 	fields := errors.FieldMap{
 		"source_dir": sourceDir,
 		"output_id":  outputID,
@@ -94,55 +154,148 @@ func (b *Builder) DetectAndBuild(ctx context.Context, sourceDir, outputID string
 	timeoutCtx, cancel := context.WithTimeout(ctx, b.config.BuildTimeout)
 	defer cancel()
 
-	// Detect application type
-	if isGoApp(sourceDir) {
-		b.logger.Info(ctx, "Detected Go application", fields)
-		return b.buildGoApp(timeoutCtx, sourceDir, outputDir)
-	} else if isRustApp(sourceDir) {
-		b.logger.Info(ctx, "Detected Rust application", fields)
-		return b.buildRustApp(timeoutCtx, sourceDir, outputDir)
+	// Confine the build, so a hostile or runaway repository cannot take
+	// down the host or read Skyline's data
+	sandbox, err := b.newSandbox(ctx, outputID)
+	if err != nil {
+		b.logger.Error(ctx, err, "Build sandbox creation failed", fields)
+		return BuildResult{}, err
+	}
+	defer sandbox.Close(ctx)
+
+	sourceDir, err = sandbox.prepare(sourceDir, outputDir)
+	if err != nil {
+		b.logger.Error(ctx, err, "Build sandbox creation failed", fields)
+		return BuildResult{}, err
+	}
+	timeoutCtx = withSandbox(timeoutCtx, sandbox)
+
+	if manifest == nil {
+		manifest = &Manifest{}
+	}
+
+	builder, err := b.selectBuilder(timeoutCtx, sourceDir, manifest)
+	if err != nil {
+		b.logger.Error(ctx, err, "Application type detection failed", fields)
+		return BuildResult{}, err
+	}
+
+	start := time.Now()
+	result, err := builder.Build(timeoutCtx, sourceDir, outputDir, manifest, settings)
+	if err != nil {
+		return BuildResult{}, err
+	}
+	result.Commit = buildVersionFromContext(ctx).Commit
+	result.Duration = time.Since(start)
+
+	return result, nil
+}
+
+// SettingsHash returns a hash of the settings that affect build output, so
+// artifacts built with different settings are never reused for each other
+func (b *Builder) SettingsHash(settings db.BuildSettings) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "go=%s\nrustc=%s\ncargo=%s\n", b.config.GoBinary, b.config.RustBinary, b.config.CargoBinary)
+	fmt.Fprintf(h, "python=%s\nindex=%s\n", b.config.PythonBinary, b.config.PythonIndexURL)
+
+	for _, builder := range b.externalBuilders() {
+		fmt.Fprintf(h, "buildpack:%s=%s\n", builder.Name(), builder.checksum())
+	}
+
+	for _, k := range sortedKeys(b.config.EnvVars) {
+		fmt.Fprintf(h, "env:%s=%s\n", k, b.config.EnvVars[k])
+	}
+
+	// Build settings of the app
+	fmt.Fprintf(h, "main=%s\ntags=%s\nldflags=%s\ngoflags=%s\n",
+		settings.MainPackage, strings.Join(settings.Tags, ","), settings.LDFlags, settings.GoFlags)
+	if settings.CGOEnabled != nil {
+		fmt.Fprintf(h, "cgo=%t\n", *settings.CGOEnabled)
+	}
+	for _, k := range sortedKeys(settings.Env) {
+		fmt.Fprintf(h, "app-env:%s=%s\n", k, settings.Env[k])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// buildEnv returns the environment build commands run with: the scrubbed
+// base environment of the sandbox, the shared caches, the configured
+// variables and the build-time variables of the app
+func (b *Builder) buildEnv(settings db.BuildSettings) []string {
+	env := append(b.config.Sandbox.env(), b.cacheEnv()...)
+	for k, v := range b.config.EnvVars {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
+	for _, k := range sortedKeys(settings.Env) {
+		env = append(env, fmt.Sprintf("%s=%s", k, settings.Env[k]))
+	}
+	return env
+}
 
-	err := errors.New("unsupported application type")
-	b.logger.Error(ctx, err, "Application type detection failed", fields)
-	return BuildResult{}, err
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // buildGoApp builds a Go application
-func (b *Builder) buildGoApp(ctx context.Context, sourceDir, outputDir string) (BuildResult, error) {
+func (b *Builder) buildGoApp(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
 	fields := errors.FieldMap{
 		"source_dir": sourceDir,
 		"output_dir": outputDir,
 		"type":       "go",
 	}
+	deployLog := deployLogFromContext(ctx)
+
+	// Build statically unless the app asks for cgo or needs it for SQLite
+	cgoEnabled := goRequiresCgo(sourceDir)
+	if settings.CGOEnabled != nil {
+		cgoEnabled = *settings.CGOEnabled
+	}
+	fields["cgo_enabled"] = cgoEnabled
 
 	// Set up build environment
-	env := os.Environ()
-	for k, v := range b.config.EnvVars {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	cgoSetting := "CGO_ENABLED=0"
+	if cgoEnabled {
+		cgoSetting = "CGO_ENABLED=1"
+	}
+	env := append(b.buildEnv(settings), cgoSetting)
+	if settings.GoFlags != "" {
+		env = append(env, "GOFLAGS="+settings.GoFlags)
 	}
-	// Add CGO_ENABLED=0 for static linking
-	env = append(env, "CGO_ENABLED=0")
-	// Clean Go modules cache to ensure fresh builds
-	env = append(env, "GOCACHE="+filepath.Join(outputDir, ".cache"))
 
-	// Find main.go files
-	mainFiles, err := findGoMainFiles(sourceDir)
-	if err != nil {
-		wrappedErr := errors.Wrap(err, "failed to find Go main package")
-		b.logger.Error(ctx, wrappedErr, "Go main package detection failed", fields)
-		return BuildResult{}, wrappedErr
+	// Use the main package of the build settings or the manifest, or detect it
+	mainPackage := settings.MainPackage
+	if mainPackage == "" {
+		mainPackage = manifest.Build.Target
 	}
+	mainDir := filepath.Join(sourceDir, mainPackage)
+	if mainPackage == "" {
+		mainFiles, err := findGoMainFiles(sourceDir)
+		if err != nil {
+			wrappedErr := errors.Wrap(err, "failed to find Go main package")
+			b.logger.Error(ctx, wrappedErr, "Go main package detection failed", fields)
+			return BuildResult{}, wrappedErr
+		}
 
-	if len(mainFiles) == 0 {
-		err := errors.New("no Go main package found")
-		b.logger.Error(ctx, err, "Go main package detection failed", fields)
-		return BuildResult{}, err
+		if len(mainFiles) == 0 {
+			err := errors.New("no Go main package found")
+			b.logger.Error(ctx, err, "Go main package detection failed", fields)
+			return BuildResult{}, err
+		}
+
+		mainDir = filepath.Dir(selectGoMainFile(sourceDir, mainFiles))
+		if len(mainFiles) > 1 {
+			deployLog.Printf("Found %d main packages, building %s; set main_package in the build settings to choose another",
+				len(mainFiles), relativePackage(sourceDir, mainDir))
+		}
 	}
 
-	// Use the first main.go found
-	mainFile := mainFiles[0]
-	mainDir := filepath.Dir(mainFile)
 	relMainDir, err := filepath.Rel(sourceDir, mainDir)
 	if err != nil {
 		relMainDir = mainDir // Fallback to absolute path
@@ -155,23 +308,20 @@ func (b *Builder) buildGoApp(ctx context.Context, sourceDir, outputDir string) (
 	outputBinaryName := filepath.Base(outputDir)
 	outputBinaryPath := filepath.Join(outputDir, outputBinaryName)
 
-	// Determine if the app has a database
-	hasDB, _ := detectSQLiteUsage(sourceDir)
-	fields["has_database"] = hasDB
-
-	// Find default port
-	port, _ := detectDefaultPort(sourceDir)
-	fields["port"] = port
-
-	// Check for static assets
-	hasStatic, staticDir := detectStaticAssets(sourceDir)
-	fields["has_static"] = hasStatic
-
-	// Run go build
-	cmd := exec.CommandContext(ctx, b.config.GoBinary, "build", "-o", outputBinaryPath)
+	// Run go build, stamping the binary with its version
+	var flags []string
+	if len(settings.Tags) > 0 {
+		flags = append(flags, "-tags", strings.Join(settings.Tags, ","))
+	}
+	ldflags := strings.TrimSpace(settings.LDFlags + " " + buildVersionFromContext(ctx).ldflags())
+	if ldflags != "" {
+		flags = append(flags, "-ldflags", ldflags)
+	}
+	deployLog.Printf("$ %s (in %s)", strings.Join(append([]string{"go", "build", "-o", outputBinaryName}, flags...), " "), relMainDir)
+	cmd := commandContext(ctx, b.config.GoBinary, append([]string{"build", "-o", outputBinaryPath}, flags...)...)
 	cmd.Dir = mainDir
 	cmd.Env = env
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(ctx, cmd)
 	if err != nil {
 		wrappedErr := errors.Wrap(err, fmt.Sprintf("go build failed: %s", output))
 		b.logger.Error(ctx, wrappedErr, "Go build failed", fields)
@@ -185,10 +335,22 @@ func (b *Builder) buildGoApp(ctx context.Context, sourceDir, outputDir string) (
 		return BuildResult{}, wrappedErr
 	}
 
+	// Determine database, port and static assets now that dependencies
+	// are downloaded
+	detected := detectRuntime(ctx, sourceDir, manifest,
+		func() (bool, string) { return b.goUsesSQLite(ctx, sourceDir, mainDir, env) },
+		func() (int, string) {
+			return findListenPort(sourceDir, []string{mainDir, sourceDir}, ".go", goPortPatterns)
+		})
+	hasStatic, staticDir := detected.HasStatic, detected.StaticDir
+	fields["has_database"] = detected.HasDatabase
+	fields["port"] = detected.Port
+	fields["has_static"] = hasStatic
+
 	// Copy static assets if present
 	if hasStatic && staticDir != "" {
 		destStaticDir := filepath.Join(outputDir, "static")
-		if err := copyDir(staticDir, destStaticDir); err != nil {
+		if err := copyPublishedDir(staticDir, destStaticDir, sourceDir); err != nil {
 			b.logger.Warn(ctx, "Failed to copy static assets", errors.WithField(fields, "error", err.Error()))
 			// Continue without static assets
 			hasStatic = false
@@ -197,14 +359,24 @@ func (b *Builder) buildGoApp(ctx context.Context, sourceDir, outputDir string) (
 		}
 	}
 
+	buildFlags := append([]string(nil), flags...)
+	buildFlags = append(buildFlags, cgoSetting)
+	if settings.GoFlags != "" {
+		buildFlags = append(buildFlags, "GOFLAGS="+settings.GoFlags)
+	}
+
 	result := BuildResult{
 		Type:        "go",
 		BinaryPath:  outputBinaryPath,
+		Toolchain:   toolchainVersion(ctx, env, b.config.GoBinary, "version"),
+		BuildFlags:  buildFlags,
 		Environment: make(map[string]string),
-		Port:        port,
-		HasDatabase: hasDB,
+		Port:        detected.Port,
+		HasDatabase: detected.HasDatabase,
 		HasStatic:   hasStatic,
 		StaticDir:   staticDir,
+		Manifest:    manifest,
+		Detections:  detected.Detections,
 	}
 
 	b.logger.Info(ctx, "Go application built successfully", fields)
@@ -212,7 +384,7 @@ func (b *Builder) buildGoApp(ctx context.Context, sourceDir, outputDir string) (
 }
 
 // buildRustApp builds a Rust application
-func (b *Builder) buildRustApp(ctx context.Context, sourceDir, outputDir string) (BuildResult, error) {
+func (b *Builder) buildRustApp(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
 	fields := errors.FieldMap{
 		"source_dir": sourceDir,
 		"output_dir": outputDir,
@@ -220,12 +392,10 @@ func (b *Builder) buildRustApp(ctx context.Context, sourceDir, outputDir string)
 	}
 
 	// Set up build environment
-	env := os.Environ()
-	for k, v := range b.config.EnvVars {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
-	}
+	env := b.buildEnv(settings)
 	// Add rustup target for static linking
-	env = append(env, "RUSTFLAGS=-C target-feature=+crt-static")
+	rustFlags := "-C target-feature=+crt-static"
+	env = append(env, "RUSTFLAGS="+rustFlags)
 
 	// Check for Cargo.toml
 	cargoPath := filepath.Join(sourceDir, "Cargo.toml")
@@ -241,23 +411,16 @@ func (b *Builder) buildRustApp(ctx context.Context, sourceDir, outputDir string)
 	outputBinaryName := filepath.Base(outputDir)
 	outputBinaryPath := filepath.Join(outputDir, outputBinaryName)
 
-	// Determine if the app has a database
-	hasDB, _ := detectSQLiteUsage(sourceDir)
-	fields["has_database"] = hasDB
-
-	// Find default port
-	port, _ := detectDefaultPort(sourceDir)
-	fields["port"] = port
-
-	// Check for static assets
-	hasStatic, staticDir := detectStaticAssets(sourceDir)
-	fields["has_static"] = hasStatic
-
 	// Run cargo build
-	cmd := exec.CommandContext(ctx, b.config.CargoBinary, "build", "--release")
+	args := []string{"build", "--release"}
+	if manifest.Build.Target != "" {
+		args = append(args, "--bin", manifest.Build.Target)
+	}
+	deployLogFromContext(ctx).Printf("$ cargo %s", strings.Join(args, " "))
+	cmd := commandContext(ctx, b.config.CargoBinary, args...)
 	cmd.Dir = sourceDir
 	cmd.Env = env
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand(ctx, cmd)
 	if err != nil {
 		wrappedErr := errors.Wrap(err, fmt.Sprintf("cargo build failed: %s", output))
 		b.logger.Error(ctx, wrappedErr, "Rust build failed", fields)
@@ -266,6 +429,9 @@ func (b *Builder) buildRustApp(ctx context.Context, sourceDir, outputDir string)
 
 	// Find the binary in target/release directory
 	releaseBinary := findRustReleaseBinary(sourceDir)
+	if manifest.Build.Target != "" {
+		releaseBinary = filepath.Join(sourceDir, "target", "release", manifest.Build.Target)
+	}
 	if releaseBinary == "" {
 		err := errors.New("could not find Rust release binary")
 		b.logger.Error(ctx, err, "Rust binary not found", fields)
@@ -286,10 +452,22 @@ func (b *Builder) buildRustApp(ctx context.Context, sourceDir, outputDir string)
 		return BuildResult{}, wrappedErr
 	}
 
+	// Determine database, port and static assets now that Cargo.lock is
+	// up to date
+	detected := detectRuntime(ctx, sourceDir, manifest,
+		func() (bool, string) { return rustUsesSQLite(sourceDir) },
+		func() (int, string) {
+			return findListenPort(sourceDir, []string{filepath.Join(sourceDir, "src"), sourceDir}, ".rs", rustPortPatterns)
+		})
+	hasStatic, staticDir := detected.HasStatic, detected.StaticDir
+	fields["has_database"] = detected.HasDatabase
+	fields["port"] = detected.Port
+	fields["has_static"] = hasStatic
+
 	// Copy static assets if present
 	if hasStatic && staticDir != "" {
 		destStaticDir := filepath.Join(outputDir, "static")
-		if err := copyDir(staticDir, destStaticDir); err != nil {
+		if err := copyPublishedDir(staticDir, destStaticDir, sourceDir); err != nil {
 			b.logger.Warn(ctx, "Failed to copy static assets", errors.WithField(fields, "error", err.Error()))
 			// Continue without static assets
 			hasStatic = false
@@ -301,11 +479,15 @@ func (b *Builder) buildRustApp(ctx context.Context, sourceDir, outputDir string)
 	result := BuildResult{
 		Type:        "rust",
 		BinaryPath:  outputBinaryPath,
+		Toolchain:   toolchainVersion(ctx, env, b.config.RustBinary, "-V"),
+		BuildFlags:  append(args, "RUSTFLAGS="+rustFlags),
 		Environment: make(map[string]string),
-		Port:        port,
-		HasDatabase: hasDB,
+		Port:        detected.Port,
+		HasDatabase: detected.HasDatabase,
 		HasStatic:   hasStatic,
 		StaticDir:   staticDir,
+		Manifest:    manifest,
+		Detections:  detected.Detections,
 	}
 
 	b.logger.Info(ctx, "Rust application built successfully", fields)
@@ -371,6 +553,30 @@ func findGoMainFiles(dir string) ([]string, error) {
 	return mainFiles, err
 }
 
+// selectGoMainFile picks the main.go to build when no main package is set:
+// the one at the root of the source tree, or else the first one found
+func selectGoMainFile(sourceDir string, mainFiles []string) string {
+	for _, file := range mainFiles {
+		if filepath.Dir(file) == filepath.Clean(sourceDir) {
+			return file
+		}
+	}
+	return mainFiles[0]
+}
+
+// relativePackage returns the package path of a directory in the source tree,
+// e.g. ./cmd/server
+func relativePackage(sourceDir, dir string) string {
+	rel, err := filepath.Rel(sourceDir, dir)
+	if err != nil {
+		return dir
+	}
+	if rel == "." {
+		return rel
+	}
+	return "./" + filepath.ToSlash(rel)
+}
+
 // findRustReleaseBinary finds the release binary in target/release
 func findRustReleaseBinary(dir string) string {
 	releaseDir := filepath.Join(dir, "target", "release")
@@ -402,167 +608,246 @@ func findRustReleaseBinary(dir string) string {
 	return ""
 }
 
-// detectSQLiteUsage checks if the app uses SQLite
-func detectSQLiteUsage(dir string) (bool, error) {
-	// For Go, check imports
-	goFiles, err := filepath.Glob(filepath.Join(dir, "**/*.go"))
-	if err == nil {
-		for _, file := range goFiles {
-			content, err := os.ReadFile(file)
-			if err != nil {
-				continue
-			}
+// detectStaticAssets checks for static assets directory. Symlinked
+// directories are ignored, since they may point anywhere on the host.
+func detectStaticAssets(dir string) (bool, string) {
+	// Common static asset directory names
+	staticDirs := []string{"static", "public", "assets", "dist", "www"}
 
-			// Check for SQLite imports
-			if strings.Contains(string(content), "github.com/mattn/go-sqlite3") ||
-				strings.Contains(string(content), "modernc.org/sqlite") {
-				return true, nil
-			}
+	for _, staticDir := range staticDirs {
+		path := filepath.Join(dir, staticDir)
+		if info, err := os.Lstat(path); err == nil && info.IsDir() {
+			return true, path
 		}
 	}
 
-	// For Rust, check Cargo.toml
-	cargoPath := filepath.Join(dir, "Cargo.toml")
-	if _, err := os.Stat(cargoPath); err == nil {
-		content, err := os.ReadFile(cargoPath)
-		if err == nil {
-			// Check for SQLite dependencies
-			if strings.Contains(string(content), "rusqlite") ||
-				strings.Contains(string(content), "sqlite") {
-				return true, nil
-			}
-		}
+	return false, ""
+}
+
+// fileChecksum returns the hex-encoded SHA-256 of a file
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
 	}
 
-	return false, nil
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// detectDefaultPort tries to detect the default port the app listens on
-func detectDefaultPort(dir string) (int, error) {
-	// Default port if we can't detect
-	defaultPort := 8080
+// artifactPath returns the file or directory a build produced
+func (r BuildResult) artifactPath() string {
+	switch {
+	case r.SiteDir != "":
+		return r.SiteDir
+	case r.AppDir != "":
+		return r.AppDir
+	default:
+		return r.BinaryPath
+	}
+}
 
-	// For Go, check for common patterns
-	goFiles, err := filepath.Glob(filepath.Join(dir, "**/*.go"))
-	if err == nil {
-		for _, file := range goFiles {
-			content, err := os.ReadFile(file)
-			if err != nil {
-				continue
-			}
+// artifactChecksum returns the hex-encoded SHA-256 of a build artifact. For a
+// directory it covers the path, mode and content of everything inside.
+func artifactChecksum(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return fileChecksum(path)
+	}
 
-			// Check for common port patterns
-			contentStr := string(content)
-			if strings.Contains(contentStr, "ListenAndServe(\":") ||
-				strings.Contains(contentStr, "Listen(\":") {
-				// Try to extract port
-				return extractPort(contentStr)
-			}
+	h := sha256.New()
+	err = filepath.WalkDir(path, func(file string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
-	}
 
-	// For Rust, check for common patterns
-	rustFiles, err := filepath.Glob(filepath.Join(dir, "**/*.rs"))
-	if err == nil {
-		for _, file := range rustFiles {
-			content, err := os.ReadFile(file)
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %s\n", rel, info.Mode())
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(file)
 			if err != nil {
-				continue
+				return err
 			}
-
-			// Check for common port patterns
-			contentStr := string(content)
-			if strings.Contains(contentStr, ".bind(") ||
-				strings.Contains(contentStr, "listen(") {
-				// Try to extract port
-				return extractPort(contentStr)
+			fmt.Fprintf(h, "-> %s\n", target)
+		case info.Mode().IsRegular():
+			sum, err := fileChecksum(file)
+			if err != nil {
+				return err
 			}
+			fmt.Fprintf(h, "%s\n", sum)
 		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
 
-	return defaultPort, nil
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// extractPort tries to extract a port number from code
-func extractPort(content string) (int, error) {
-	// Common patterns for port definition
-	patterns := []string{
-		"ListenAndServe\\(\":[0-9]+",
-		"Listen\\(\":[0-9]+",
-		"\\.bind\\(\"[^\"]*:[0-9]+",
-		"listen\\(\"[^\"]*:[0-9]+",
-		"PORT[^=]*=[^0-9]*[0-9]+",
-		"port[^=]*=[^0-9]*[0-9]+",
-	}
-
-	for _, pattern := range patterns {
-		// Find matches
-		portStr := findPortInPattern(content, pattern)
-		if portStr != "" {
-			// Convert to integer
-			var port int
-			if _, err := fmt.Sscanf(portStr, "%d", &port); err == nil {
-				return port, nil
-			}
-		}
+// copyFile copies a file from src to dst, keeping its permissions
+func copyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
 	}
 
-	// Default port if not found
-	return 8080, nil
+	sourceContent, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, sourceContent, info.Mode().Perm())
 }
 
-// findPortInPattern extracts a port number from text matching a pattern
-func findPortInPattern(content, pattern string) string {
-	index := strings.Index(content, pattern)
-	if index == -1 {
-		return ""
+// copyAppDir copies an app directory to dst, leaving out the named top-level
+// entries such as version control metadata
+func copyAppDir(src, dst string, exclude ...string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
 	}
 
-	// Extract the matched text
-	matched := content[index:]
-	endIndex := strings.IndexAny(matched, ",)};\n")
-	if endIndex != -1 {
-		matched = matched[:endIndex]
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
 	}
 
-	// Find the port number
-	for i := len(matched) - 1; i >= 0; i-- {
-		if matched[i] >= '0' && matched[i] <= '9' {
-			// Found a digit, backtrack to find the start of the number
-			end := i + 1
-			for i >= 0 && matched[i] >= '0' && matched[i] <= '9' {
-				i--
+	skip := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		skip[name] = true
+	}
+
+	for _, entry := range entries {
+		if skip[entry.Name()] {
+			continue
+		}
+
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+
+		if entry.Type()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(srcPath)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, dstPath); err != nil {
+				return err
+			}
+		} else if entry.IsDir() {
+			if err := copyDir(srcPath, dstPath); err != nil {
+				return err
+			}
+		} else {
+			if err := copyFile(srcPath, dstPath); err != nil {
+				return err
 			}
-			return matched[i+1 : end]
 		}
 	}
 
-	return ""
+	return nil
 }
 
-// detectStaticAssets checks for static assets directory
-func detectStaticAssets(dir string) (bool, string) {
-	// Common static asset directory names
-	staticDirs := []string{"static", "public", "assets", "dist", "www"}
+// copyPublishedDir copies a directory the proxy serves to dst, leaving out
+// the named top-level entries. The proxy follows symlinks, so links are
+// replaced by the content they point to, and links pointing outside root
+// fail the copy rather than publish files of the host. Broken links are
+// left out.
+func copyPublishedDir(src, dst, root string, exclude ...string) error {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	return copyPublishedTree(src, dst, root, map[string]bool{}, exclude)
+}
 
-	for _, staticDir := range staticDirs {
-		path := filepath.Join(dir, staticDir)
-		if info, err := os.Stat(path); err == nil && info.IsDir() {
-			return true, path
-		}
+// copyPublishedTree copies a published directory, following links that stay
+// inside root. visiting holds the directories being copied, so links to a
+// parent directory cannot recurse forever.
+func copyPublishedTree(src, dst, root string, visiting map[string]bool, exclude []string) error {
+	resolved, err := resolveInTree(src, root)
+	if err != nil {
+		return err
 	}
+	if visiting[resolved] {
+		return fmt.Errorf("symlink %s forms a loop", src)
+	}
+	visiting[resolved] = true
+	defer delete(visiting, resolved)
 
-	return false, ""
-}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
 
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
-	sourceContent, err := os.ReadFile(src)
+	entries, err := os.ReadDir(resolved)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(dst, sourceContent, 0644)
+	skip := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		skip[name] = true
+	}
+
+	for _, entry := range entries {
+		if skip[entry.Name()] {
+			continue
+		}
+
+		srcPath := filepath.Join(resolved, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+
+		info, err := os.Stat(srcPath)
+		if err != nil {
+			if os.IsNotExist(err) && entry.Type()&os.ModeSymlink != 0 {
+				continue
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			err = copyPublishedTree(srcPath, dstPath, root, visiting, nil)
+		} else if target, resolveErr := resolveInTree(srcPath, root); resolveErr != nil {
+			err = resolveErr
+		} else {
+			err = copyFile(target, dstPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveInTree resolves the symlinks of path and checks that the result is
+// inside root, which must be resolved itself
+func resolveInTree(path, root string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s points outside of %s and cannot be published", path, root)
+	}
+	return resolved, nil
 }
 
 // copyDir recursively copies a directory from src to dst
@@ -589,7 +874,16 @@ func copyDir(src, dst string) error {
 		srcPath := filepath.Join(src, entry.Name())
 		dstPath := filepath.Join(dst, entry.Name())
 
-		if entry.IsDir() {
+		if entry.Type()&os.ModeSymlink != 0 {
+			// Keep links, e.g. node_modules/.bin, pointing where they did
+			target, err := os.Readlink(srcPath)
+			if err != nil {
+				return err
+			}
+			if err = os.Symlink(target, dstPath); err != nil {
+				return err
+			}
+		} else if entry.IsDir() {
 			// Recursively copy subdirectory
 			if err = copyDir(srcPath, dstPath); err != nil {
 				return err
@@ -0,0 +1,129 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danbruder/skyline/internal/db"
+)
+
+func TestBuildGoAppSettings(t *testing.T) {
+	ctx := context.Background()
+
+	// A fake go that records where and how it was run, and writes the binary
+	logPath := filepath.Join(t.TempDir(), "go.log")
+	goBinary := filepath.Join(t.TempDir(), "go")
+	script := `#!/bin/sh
+[ "$1" = list ] && exit 1
+[ "$1" = version ] && { echo "go version go1.21.0 linux/amd64"; exit 0; }
+{
+  echo "dir=$(pwd)"
+  echo "args=$*"
+  echo "CGO_ENABLED=$CGO_ENABLED"
+  echo "GOFLAGS=$GOFLAGS"
+  echo "VERSION=$VERSION"
+} > "` + logPath + `"
+touch "$3"
+`
+	if err := os.WriteFile(goBinary, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write go: %v", err)
+	}
+
+	sourceDir := t.TempDir()
+	writeFiles(t, sourceDir, map[string]string{
+		"go.mod":               "module example.com/app\n\nrequire github.com/mattn/go-sqlite3 v1.14.22\n",
+		"cmd/worker/main.go":   "package main\n",
+		"cmd/server/main.go":   "package main\n",
+		"main.go":              "package main\n",
+		"internal/store/db.go": "package store\n",
+	})
+
+	b := NewBuilder(BuildConfig{GoBinary: goBinary, OutputDir: t.TempDir(), Sandbox: testSandbox}, newMockLogger(t))
+
+	readLog := func() map[string]string {
+		t.Helper()
+		content, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("go was not run: %v", err)
+		}
+		values := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			key, value, _ := strings.Cut(line, "=")
+			values[key] = value
+		}
+		return values
+	}
+
+	// Without settings the root package is built, with cgo for go-sqlite3
+	if _, err := b.DetectAndBuild(ctx, sourceDir, "app/1", nil, db.BuildSettings{}); err != nil {
+		t.Fatalf("DetectAndBuild() error = %v", err)
+	}
+	// Builds run in a copy of the source
+	got := readLog()
+	if got["dir"] == sourceDir || filepath.Base(got["dir"]) != "src" || got["CGO_ENABLED"] != "1" || strings.Contains(got["args"], "-tags") {
+		t.Errorf("go build without settings ran with %v", got)
+	}
+
+	// Build settings choose the package and flags
+	cgo := false
+	settings := db.BuildSettings{
+		MainPackage: "./cmd/server",
+		Tags:        []string{"netgo", "osusergo"},
+		LDFlags:     "-s -w",
+		GoFlags:     "-mod=mod",
+		CGOEnabled:  &cgo,
+		Env:         map[string]string{"VERSION": "1.2.3"},
+	}
+	if _, err := b.DetectAndBuild(ctx, sourceDir, "app/2", nil, settings); err != nil {
+		t.Fatalf("DetectAndBuild() with settings error = %v", err)
+	}
+	got = readLog()
+	if !strings.HasSuffix(got["dir"], filepath.Join("src", "cmd", "server")) {
+		t.Errorf("go build ran in %s, want cmd/server", got["dir"])
+	}
+	if !strings.HasSuffix(got["args"], "-tags netgo,osusergo -ldflags -s -w") {
+		t.Errorf("go build args = %q, want tags and ldflags", got["args"])
+	}
+	if got["CGO_ENABLED"] != "0" || got["GOFLAGS"] != "-mod=mod" || got["VERSION"] != "1.2.3" {
+		t.Errorf("go build environment = %v, want settings applied", got)
+	}
+
+	// Different settings never share an artifact
+	if b.SettingsHash(db.BuildSettings{}) == b.SettingsHash(settings) {
+		t.Error("SettingsHash() is the same with and without build settings")
+	}
+
+	// The version of a deployment is linked into the binary after the
+	// configured flags, and recorded with the toolchain
+	versionCtx := withBuildVersion(ctx, buildVersion{Commit: "abc123", DeployID: "deploy-1"})
+	result, err := b.DetectAndBuild(versionCtx, sourceDir, "app/3", nil, settings)
+	if err != nil {
+		t.Fatalf("DetectAndBuild() with version error = %v", err)
+	}
+	got = readLog()
+	if !strings.HasSuffix(got["args"], "-ldflags -s -w -X main.commitSHA=abc123 -X main.deployID=deploy-1") {
+		t.Errorf("go build args = %q, want version ldflags", got["args"])
+	}
+	if result.Commit != "abc123" || result.Toolchain != "go version go1.21.0 linux/amd64" {
+		t.Errorf("DetectAndBuild() commit = %q, toolchain = %q", result.Commit, result.Toolchain)
+	}
+	if !reflect.DeepEqual(result.BuildFlags[len(result.BuildFlags)-2:], []string{"CGO_ENABLED=0", "GOFLAGS=-mod=mod"}) {
+		t.Errorf("DetectAndBuild() build flags = %q, want cgo and GOFLAGS", result.BuildFlags)
+	}
+}
+
+func TestVersionEnv(t *testing.T) {
+	got := versionEnv("release-1", deployState{Commit: "abc123"})
+	want := []string{"SKYLINE_DEPLOY_ID=release-1", "SKYLINE_COMMIT_SHA=abc123"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("versionEnv() = %q, want %q", got, want)
+	}
+
+	if got := versionEnv("release-1", deployState{}); len(got) != 1 {
+		t.Errorf("versionEnv() without commit = %q, want only the deploy ID", got)
+	}
+}
@@ -0,0 +1,240 @@
+package deploy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// External builders ("buildpacks") live in directories of their own below
+// BuildpacksDir and provide two executables:
+//
+//	detect <source-dir>
+//		Exits with status 0 if the buildpack can build the source tree.
+//	build <source-dir> <output-dir>
+//		Builds the source tree and writes an executable named "app" to the
+//		output directory. It may also write a build.json next to it:
+//		{"port": 3000, "has_database": true, "static_dir": "static",
+//		 "environment": {"KEY": "value"}}
+//		where static_dir is relative to the output directory.
+//
+// Both run in the source directory with SKYLINE_SOURCE_DIR and
+// SKYLINE_OUTPUT_DIR set. Build output is streamed to the deployment log.
+const (
+	buildpackDetect    = "detect"
+	buildpackBuild     = "build"
+	buildpackResult    = "build.json"
+	buildpackBinary    = "app"
+	buildpackDetectMax = 30 * time.Second
+)
+
+// buildpackOutput is the optional build.json written by a buildpack
+type buildpackOutput struct {
+	Port        int               `json:"port"`
+	HasDatabase bool              `json:"has_database"`
+	StaticDir   string            `json:"static_dir"`
+	Environment map[string]string `json:"environment"`
+}
+
+// externalBuilder builds applications with a buildpack on disk
+type externalBuilder struct {
+	b    *Builder
+	name string
+	dir  string
+}
+
+// externalBuilders discovers the buildpacks in BuildpacksDir, sorted by name.
+// Buildpacks named like a registered builder are ignored.
+func (b *Builder) externalBuilders() []*externalBuilder {
+	if b.config.BuildpacksDir == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(b.config.BuildpacksDir)
+	if err != nil {
+		return nil
+	}
+
+	registered := make(map[string]bool)
+	for _, builder := range b.builders {
+		registered[builder.Name()] = true
+	}
+
+	builders := make([]*externalBuilder, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || strings.HasPrefix(name, ".") || registered[name] {
+			continue
+		}
+
+		dir := filepath.Join(b.config.BuildpacksDir, name)
+		if !isExecutable(filepath.Join(dir, buildpackDetect)) || !isExecutable(filepath.Join(dir, buildpackBuild)) {
+			continue
+		}
+
+		builders = append(builders, &externalBuilder{b: b, name: name, dir: dir})
+	}
+
+	sort.Slice(builders, func(i, j int) bool {
+		return builders[i].name < builders[j].name
+	})
+
+	return builders
+}
+
+func (e *externalBuilder) Name() string {
+	return e.name
+}
+
+func (e *externalBuilder) Detect(ctx context.Context, sourceDir string) bool {
+	ctx, cancel := context.WithTimeout(ctx, buildpackDetectMax)
+	defer cancel()
+
+	sourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return false
+	}
+
+	cmd := commandContext(ctx, e.executable(buildpackDetect), sourceDir)
+	cmd.Dir = sourceDir
+	cmd.Env = e.env(db.BuildSettings{}, sourceDir, "")
+
+	return cmd.Run() == nil
+}
+
+func (e *externalBuilder) Build(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
+	fields := errors.FieldMap{
+		"source_dir": sourceDir,
+		"output_dir": outputDir,
+		"type":       e.name,
+	}
+
+	sourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return BuildResult{}, errors.Wrap(err, "failed to resolve source directory")
+	}
+	outputDir, err = filepath.Abs(outputDir)
+	if err != nil {
+		return BuildResult{}, errors.Wrap(err, "failed to resolve output directory")
+	}
+
+	e.b.logger.Info(ctx, "Building application with buildpack", fields)
+
+	// Run the buildpack
+	deployLogFromContext(ctx).Printf("$ %s/build", e.name)
+	cmd := commandContext(ctx, e.executable(buildpackBuild), sourceDir, outputDir)
+	cmd.Dir = sourceDir
+	cmd.Env = e.env(settings, sourceDir, outputDir)
+	output, err := runCommand(ctx, cmd)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, fmt.Sprintf("%s build failed: %s", e.name, output))
+		e.b.logger.Error(ctx, wrappedErr, "Buildpack build failed", fields)
+		return BuildResult{}, wrappedErr
+	}
+
+	// Check the built executable
+	binaryPath := filepath.Join(outputDir, buildpackBinary)
+	if !isExecutable(binaryPath) {
+		err := fmt.Errorf("%s build did not produce an executable %s", e.name, buildpackBinary)
+		e.b.logger.Error(ctx, err, "Buildpack output missing", fields)
+		return BuildResult{}, err
+	}
+
+	// Read what the buildpack reported about the app
+	var reported buildpackOutput
+	if data, err := os.ReadFile(filepath.Join(outputDir, buildpackResult)); err == nil {
+		if err := json.Unmarshal(data, &reported); err != nil {
+			wrappedErr := errors.Wrap(err, fmt.Sprintf("invalid %s written by %s", buildpackResult, e.name))
+			e.b.logger.Error(ctx, wrappedErr, "Buildpack output invalid", fields)
+			return BuildResult{}, wrappedErr
+		}
+	}
+
+	result := BuildResult{
+		Type:        e.name,
+		BinaryPath:  binaryPath,
+		Environment: reported.Environment,
+		Port:        reported.Port,
+		HasDatabase: reported.HasDatabase,
+		Manifest:    manifest,
+	}
+	if result.Environment == nil {
+		result.Environment = make(map[string]string)
+	}
+
+	if reported.StaticDir != "" {
+		staticDir := filepath.Join(outputDir, filepath.Clean(reported.StaticDir))
+		if info, err := os.Lstat(staticDir); err == nil && info.IsDir() && strings.HasPrefix(staticDir, outputDir+string(filepath.Separator)) {
+			result.HasStatic = true
+			result.StaticDir = staticDir
+		}
+	}
+
+	// Declared settings take precedence
+	if manifest.Run.Port != 0 {
+		result.Port = manifest.Run.Port
+	}
+	if len(manifest.Databases) > 0 {
+		result.HasDatabase = true
+	}
+	if manifest.Static != "" {
+		staticDir := filepath.Join(outputDir, "static")
+		if err := copyPublishedDir(filepath.Join(sourceDir, manifest.Static), staticDir, sourceDir); err != nil {
+			e.b.logger.Warn(ctx, "Failed to copy static assets", errors.WithField(fields, "error", err.Error()))
+		} else {
+			result.HasStatic = true
+			result.StaticDir = staticDir
+		}
+	}
+	if result.Port == 0 {
+		result.Port = 8080
+	}
+
+	e.b.logger.Info(ctx, "Buildpack application built successfully", fields)
+	return result, nil
+}
+
+// checksum returns a hash of the buildpack executables
+func (e *externalBuilder) checksum() string {
+	h := sha256.New()
+	for _, name := range []string{buildpackDetect, buildpackBuild} {
+		sum, _ := fileChecksum(e.executable(name))
+		fmt.Fprintf(h, "%s=%s\n", name, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// executable returns the path of a buildpack executable
+func (e *externalBuilder) executable(name string) string {
+	path, err := filepath.Abs(filepath.Join(e.dir, name))
+	if err != nil {
+		return filepath.Join(e.dir, name)
+	}
+	return path
+}
+
+// env returns the environment buildpack executables run with
+func (e *externalBuilder) env(settings db.BuildSettings, sourceDir, outputDir string) []string {
+	env := e.b.buildEnv(settings)
+	env = append(env, "SKYLINE_SOURCE_DIR="+sourceDir)
+	if outputDir != "" {
+		env = append(env, "SKYLINE_OUTPUT_DIR="+outputDir)
+	}
+	return env
+}
+
+// isExecutable reports whether a path is an executable file
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+}
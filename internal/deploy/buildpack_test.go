@@ -0,0 +1,75 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danbruder/skyline/internal/db"
+)
+
+func TestExternalBuilder(t *testing.T) {
+	ctx := context.Background()
+	buildpacksDir := t.TempDir()
+
+	// A buildpack for source trees containing a hello.txt
+	writeScript := func(name, script string) {
+		t.Helper()
+		dir := filepath.Join(buildpacksDir, "hello")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create buildpack: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	writeScript("detect", `test -f "$1/hello.txt"`)
+	writeScript("build", `set -e
+printf '#!/bin/sh\ncat hello.txt\n' > "$2/app"
+chmod +x "$2/app"
+mkdir -p "$2/public"
+echo '{"port": 3000, "static_dir": "public", "environment": {"GREETING": "hi"}}' > "$2/build.json"
+`)
+
+	b := NewBuilder(BuildConfig{OutputDir: t.TempDir(), BuildpacksDir: buildpacksDir, Sandbox: testSandbox}, newMockLogger(t))
+
+	sourceDir := t.TempDir()
+	if _, err := b.DetectAndBuild(ctx, sourceDir, "app/1", nil, db.BuildSettings{}); err == nil {
+		t.Fatal("DetectAndBuild() of an unknown stack succeeded")
+	}
+
+	if err := os.WriteFile(filepath.Join(sourceDir, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+
+	result, err := b.DetectAndBuild(ctx, sourceDir, "app/2", nil, db.BuildSettings{})
+	if err != nil {
+		t.Fatalf("DetectAndBuild() error = %v", err)
+	}
+
+	if result.Type != "hello" {
+		t.Errorf("Type = %q, want hello", result.Type)
+	}
+	if !isExecutable(result.BinaryPath) {
+		t.Errorf("BinaryPath %q is not executable", result.BinaryPath)
+	}
+	if result.Port != 3000 || !result.HasStatic || result.Environment["GREETING"] != "hi" {
+		t.Errorf("DetectAndBuild() = %+v, want port, static assets and environment from build.json", result)
+	}
+
+	// The manifest selects builders by name and overrides reported settings
+	manifest := &Manifest{Build: ManifestBuild{Type: "hello"}, Run: ManifestRun{Port: 4000}}
+	result, err = b.DetectAndBuild(ctx, sourceDir, "app/3", manifest, db.BuildSettings{})
+	if err != nil {
+		t.Fatalf("DetectAndBuild() with manifest error = %v", err)
+	}
+	if result.Port != 4000 {
+		t.Errorf("Port = %d, want 4000", result.Port)
+	}
+
+	manifest.Build.Type = "missing"
+	if _, err := b.DetectAndBuild(ctx, sourceDir, "app/4", manifest, db.BuildSettings{}); err == nil {
+		t.Error("DetectAndBuild() with an unknown declared type succeeded")
+	}
+}
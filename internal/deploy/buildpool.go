@@ -0,0 +1,153 @@
+package deploy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BuildJob describes a build that is running or waiting for a worker
+type BuildJob struct {
+	DeploymentID string     `json:"deployment_id"`
+	AppID        string     `json:"app_id"`
+	Commit       string     `json:"commit"`
+	QueuedAt     time.Time  `json:"queued_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+}
+
+// BuildQueueStatus shows the builds of a BuildPool
+type BuildQueueStatus struct {
+	Workers int        `json:"workers"`
+	Running []BuildJob `json:"running"`
+	Queued  []BuildJob `json:"queued"`
+}
+
+// BuildPool limits how many builds run at once. Builds beyond the limit wait
+// for a worker in the order they arrived.
+type BuildPool struct {
+	workers int
+	running []*BuildJob
+	queued  []*queuedBuild
+	mu      sync.Mutex
+}
+
+// queuedBuild is a build waiting for a worker; ready is closed when it gets one
+type queuedBuild struct {
+	job   *BuildJob
+	ready chan struct{}
+}
+
+// NewBuildPool creates a BuildPool running up to workers builds at once
+func NewBuildPool(workers int) *BuildPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &BuildPool{workers: workers}
+}
+
+// Acquire waits for a worker for a build. The returned function hands the
+// worker on to the next build and must be called when the build is done.
+func (p *BuildPool) Acquire(ctx context.Context, job BuildJob) (func(), error) {
+	job.QueuedAt = time.Now()
+	waiting := &queuedBuild{job: &job, ready: make(chan struct{})}
+
+	p.mu.Lock()
+	p.queued = append(p.queued, waiting)
+	p.dispatch()
+	position := p.position(waiting)
+	p.mu.Unlock()
+
+	if position > 0 {
+		deployLogFromContext(ctx).Printf("Waiting for a build worker, position %d in the queue", position)
+	}
+
+	select {
+	case <-waiting.ready:
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		select {
+		case <-waiting.ready:
+			// A worker was handed over while giving up
+			p.remove(waiting.job)
+		default:
+			p.queued = removeQueued(p.queued, waiting)
+		}
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.remove(waiting.job)
+		})
+	}, nil
+}
+
+// Status returns the running and waiting builds, in the order they started
+// and will start
+func (p *BuildPool) Status() BuildQueueStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := BuildQueueStatus{
+		Workers: p.workers,
+		Running: make([]BuildJob, 0, len(p.running)),
+		Queued:  make([]BuildJob, 0, len(p.queued)),
+	}
+	for _, job := range p.running {
+		status.Running = append(status.Running, *job)
+	}
+	for _, waiting := range p.queued {
+		status.Queued = append(status.Queued, *waiting.job)
+	}
+	return status
+}
+
+// dispatch hands free workers to waiting builds. Must be called with p.mu
+// held.
+func (p *BuildPool) dispatch() {
+	for len(p.running) < p.workers && len(p.queued) > 0 {
+		next := p.queued[0]
+		p.queued = p.queued[1:]
+
+		started := time.Now()
+		next.job.StartedAt = &started
+		p.running = append(p.running, next.job)
+		close(next.ready)
+	}
+}
+
+// remove frees the worker of a running build. Must be called with p.mu held.
+func (p *BuildPool) remove(job *BuildJob) {
+	for i, running := range p.running {
+		if running == job {
+			p.running = append(p.running[:i], p.running[i+1:]...)
+			break
+		}
+	}
+	p.dispatch()
+}
+
+// position returns the 1-based queue position of a waiting build, or 0 if
+// it has a worker. Must be called with p.mu held.
+func (p *BuildPool) position(waiting *queuedBuild) int {
+	for i, queued := range p.queued {
+		if queued == waiting {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// removeQueued removes a waiting build from a queue
+func removeQueued(queue []*queuedBuild, waiting *queuedBuild) []*queuedBuild {
+	for i, queued := range queue {
+		if queued == waiting {
+			return append(queue[:i], queue[i+1:]...)
+		}
+	}
+	return queue
+}
@@ -0,0 +1,89 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBuildPool(t *testing.T) {
+	ctx := context.Background()
+	pool := NewBuildPool(1)
+
+	releaseFirst, err := pool.Acquire(ctx, BuildJob{DeploymentID: "first"})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	// Later builds wait in order for the worker
+	acquired := make(chan string, 2)
+	releases := make(chan func(), 2)
+	for _, id := range []string{"second", "third"} {
+		id := id
+		go func() {
+			release, err := pool.Acquire(ctx, BuildJob{DeploymentID: id})
+			if err != nil {
+				t.Errorf("Acquire(%s) error = %v", id, err)
+				return
+			}
+			acquired <- id
+			releases <- release
+		}()
+		waitForQueue(t, pool, id)
+	}
+
+	// A cancelled build leaves the queue without taking a worker
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := pool.Acquire(cancelCtx, BuildJob{DeploymentID: "cancelled"})
+		cancelled <- err
+	}()
+	waitForQueue(t, pool, "cancelled")
+	cancel()
+	if err := <-cancelled; err != context.Canceled {
+		t.Fatalf("Acquire() after cancel error = %v, want context.Canceled", err)
+	}
+
+	status := pool.Status()
+	if status.Workers != 1 || len(status.Running) != 1 || status.Running[0].DeploymentID != "first" || status.Running[0].StartedAt == nil {
+		t.Errorf("Status() running = %+v, want first", status.Running)
+	}
+	if len(status.Queued) != 2 || status.Queued[0].DeploymentID != "second" || status.Queued[1].DeploymentID != "third" {
+		t.Errorf("Status() queued = %+v, want second and third", status.Queued)
+	}
+
+	for _, want := range []string{"second", "third"} {
+		releaseFirst()
+		releaseFirst() // Releasing twice frees a single worker
+		select {
+		case got := <-acquired:
+			if got != want {
+				t.Fatalf("%s got the worker, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s did not get the worker", want)
+		}
+		releaseFirst = <-releases
+	}
+	releaseFirst()
+
+	if status := pool.Status(); len(status.Running) != 0 || len(status.Queued) != 0 {
+		t.Errorf("Status() after all builds = %+v, want an idle pool", status)
+	}
+}
+
+// waitForQueue waits until a build waits for a worker
+func waitForQueue(t *testing.T, pool *BuildPool, deploymentID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, job := range pool.Status().Queued {
+			if job.DeploymentID == deploymentID {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("build %s is not queued", deploymentID)
+}
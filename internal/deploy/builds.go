@@ -0,0 +1,107 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// Build artifacts of an app are written to a directory per build:
+//
+//	<BuildDir>/<app>/<build>  the artifact, recorded under the build's ID
+//
+// Releases copy what they need from the artifact, so artifacts only serve
+// later deployments of the same commit and are kept like releases.
+
+// buildsDir returns the directory the builds of an app are written to
+func (p *Pipeline) buildsDir(appID string) string {
+	return filepath.Join(p.config.BuildDir, appID)
+}
+
+// touchBuild marks a build of an app as used, so reused builds are kept
+// longer than builds of commits no longer deployed
+func (p *Pipeline) touchBuild(appID, buildID string) {
+	now := time.Now()
+	os.Chtimes(filepath.Join(p.buildsDir(appID), buildID), now, now)
+}
+
+// pruneBuilds removes the oldest build artifacts of an app beyond the
+// retention limit, and their records, never removing the build in use
+func (p *Pipeline) pruneBuilds(ctx context.Context, appID, inUse string) {
+	buildsDir := p.buildsDir(appID)
+	entries, err := os.ReadDir(buildsDir)
+	if err != nil || len(entries) <= p.config.KeepBuilds {
+		return
+	}
+
+	type build struct {
+		id      string
+		modTime int64
+	}
+	builds := make([]build, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		builds = append(builds, build{id: entry.Name(), modTime: info.ModTime().UnixNano()})
+	}
+
+	// Newest first
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].modTime > builds[j].modTime
+	})
+
+	for i, b := range builds {
+		if i < p.config.KeepBuilds || b.id == inUse {
+			continue
+		}
+
+		p.removeBuild(ctx, appID, b.id)
+	}
+}
+
+// RemoveBuilds removes all build artifacts of an app and their records
+func (p *Pipeline) RemoveBuilds(ctx context.Context, appID string) error {
+	buildsDir := p.buildsDir(appID)
+	entries, err := os.ReadDir(buildsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to list builds")
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			p.removeBuild(ctx, appID, entry.Name())
+		}
+	}
+
+	if err := os.RemoveAll(buildsDir); err != nil {
+		return errors.Wrap(err, "failed to remove builds")
+	}
+	return nil
+}
+
+// removeBuild forgets a build and removes its artifact
+func (p *Pipeline) removeBuild(ctx context.Context, appID, buildID string) {
+	fields := errors.FieldMap{
+		"app_id":   appID,
+		"build_id": buildID,
+	}
+
+	// Forget the build first, so it is never looked up without its artifact
+	if err := p.database.DeleteBuild(ctx, buildID); err != nil {
+		p.logger.Warn(ctx, "Failed to delete build record", errors.WithField(fields, "error", err.Error()))
+		return
+	}
+
+	if err := os.RemoveAll(filepath.Join(p.buildsDir(appID), buildID)); err != nil {
+		p.logger.Warn(ctx, "Failed to remove build artifact", errors.WithField(fields, "error", err.Error()))
+	}
+}
@@ -0,0 +1,82 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+func TestBuildRetention(t *testing.T) {
+	ctx := context.Background()
+	logger := newMockLogger(t)
+
+	database, err := db.New(ctx, filepath.Join(t.TempDir(), "test.db"), logger)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	p := &Pipeline{
+		config:   PipelineConfig{BuildDir: t.TempDir(), KeepBuilds: 2},
+		logger:   logger,
+		database: database,
+	}
+
+	// Record four builds of different commits, oldest first
+	builds := []string{"b1", "b2", "b3", "b4"}
+	for i, id := range builds {
+		dir := filepath.Join(p.buildsDir("app"), id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create build: %v", err)
+		}
+		err := database.CreateBuild(ctx, &db.Build{
+			ID:           id,
+			RepoURL:      "https://github.com/example/repo",
+			CommitSHA:    strings.Repeat(string(rune('a'+i)), 40),
+			ArtifactPath: dir,
+		})
+		if err != nil {
+			t.Fatalf("Failed to record build: %v", err)
+		}
+		modTime := time.Now().Add(time.Duration(i-len(builds)) * time.Minute)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set build time: %v", err)
+		}
+	}
+
+	// Reusing a build keeps it, like the build in use
+	p.touchBuild("app", "b2")
+	p.pruneBuilds(ctx, "app", "b1")
+	for i, id := range builds {
+		want := id != "b3"
+		if _, err := os.Stat(filepath.Join(p.buildsDir("app"), id)); (err == nil) != want {
+			t.Errorf("build %s exists = %v, want %v", id, err == nil, want)
+		}
+
+		_, err := database.FindBuild(ctx, "https://github.com/example/repo", strings.Repeat(string(rune('a'+i)), 40), "")
+		if found := err == nil; found != want {
+			t.Errorf("build %s recorded = %v, want %v", id, found, want)
+		}
+	}
+
+	// Deleted apps take their builds along
+	if err := p.RemoveBuilds(ctx, "app"); err != nil {
+		t.Fatalf("RemoveBuilds() error = %v", err)
+	}
+	if _, err := os.Stat(p.buildsDir("app")); !os.IsNotExist(err) {
+		t.Errorf("builds of a removed app exist, stat error = %v", err)
+	}
+	_, err = database.FindBuild(ctx, "https://github.com/example/repo", strings.Repeat("d", 40), "")
+	if !errors.Is(err, errors.ErrRecordNotFound) {
+		t.Errorf("FindBuild() of a removed app error = %v, want not found", err)
+	}
+	if err := p.RemoveBuilds(ctx, "app"); err != nil {
+		t.Errorf("RemoveBuilds() of an app without builds error = %v", err)
+	}
+}
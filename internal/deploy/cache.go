@@ -0,0 +1,152 @@
+package deploy
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// Builds on a host share their caches below BuildConfig.CacheDir, so warm
+// builds only download and compile what changed:
+//
+//	go/mod    GOMODCACHE, downloaded Go modules
+//	go/build  GOCACHE, compiled Go packages
+//	cargo     CARGO_HOME, the Cargo registry and git checkouts
+//
+// Concurrent builds may use them: Go's caches are safe for concurrent use,
+// and Cargo locks its home directory.
+const (
+	goModCacheDir   = "go/mod"
+	goBuildCacheDir = "go/build"
+	cargoHomeDir    = "cargo"
+)
+
+// DefaultCacheMaxAge is how long compiled packages are kept without being
+// used when caches are pruned
+const DefaultCacheMaxAge = 7 * 24 * time.Hour
+
+// PruneResult reports what pruning the build caches removed
+type PruneResult struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// cacheEnv returns the variables that point builds at the shared caches
+func (b *Builder) cacheEnv() []string {
+	return []string{
+		"GOMODCACHE=" + filepath.Join(b.config.CacheDir, goModCacheDir),
+		"GOCACHE=" + filepath.Join(b.config.CacheDir, goBuildCacheDir),
+		"CARGO_HOME=" + filepath.Join(b.config.CacheDir, cargoHomeDir),
+	}
+}
+
+// cacheDirs returns the directories of the shared caches
+func (b *Builder) cacheDirs() []string {
+	return []string{
+		filepath.Join(b.config.CacheDir, goModCacheDir),
+		filepath.Join(b.config.CacheDir, goBuildCacheDir),
+		filepath.Join(b.config.CacheDir, cargoHomeDir),
+	}
+}
+
+// PruneCaches removes compiled Go packages that no build used for longer
+// than maxAge; Go marks the entries it uses. With all, it also removes the
+// downloaded Go modules and Cargo's registry and git checkouts, so the next
+// builds download their dependencies again. Pruning everything should not
+// run alongside builds.
+func (b *Builder) PruneCaches(ctx context.Context, maxAge time.Duration, all bool) (PruneResult, error) {
+	var result PruneResult
+	fields := errors.FieldMap{
+		"cache_dir": b.config.CacheDir,
+		"max_age":   maxAge.String(),
+		"all":       all,
+	}
+
+	b.logger.Info(ctx, "Pruning build caches", fields)
+
+	// Entries of the build cache are independent files named by their
+	// content, so any of them can be removed
+	cutoff := time.Now().Add(-maxAge)
+	buildCache := filepath.Join(b.config.CacheDir, goBuildCacheDir)
+	err := filepath.WalkDir(buildCache, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if filepath.Dir(path) == buildCache {
+			// README, trim.txt and the testexpand files describe the cache
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		result.Files++
+		result.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to prune Go build cache")
+		b.logger.Error(ctx, wrappedErr, "Build cache pruning failed", fields)
+		return result, wrappedErr
+	}
+
+	if all {
+		dirs := []string{
+			filepath.Join(b.config.CacheDir, goModCacheDir),
+			filepath.Join(b.config.CacheDir, cargoHomeDir, "registry"),
+			filepath.Join(b.config.CacheDir, cargoHomeDir, "git"),
+		}
+		for _, dir := range dirs {
+			removed, err := removeCache(dir)
+			result.Files += removed.Files
+			result.Bytes += removed.Bytes
+			if err != nil {
+				wrappedErr := errors.Wrap(err, "failed to remove "+dir)
+				b.logger.Error(ctx, wrappedErr, "Build cache pruning failed", fields)
+				return result, wrappedErr
+			}
+		}
+	}
+
+	b.logger.Info(ctx, "Build caches pruned", errors.WithField(fields, "bytes", result.Bytes))
+	return result, nil
+}
+
+// removeCache removes a cache directory. Go makes its module cache read-only,
+// so directories are made writable first.
+func removeCache(dir string) (PruneResult, error) {
+	var result PruneResult
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return os.Chmod(path, 0755)
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			result.Files++
+			result.Bytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return PruneResult{}, err
+	}
+	return result, os.RemoveAll(dir)
+}
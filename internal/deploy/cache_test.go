@@ -0,0 +1,55 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneCaches(t *testing.T) {
+	ctx := context.Background()
+	b := NewBuilder(BuildConfig{OutputDir: filepath.Join(t.TempDir(), "builds")}, newMockLogger(t))
+
+	cacheDir := filepath.Join(filepath.Dir(b.config.OutputDir), "build-cache")
+	writeFiles(t, cacheDir, map[string]string{
+		"go/build/README":              "cache\n",
+		"go/build/0a/old-d":            "old",
+		"go/build/0b/new-d":            "new",
+		"go/mod/example.com/m/go.mod":  "module example.com/m\n",
+		"cargo/registry/cache/a.crate": "crate",
+		"cargo/config.toml":            "",
+	})
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	for _, name := range []string{"go/build/README", "go/build/0a/old-d"} {
+		if err := os.Chtimes(filepath.Join(cacheDir, name), old, old); err != nil {
+			t.Fatalf("Failed to age %s: %v", name, err)
+		}
+	}
+	// Go makes downloaded modules read-only
+	os.Chmod(filepath.Join(cacheDir, "go/mod/example.com/m"), 0555)
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(cacheDir, name))
+		return err == nil
+	}
+
+	result, err := b.PruneCaches(ctx, DefaultCacheMaxAge, false)
+	if err != nil {
+		t.Fatalf("PruneCaches() error = %v", err)
+	}
+	if result.Files != 1 || result.Bytes != 3 || exists("go/build/0a/old-d") {
+		t.Errorf("PruneCaches() = %+v, want the old build cache entry removed", result)
+	}
+	if !exists("go/build/README") || !exists("go/build/0b/new-d") || !exists("go/mod/example.com/m/go.mod") {
+		t.Error("PruneCaches() removed entries that are in use")
+	}
+
+	if _, err := b.PruneCaches(ctx, DefaultCacheMaxAge, true); err != nil {
+		t.Fatalf("PruneCaches() of everything error = %v", err)
+	}
+	if exists("go/mod") || exists("cargo/registry") || !exists("cargo/config.toml") {
+		t.Error("PruneCaches() of everything did not remove just the downloaded dependencies")
+	}
+}
@@ -6,29 +6,42 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/internal/supervisor"
 	"github.com/danbruder/skyline/pkg/errors"
 )
 
 // AppDeployer defines the interface for deploying applications
 type AppDeployer interface {
-	Deploy(ctx context.Context, buildResult BuildResult, appID string) error
+	Deploy(ctx context.Context, buildResult BuildResult, appID, releaseID string) error
+	Rollback(ctx context.Context, appID, releaseID string) error
 	Undeploy(ctx context.Context, appID string) error
+	CurrentRelease(appID string) (string, error)
+	HasRelease(appID, releaseID string) bool
 }
 
 // SupervisorClient defines the interface for interacting with the supervisor
 type SupervisorClient interface {
-	StartApp(appID, execPath string, env []string) error
+	StartApp(appID string, command supervisor.Command) error
 	StopApp(appID string) error
 	RestartApp(appID string) error
 	GetStatus(appID string) (string, error)
+	StageApp(appID string, command supervisor.Command) error
+	GetStagedStatus(appID string) (string, error)
+	PromoteApp(appID string, drain time.Duration) error
+	DiscardApp(appID string) error
+	SetHealthCheck(appID string, check supervisor.HealthCheck)
+	RemoveHealthCheck(appID string)
 }
 
 // ProxyClient defines the interface for interacting with the proxy
 type ProxyClient interface {
 	AddRoute(appID, domain string, port int) error
+	AddAssetsRoute(appID, domain string, port int, prefix, root string) error
+	AddStaticRoute(appID, domain, root string, spa bool) error
 	RemoveRoute(appID string) error
 }
 
@@ -46,6 +59,9 @@ type DeployConfig struct {
 	DefaultEnv      map[string]string
 	DeployTimeout   time.Duration
 	BackupDatabases bool
+	KeepReleases    int
+	HealthTimeout   time.Duration
+	DrainTimeout    time.Duration
 }
 
 // Deployer implements AppDeployer
@@ -83,6 +99,15 @@ func NewDeployer(
 	if config.DefaultEnv == nil {
 		config.DefaultEnv = make(map[string]string)
 	}
+	if config.KeepReleases == 0 {
+		config.KeepReleases = 5
+	}
+	if config.HealthTimeout == 0 {
+		config.HealthTimeout = 30 * time.Second
+	}
+	if config.DrainTimeout == 0 {
+		config.DrainTimeout = 10 * time.Second
+	}
 
 	return &Deployer{
 		config:     config,
@@ -94,12 +119,13 @@ func NewDeployer(
 	}
 }
 
-// Deploy deploys an application
-func (d *Deployer) Deploy(ctx context.Context, buildResult BuildResult, appID string) error {
+// Deploy deploys an application as a new immutable release
+func (d *Deployer) Deploy(ctx context.Context, buildResult BuildResult, appID, releaseID string) error {
 	fields := errors.FieldMap{
-		"app_id":      appID,
-		"binary_path": buildResult.BinaryPath,
-		"app_type":    buildResult.Type,
+		"app_id":     appID,
+		"release_id": releaseID,
+		"artifact":   buildResult.artifactPath(),
+		"app_type":   buildResult.Type,
 	}
 
 	// Create timeout context
@@ -107,6 +133,7 @@ func (d *Deployer) Deploy(ctx context.Context, buildResult BuildResult, appID st
 	defer cancel()
 
 	d.logger.Info(timeoutCtx, "Deploying application", fields)
+	deployLog := deployLogFromContext(ctx)
 
 	// Get app details from database
 	app, err := d.database.GetApp(timeoutCtx, appID)
@@ -121,11 +148,20 @@ func (d *Deployer) Deploy(ctx context.Context, buildResult BuildResult, appID st
 
 	// Create app directory structure
 	appDir := filepath.Join(d.config.AppsDir, appID)
-	binDir := filepath.Join(appDir, "bin")
+	releaseDir := d.releaseDir(appID, releaseID)
+	binDir := filepath.Join(releaseDir, "bin")
 	dataDir := filepath.Join(d.config.DataDir, appID)
 	dbDir := filepath.Join(dataDir, "db")
 	logDir := filepath.Join(appDir, "logs")
 
+	// Releases that never went live cannot be rolled back to, so they are
+	// removed rather than counted toward the retention limit
+	defer func() {
+		if current, _ := d.CurrentRelease(appID); current != releaseID {
+			d.removeRelease(ctx, appID, releaseID)
+		}
+	}()
+
 	// Ensure all directories exist
 	for _, dir := range []string{appDir, binDir, dataDir, dbDir, logDir} {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -136,41 +172,59 @@ func (d *Deployer) Deploy(ctx context.Context, buildResult BuildResult, appID st
 		}
 	}
 
-	// Copy binary to app directory
-	appBinaryPath := filepath.Join(binDir, "app")
-	if err := copyFile(buildResult.BinaryPath, appBinaryPath); err != nil {
-		wrappedErr := errors.Wrap(err, "failed to copy binary")
-		d.logger.Error(timeoutCtx, wrappedErr, "Binary copy failed", fields)
-		return wrappedErr
-	}
+	switch {
+	case buildResult.SiteDir != "":
+		// Static sites are served from the release by the proxy
+		siteDir := filepath.Join(releaseDir, staticSiteDir)
+		deployLog.Printf("Copying site to %s", siteDir)
+		if err := copyPublishedDir(buildResult.SiteDir, siteDir, buildResult.SiteDir); err != nil {
+			wrappedErr := errors.Wrap(err, "failed to copy site")
+			d.logger.Error(timeoutCtx, wrappedErr, "Site copy failed", fields)
+			return wrappedErr
+		}
+	case buildResult.AppDir != "":
+		// Apps run by an interpreter are deployed as a whole directory
+		appFilesDir := filepath.Join(releaseDir, "app")
+		deployLog.Printf("Copying application to %s", appFilesDir)
+		if err := copyDir(buildResult.AppDir, appFilesDir); err != nil {
+			wrappedErr := errors.Wrap(err, "failed to copy application")
+			d.logger.Error(timeoutCtx, wrappedErr, "Application copy failed", fields)
+			return wrappedErr
+		}
+	default:
+		// Copy binary to release directory
+		appBinaryPath := filepath.Join(binDir, "app")
+		deployLog.Printf("Copying binary to %s", appBinaryPath)
+		if err := copyFile(buildResult.BinaryPath, appBinaryPath); err != nil {
+			wrappedErr := errors.Wrap(err, "failed to copy binary")
+			d.logger.Error(timeoutCtx, wrappedErr, "Binary copy failed", fields)
+			return wrappedErr
+		}
 
-	// Make binary executable
-	if err := os.Chmod(appBinaryPath, 0755); err != nil {
-		wrappedErr := errors.Wrap(err, "failed to make binary executable")
-		d.logger.Error(timeoutCtx, wrappedErr, "Binary permission setting failed", fields)
-		return wrappedErr
+		// Make binary executable
+		if err := os.Chmod(appBinaryPath, 0755); err != nil {
+			wrappedErr := errors.Wrap(err, "failed to make binary executable")
+			d.logger.Error(timeoutCtx, wrappedErr, "Binary permission setting failed", fields)
+			return wrappedErr
+		}
 	}
 
 	// Copy static assets if present
+	hasStatic := false
 	if buildResult.HasStatic && buildResult.StaticDir != "" {
-		staticDir := filepath.Join(appDir, "static")
-		if err := copyDir(buildResult.StaticDir, staticDir); err != nil {
+		staticDir := filepath.Join(releaseDir, "static")
+		deployLog.Printf("Copying static assets to %s", staticDir)
+		if err := copyPublishedDir(buildResult.StaticDir, staticDir, buildResult.StaticDir); err != nil {
 			// Log but continue
+			deployLog.Printf("Failed to copy static assets: %v", err)
 			d.logger.Warn(timeoutCtx, "Failed to copy static assets",
 				errors.WithField(fields, "error", err.Error()))
 		} else {
+			hasStatic = true
 			fields["static_dir"] = staticDir
 		}
 	}
 
-	// Set up environment variables
-	env := make(map[string]string)
-
-	// Add default environment variables
-	for k, v := range d.config.DefaultEnv {
-		env[k] = v
-	}
-
 	// Set port
 	port := buildResult.Port
 	if port == 0 {
@@ -179,73 +233,491 @@ func (d *Deployer) Deploy(ctx context.Context, buildResult BuildResult, appID st
 	if app.Port != 0 {
 		port = app.Port
 	}
-	env["PORT"] = strconv.Itoa(port)
 	fields["port"] = port
 
-	// Set app-specific env variables
-	for _, e := range app.Environment {
-		env[e.Key] = e.Value
+	// Record how the release was built so it can be started again later
+	state := deployState{
+		Type:        buildResult.Type,
+		Commit:      buildResult.Commit,
+		Command:     buildResult.Command,
+		BinDirs:     buildResult.BinDirs,
+		Site:        buildResult.SiteDir != "",
+		Port:        port,
+		HasDatabase: buildResult.HasDatabase,
+		HasStatic:   hasStatic,
+		Environment: buildResult.Environment,
+		Manifest:    buildResult.Manifest,
+	}
+	if err := d.saveState(releaseDir, state); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to save release state")
+		d.logger.Error(timeoutCtx, wrappedErr, "Release state write failed", fields)
+		return wrappedErr
+	}
+
+	// Run pre-deploy hooks, e.g. migrations, while the old release still
+	// serves traffic
+	if state.Manifest != nil && len(state.Manifest.Hooks.PreDeploy) > 0 {
+		deployLog.Printf("Running pre-deploy hooks")
+		if err := d.runHooks(timeoutCtx, app, releaseID, state, state.Manifest.Hooks.PreDeploy); err != nil {
+			wrappedErr := errors.Wrap(err, "pre-deploy hook failed")
+			d.logger.Error(timeoutCtx, wrappedErr, "Pre-deploy hook failed", fields)
+			return wrappedErr
+		}
+	}
+
+	if err := d.activate(timeoutCtx, app, releaseID, state); err != nil {
+		return err
+	}
+
+	// Run post-deploy hooks. The release is live already, so failures are
+	// reported but do not fail the deployment.
+	if state.Manifest != nil && len(state.Manifest.Hooks.PostDeploy) > 0 {
+		deployLog.Printf("Running post-deploy hooks")
+		if err := d.runHooks(timeoutCtx, app, releaseID, state, state.Manifest.Hooks.PostDeploy); err != nil {
+			deployLog.Printf("Post-deploy hook failed: %v", err)
+			d.logger.Warn(timeoutCtx, "Post-deploy hook failed",
+				errors.WithField(fields, "error", err.Error()))
+		}
+	}
+
+	// Drop releases beyond the retention limit
+	d.pruneReleases(timeoutCtx, appID)
+
+	d.logger.Info(timeoutCtx, "Application deployed successfully", fields)
+	return nil
+}
+
+// Rollback makes an earlier release current again and restarts the app on
+// it without rebuilding
+func (d *Deployer) Rollback(ctx context.Context, appID, releaseID string) error {
+	fields := errors.FieldMap{
+		"app_id":     appID,
+		"release_id": releaseID,
+	}
+
+	// Create timeout context
+	timeoutCtx, cancel := context.WithTimeout(ctx, d.config.DeployTimeout)
+	defer cancel()
+
+	d.logger.Info(timeoutCtx, "Rolling back application", fields)
+	deployLogFromContext(ctx).Printf("Rolling back to release %s", releaseID)
+
+	app, err := d.database.GetApp(timeoutCtx, appID)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to get app details")
+		d.logger.Error(timeoutCtx, wrappedErr, "App retrieval failed", fields)
+		return wrappedErr
+	}
+
+	state, err := d.loadState(d.releaseDir(appID, releaseID))
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "release is not available")
+		d.logger.Error(timeoutCtx, wrappedErr, "Release state read failed", fields)
+		return wrappedErr
+	}
+
+	if err := d.activate(timeoutCtx, app, releaseID, state); err != nil {
+		return err
+	}
+
+	d.logger.Info(timeoutCtx, "Application rolled back successfully", fields)
+	return nil
+}
+
+// activate runs a release next to the current process of the app on a
+// spare port. Once the new process accepts connections the proxy route and
+// the current release are switched over to it, and the old process is
+// drained and stopped. Until then the old process keeps serving traffic.
+func (d *Deployer) activate(ctx context.Context, app *db.App, releaseID string, state deployState) error {
+	if state.Site {
+		return d.activateSite(ctx, app, releaseID, state)
+	}
+
+	fields := errors.FieldMap{
+		"app_id":     app.ID,
+		"release_id": releaseID,
+	}
+	deployLog := deployLogFromContext(ctx)
+	appDir := filepath.Join(d.config.AppsDir, app.ID)
+
+	// The running process holds on to its port until it is drained, so the
+	// new one may listen on another port than the configured one
+	configuredPort := state.Port
+	if !portAvailable(state.Port) {
+		port, err := freePort()
+		if err != nil {
+			wrappedErr := errors.Wrap(err, "failed to find a free port")
+			d.logger.Error(ctx, wrappedErr, "Port allocation failed", fields)
+			return wrappedErr
+		}
+		state.Port = port
+	}
+	fields["port"] = state.Port
+
+	// Start the new process next to the current one
+	deployLog.Printf("Starting release %s on port %d (output goes to %s)",
+		releaseID, state.Port, filepath.Join(appDir, "app.log"))
+	if err := d.supervisor.StageApp(app.ID, d.processCommand(app, releaseID, state)); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to start app")
+		d.logger.Error(ctx, wrappedErr, "App start failed", fields)
+		return wrappedErr
+	}
+
+	// Only route traffic to the new process once it is ready
+	deployLog.Printf("Waiting for port %d to accept connections", state.Port)
+	if err := d.waitForReady(ctx, app.ID, state.Port, healthCheckPath(state)); err != nil {
+		d.discard(ctx, app.ID, fields)
+		wrappedErr := errors.Wrap(err, "new release did not become ready")
+		d.logger.Error(ctx, wrappedErr, "Health check failed", fields)
+		return wrappedErr
 	}
 
-	// Set database path if app uses SQLite
-	if buildResult.HasDatabase {
-		dbPath := filepath.Join(dbDir, "app.db")
-		env["DATABASE_URL"] = fmt.Sprintf("sqlite://%s", dbPath)
-		fields["db_path"] = dbPath
+	// Point current at the release
+	previous, _ := d.CurrentRelease(app.ID)
+	deployLog.Printf("Switching current release to %s", releaseID)
+	if err := d.switchCurrent(app.ID, releaseID); err != nil {
+		d.discard(ctx, app.ID, fields)
+		wrappedErr := errors.Wrap(err, "failed to switch current release")
+		d.logger.Error(ctx, wrappedErr, "Release switch failed", fields)
+		return wrappedErr
+	}
 
-		// Configure database backup if enabled
-		if d.config.BackupDatabases && d.backup != nil {
-			if err := d.backup.AddDatabase(appID, dbPath); err != nil {
+	// Switch the proxy route over to the new process
+	deployLog.Printf("Routing %s to port %d", app.Domain, state.Port)
+	if err := d.addRoute(app, state.Port, state); err != nil {
+		d.discard(ctx, app.ID, fields)
+		previousState := deployState{}
+		if previous != "" {
+			if err := d.switchCurrent(app.ID, previous); err != nil {
+				d.logger.Warn(ctx, "Failed to restore previous release",
+					errors.WithField(fields, "error", err.Error()))
+			}
+			previousState, _ = d.loadState(d.releaseDir(app.ID, previous))
+		}
+		previousPort := previousState.LivePort
+		if previousPort == 0 {
+			previousPort = app.Port
+		}
+		if previous != "" && previousPort != 0 && !previousState.Site {
+			if err := d.addRoute(app, previousPort, previousState); err != nil {
+				d.logger.Warn(ctx, "Failed to restore previous proxy route",
+					errors.WithField(fields, "error", err.Error()))
+			}
+		}
+
+		wrappedErr := errors.Wrap(err, "failed to configure proxy")
+		d.logger.Error(ctx, wrappedErr, "Proxy configuration failed", fields)
+		return wrappedErr
+	}
+
+	// Hand over to the new process and drain the old one
+	if err := d.supervisor.PromoteApp(app.ID, d.config.DrainTimeout); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to promote new release")
+		d.logger.Error(ctx, wrappedErr, "App promotion failed", fields)
+		return wrappedErr
+	}
+	deployLog.Printf("Draining previous process for %s", d.config.DrainTimeout)
+
+	// Record the port the release listens on, which restarts keep so the
+	// proxy route stays valid, next to the configured one
+	live := state
+	live.Port, live.LivePort = configuredPort, state.Port
+	if err := d.saveState(d.releaseDir(app.ID, releaseID), live); err != nil {
+		d.logger.Warn(ctx, "Failed to record the port of the release",
+			errors.WithField(fields, "error", err.Error()))
+	}
+
+	// Keep checking the declared health check path unless a health check
+	// was configured for the app explicitly
+	if _, err := d.database.GetHealthCheck(ctx, app.ID); errors.Is(err, errors.ErrRecordNotFound) {
+		if path := healthCheckPath(state); path != "" {
+			d.supervisor.SetHealthCheck(app.ID, supervisor.HealthCheck{Type: db.HealthCheckHTTP, Path: path})
+		} else {
+			d.supervisor.RemoveHealthCheck(app.ID)
+		}
+	}
+
+	// Configure database backups if the app uses SQLite
+	if d.config.BackupDatabases && d.backup != nil {
+		for _, database := range d.databases(app.ID, state) {
+			if err := d.backup.AddDatabase(database.key, database.path); err != nil {
 				// Log but continue
-				d.logger.Warn(timeoutCtx, "Failed to configure database backup",
+				d.logger.Warn(ctx, "Failed to configure database backup",
 					errors.WithField(fields, "error", err.Error()))
 			}
 		}
 	}
 
-	// Set HOME directory
-	env["HOME"] = appDir
+	// Update app status in database
+	app.Status = "running"
+	app.LastDeploy = time.Now()
+	app.UpdatedAt = time.Now()
 
-	// Convert env map to slice for supervisor
-	envSlice := make([]string, 0, len(env))
-	for k, v := range env {
-		envSlice = append(envSlice, fmt.Sprintf("%s=%s", k, v))
+	if err := d.database.UpdateApp(ctx, app); err != nil {
+		// Log but continue - the app is running
+		d.logger.Warn(ctx, "Failed to update app status in database",
+			errors.WithField(fields, "error", err.Error()))
 	}
 
-	// Configure proxy
-	if err := d.proxy.AddRoute(appID, app.Domain, port); err != nil {
-		wrappedErr := errors.Wrap(err, "failed to configure proxy")
-		d.logger.Error(timeoutCtx, wrappedErr, "Proxy configuration failed", fields)
+	return nil
+}
+
+// addRoute routes the domain of an app to a port. Apps that opted in have
+// their static assets served by the proxy from the current release.
+func (d *Deployer) addRoute(app *db.App, port int, state deployState) error {
+	if !state.HasStatic || state.Manifest == nil || !state.Manifest.ServeStatic {
+		return d.proxy.AddRoute(app.ID, app.Domain, port)
+	}
+
+	prefix := state.Manifest.StaticPath
+	if prefix == "" {
+		prefix = "/static"
+	}
+	root, err := filepath.Abs(filepath.Join(d.config.AppsDir, app.ID, "static"))
+	if err != nil {
+		return err
+	}
+
+	return d.proxy.AddAssetsRoute(app.ID, app.Domain, port, prefix, root)
+}
+
+// activateSite switches a static site over to a release. The proxy serves
+// the site of the current release, so no process is started, and the process
+// of an earlier release that was not a static site is stopped.
+func (d *Deployer) activateSite(ctx context.Context, app *db.App, releaseID string, state deployState) error {
+	fields := errors.FieldMap{
+		"app_id":     app.ID,
+		"release_id": releaseID,
+	}
+	deployLog := deployLogFromContext(ctx)
+
+	root, err := d.siteRoot(app.ID)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to resolve site directory")
+		d.logger.Error(ctx, wrappedErr, "Site directory resolution failed", fields)
 		return wrappedErr
 	}
 
-	// Start the app
-	if err := d.supervisor.StartApp(appID, appBinaryPath, envSlice); err != nil {
-		wrappedErr := errors.Wrap(err, "failed to start app")
-		d.logger.Error(timeoutCtx, wrappedErr, "App start failed", fields)
+	// Point current at the release
+	previous, _ := d.CurrentRelease(app.ID)
+	deployLog.Printf("Switching current release to %s", releaseID)
+	if err := d.switchCurrent(app.ID, releaseID); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to switch current release")
+		d.logger.Error(ctx, wrappedErr, "Release switch failed", fields)
+		return wrappedErr
+	}
 
-		// Try to clean up proxy route
-		if cleanupErr := d.proxy.RemoveRoute(appID); cleanupErr != nil {
-			d.logger.Warn(timeoutCtx, "Failed to clean up proxy route after failed deployment",
-				errors.WithField(fields, "error", cleanupErr.Error()))
+	deployLog.Printf("Serving %s from %s", app.Domain, root)
+	if err := d.proxy.AddStaticRoute(app.ID, app.Domain, root, spa(state)); err != nil {
+		if previous != "" {
+			if err := d.switchCurrent(app.ID, previous); err != nil {
+				d.logger.Warn(ctx, "Failed to restore previous release",
+					errors.WithField(fields, "error", err.Error()))
+			}
 		}
 
+		wrappedErr := errors.Wrap(err, "failed to configure proxy")
+		d.logger.Error(ctx, wrappedErr, "Proxy configuration failed", fields)
 		return wrappedErr
 	}
 
+	if _, err := d.supervisor.GetStatus(app.ID); err == nil {
+		deployLog.Printf("Stopping the process of the previous release")
+		if err := d.supervisor.StopApp(app.ID); err != nil {
+			d.logger.Warn(ctx, "Failed to stop previous process",
+				errors.WithField(fields, "error", err.Error()))
+		}
+	}
+	d.supervisor.RemoveHealthCheck(app.ID)
+
 	// Update app status in database
 	app.Status = "running"
 	app.LastDeploy = time.Now()
 	app.UpdatedAt = time.Now()
-	app.Port = port
 
-	if err := d.database.UpdateApp(timeoutCtx, app); err != nil {
-		// Log but continue - the app is running
-		d.logger.Warn(timeoutCtx, "Failed to update app status in database",
+	if err := d.database.UpdateApp(ctx, app); err != nil {
+		// Log but continue - the site is served
+		d.logger.Warn(ctx, "Failed to update app status in database",
 			errors.WithField(fields, "error", err.Error()))
 	}
 
-	d.logger.Info(timeoutCtx, "Application deployed successfully", fields)
+	return nil
+}
+
+// siteRoot returns the absolute site directory of the current release of an
+// app, which the proxy serves static sites from
+func (d *Deployer) siteRoot(appID string) (string, error) {
+	return filepath.Abs(filepath.Join(d.config.AppsDir, appID, "current", staticSiteDir))
+}
+
+// spa reports whether a static site falls back to index.html
+func spa(state deployState) bool {
+	return state.Manifest != nil && state.Manifest.SPA
+}
+
+// waitForReady waits until the staged process of an app accepts connections
+// on its port, or answers its health check path if it has one
+func (d *Deployer) waitForReady(ctx context.Context, appID string, port int, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, d.config.HealthTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		status, err := d.supervisor.GetStagedStatus(appID)
+		if err != nil {
+			return err
+		}
+		if status != "running" {
+			return fmt.Errorf("process %s before accepting connections", status)
+		}
+
+		if path == "" && portListening(ctx, port) {
+			return nil
+		}
+		if path != "" && pathHealthy(ctx, port, path) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if path != "" {
+				return fmt.Errorf("%s on port %d not healthy after %s", path, port, d.config.HealthTimeout)
+			}
+			return fmt.Errorf("port %d not accepting connections after %s", port, d.config.HealthTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// discard stops the staged process of an app after a failed activation
+func (d *Deployer) discard(ctx context.Context, appID string, fields errors.FieldMap) {
+	if err := d.supervisor.DiscardApp(appID); err != nil {
+		d.logger.Warn(ctx, "Failed to stop new release",
+			errors.WithField(fields, "error", err.Error()))
+	}
+}
+
+// Start starts the current release of an app with the environment it was
+// deployed with
+func (d *Deployer) Start(ctx context.Context, appID string) error {
+	fields := errors.FieldMap{"app_id": appID}
+
+	app, err := d.database.GetApp(ctx, appID)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to get app details")
+		d.logger.Error(ctx, wrappedErr, "App retrieval failed", fields)
+		return wrappedErr
+	}
+
+	releaseID, err := d.CurrentRelease(appID)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "app has not been deployed")
+		d.logger.Error(ctx, wrappedErr, "Current release lookup failed", fields)
+		return wrappedErr
+	}
+
+	releaseDir := d.releaseDir(appID, releaseID)
+	state, err := d.loadState(releaseDir)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to read release state")
+		d.logger.Error(ctx, wrappedErr, "Release state read failed", fields)
+		return wrappedErr
+	}
+
+	if state.Site {
+		root, err := d.siteRoot(appID)
+		if err == nil {
+			err = d.proxy.AddStaticRoute(appID, app.Domain, root, spa(state))
+		}
+		if err != nil {
+			wrappedErr := errors.Wrap(err, "failed to configure proxy")
+			d.logger.Error(ctx, wrappedErr, "Proxy configuration failed", fields)
+			return wrappedErr
+		}
+
+		d.setStatus(ctx, app, "running")
+		return nil
+	}
+
+	// Listen on the port the proxy routes to, which differs from the
+	// configured one after blue/green deployments. Releases that went live
+	// before the port was recorded listen on the port of the app.
+	if state.LivePort != 0 {
+		state.Port = state.LivePort
+	} else if app.Port != 0 {
+		state.Port = app.Port
+	}
+
+	if err := d.supervisor.StartApp(appID, d.processCommand(app, releaseID, state)); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to start app")
+		d.logger.Error(ctx, wrappedErr, "App start failed", fields)
+		return wrappedErr
+	}
+
+	d.setStatus(ctx, app, "running")
+	return nil
+}
+
+// Stop stops the running process of an app
+func (d *Deployer) Stop(ctx context.Context, appID string) error {
+	fields := errors.FieldMap{"app_id": appID}
+
+	app, err := d.database.GetApp(ctx, appID)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to get app details")
+		d.logger.Error(ctx, wrappedErr, "App retrieval failed", fields)
+		return wrappedErr
+	}
+
+	// Static sites have no process, they are stopped by no longer serving them
+	if releaseID, err := d.CurrentRelease(appID); err == nil {
+		if state, err := d.loadState(d.releaseDir(appID, releaseID)); err == nil && state.Site {
+			if err := d.proxy.RemoveRoute(appID); err != nil {
+				wrappedErr := errors.Wrap(err, "failed to remove proxy route")
+				d.logger.Error(ctx, wrappedErr, "Proxy configuration failed", fields)
+				return wrappedErr
+			}
+
+			d.setStatus(ctx, app, "stopped")
+			return nil
+		}
+	}
+
+	if err := d.supervisor.StopApp(appID); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to stop app")
+		d.logger.Error(ctx, wrappedErr, "App stop failed", fields)
+		return wrappedErr
+	}
+
+	d.setStatus(ctx, app, "stopped")
+	return nil
+}
+
+// Restart restarts the process of an app, starting it if the supervisor is
+// not managing it yet
+func (d *Deployer) Restart(ctx context.Context, appID string) error {
+	fields := errors.FieldMap{"app_id": appID}
+
+	if _, err := d.supervisor.GetStatus(appID); err != nil {
+		return d.Start(ctx, appID)
+	}
+
+	app, err := d.database.GetApp(ctx, appID)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to get app details")
+		d.logger.Error(ctx, wrappedErr, "App retrieval failed", fields)
+		return wrappedErr
+	}
+
+	if err := d.supervisor.RestartApp(appID); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to restart app")
+		d.logger.Error(ctx, wrappedErr, "App restart failed", fields)
+		return wrappedErr
+	}
+
+	d.setStatus(ctx, app, "running")
 	return nil
 }
 
@@ -291,12 +763,25 @@ func (d *Deployer) Undeploy(ctx context.Context, appID string) error {
 			errors.WithField(fields, "error", err.Error()))
 	}
 
-	// Remove database backup
+	// Remove database backups
 	if d.backup != nil {
-		if err := d.backup.RemoveDatabase(appID); err != nil {
-			// Log but continue
-			d.logger.Warn(timeoutCtx, "Failed to remove database backup",
-				errors.WithField(fields, "error", err.Error()))
+		keys := []string{appID}
+		if releaseID, err := d.CurrentRelease(appID); err == nil {
+			if state, err := d.loadState(d.releaseDir(appID, releaseID)); err == nil {
+				for _, database := range d.databases(appID, state) {
+					if database.key != appID {
+						keys = append(keys, database.key)
+					}
+				}
+			}
+		}
+
+		for _, key := range keys {
+			if err := d.backup.RemoveDatabase(key); err != nil {
+				// Log but continue
+				d.logger.Warn(timeoutCtx, "Failed to remove database backup",
+					errors.WithField(fields, "error", err.Error()))
+			}
 		}
 	}
 
@@ -323,3 +808,212 @@ func (d *Deployer) Undeploy(ctx context.Context, appID string) error {
 	d.logger.Info(timeoutCtx, "Application undeployed successfully", fields)
 	return nil
 }
+
+// buildEnv builds the process environment for an app
+func (d *Deployer) buildEnv(app *db.App, state deployState) []string {
+	env := make(map[string]string)
+
+	// Add default environment variables
+	for k, v := range d.config.DefaultEnv {
+		env[k] = v
+	}
+
+	// Add variables the build asked for
+	for k, v := range state.Environment {
+		env[k] = v
+	}
+
+	env["PORT"] = strconv.Itoa(state.Port)
+
+	// Set app-specific env variables
+	for _, e := range app.Environment {
+		env[e.Key] = e.Value
+	}
+
+	// Set database paths if app uses SQLite
+	for _, database := range d.databases(app.ID, state) {
+		env[database.env] = fmt.Sprintf("sqlite://%s", database.path)
+	}
+
+	// Set HOME directory
+	env["HOME"] = filepath.Join(d.config.AppsDir, app.ID)
+
+	// Convert env map to slice for supervisor
+	envSlice := make([]string, 0, len(env))
+	for k, v := range env {
+		envSlice = append(envSlice, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	return envSlice
+}
+
+// appDatabase is a SQLite database of a deployed app
+type appDatabase struct {
+	key  string // Backup key
+	path string
+	env  string // Variable holding the database URL
+}
+
+// databases returns the SQLite databases of a release. Apps that declare no
+// databases get a single app.db in DATABASE_URL when they use SQLite.
+func (d *Deployer) databases(appID string, state deployState) []appDatabase {
+	dbDir := filepath.Join(d.config.DataDir, appID, "db")
+
+	if state.Manifest == nil || len(state.Manifest.Databases) == 0 {
+		if !state.HasDatabase {
+			return nil
+		}
+		return []appDatabase{{key: appID, path: filepath.Join(dbDir, "app.db"), env: "DATABASE_URL"}}
+	}
+
+	databases := make([]appDatabase, 0, len(state.Manifest.Databases))
+	for i, declared := range state.Manifest.Databases {
+		database := appDatabase{
+			key:  appID + "/" + declared.Name,
+			path: filepath.Join(dbDir, declared.Name+".db"),
+			env:  declared.Env,
+		}
+
+		// The first database is the app's main one
+		if i == 0 {
+			database.key = appID
+		}
+		if database.env == "" {
+			database.env = strings.ToUpper(strings.ReplaceAll(declared.Name, "-", "_")) + "_DATABASE_URL"
+			if i == 0 {
+				database.env = "DATABASE_URL"
+			}
+		}
+
+		databases = append(databases, database)
+	}
+
+	return databases
+}
+
+// processCommand returns how the process of a release is run: its binary,
+// or the start command of an app deployed as a directory
+func (d *Deployer) processCommand(app *db.App, releaseID string, state deployState) supervisor.Command {
+	env := d.releaseEnv(app, releaseID, state)
+	command := supervisor.Command{
+		Path: filepath.Join(d.releaseDir(app.ID, releaseID), "bin", "app"),
+		Env:  env,
+	}
+
+	if len(state.Command) > 0 {
+		command.Path = state.Command[0]
+		command.Args = append([]string(nil), state.Command[1:]...)
+		command.Dir = d.workDir(app.ID, releaseID, state)
+	}
+	command.Args = append(command.Args, runArgs(state, env)...)
+
+	return command
+}
+
+// releaseEnv builds the process environment of a release, with its version
+// and the directories of its interpreter and tools in front of PATH
+func (d *Deployer) releaseEnv(app *db.App, releaseID string, state deployState) []string {
+	env := append(d.buildEnv(app, state), versionEnv(releaseID, state)...)
+	if len(state.BinDirs) == 0 {
+		return env
+	}
+
+	workDir := d.workDir(app.ID, releaseID, state)
+	dirs := make([]string, 0, len(state.BinDirs)+1)
+	for _, dir := range state.BinDirs {
+		dirs = append(dirs, filepath.Join(workDir, dir))
+	}
+	dirs = append(dirs, os.Getenv("PATH"))
+
+	return append(env, "PATH="+strings.Join(dirs, string(os.PathListSeparator)))
+}
+
+// workDir returns the directory commands of a release run in
+func (d *Deployer) workDir(appID, releaseID string, state deployState) string {
+	if len(state.Command) > 0 {
+		return filepath.Join(d.releaseDir(appID, releaseID), "app")
+	}
+	return d.releaseDir(appID, releaseID)
+}
+
+// runHooks runs deployment hooks of a release in its directory with the
+// environment of the app
+func (d *Deployer) runHooks(ctx context.Context, app *db.App, releaseID string, state deployState, hooks []string) error {
+	deployLog := deployLogFromContext(ctx)
+	env := d.hookEnv(app, releaseID, state)
+
+	for _, hook := range hooks {
+		deployLog.Printf("$ %s", hook)
+
+		cmd := commandContext(ctx, "sh", "-c", hook)
+		cmd.Dir = d.workDir(app.ID, releaseID, state)
+		cmd.Env = env
+		if output, err := runCommand(ctx, cmd); err != nil {
+			return fmt.Errorf("%s: %w: %s", hook, err, output)
+		}
+	}
+
+	return nil
+}
+
+// hookEnv returns the environment of the hooks of a release. Hooks come from
+// the repository, so of Skyline's own environment they only see the variables
+// builds see, never its tokens and secrets.
+func (d *Deployer) hookEnv(app *db.App, releaseID string, state deployState) []string {
+	home, err := filepath.Abs(filepath.Join(d.config.DataDir, app.ID))
+	if err != nil {
+		home = filepath.Join(d.config.DataDir, app.ID)
+	}
+
+	env := []string{"HOME=" + home}
+	for _, name := range sandboxPassEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+
+	// Variables of the release take precedence
+	return append(env, d.releaseEnv(app, releaseID, state)...)
+}
+
+// runArgs returns the declared arguments of a release with variables such as
+// $PORT expanded from its environment
+func runArgs(state deployState, env []string) []string {
+	if state.Manifest == nil || len(state.Manifest.Run.Args) == 0 {
+		return nil
+	}
+
+	vars := make(map[string]string, len(env))
+	for _, e := range env {
+		if k, v, ok := strings.Cut(e, "="); ok {
+			vars[k] = v
+		}
+	}
+
+	args := make([]string, len(state.Manifest.Run.Args))
+	for i, arg := range state.Manifest.Run.Args {
+		args[i] = os.Expand(arg, func(name string) string { return vars[name] })
+	}
+
+	return args
+}
+
+// healthCheckPath returns the declared health check path of a release
+func healthCheckPath(state deployState) string {
+	if state.Manifest == nil {
+		return ""
+	}
+	return state.Manifest.HealthCheck
+}
+
+// setStatus updates the stored status of an app
+func (d *Deployer) setStatus(ctx context.Context, app *db.App, status string) {
+	app.Status = status
+	app.UpdatedAt = time.Now()
+
+	if err := d.database.UpdateApp(ctx, app); err != nil {
+		// Log but continue - the process state is what matters
+		d.logger.Warn(ctx, "Failed to update app status in database",
+			errors.FieldMap{"app_id": app.ID, "error": err.Error()})
+	}
+}
@@ -0,0 +1,301 @@
+package deploy
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// DeployKeyConfig contains configuration for the deploy keys of apps
+type DeployKeyConfig struct {
+	SecretKeyFile  string // Key deploy keys are encrypted with; created if missing
+	KnownHostsFile string // Host keys of git servers, recorded on first connection
+	KeyDir         string // Directory private keys are written to while git runs
+	SSHBinary      string
+}
+
+// DeployKeys generates the SSH deploy keys of apps and hands them to git.
+// Private keys are encrypted with AES-256-GCM before they are stored.
+type DeployKeys struct {
+	config   DeployKeyConfig
+	logger   errors.Logger
+	database *db.Database
+	aead     cipher.AEAD
+}
+
+// NewDeployKeys creates a new DeployKeys, creating the secret key if it does
+// not exist yet
+func NewDeployKeys(config DeployKeyConfig, logger errors.Logger, database *db.Database) (*DeployKeys, error) {
+	// Set defaults
+	if config.SecretKeyFile == "" {
+		config.SecretKeyFile = "data/system/secret.key"
+	}
+	if config.KnownHostsFile == "" {
+		config.KnownHostsFile = "data/system/known_hosts"
+	}
+	if config.KeyDir == "" {
+		config.KeyDir = "data/system/ssh"
+	}
+	if config.SSHBinary == "" {
+		config.SSHBinary = "ssh"
+	}
+
+	// git runs ssh in the checkout, so paths have to be absolute
+	for _, path := range []*string{&config.KnownHostsFile, &config.KeyDir} {
+		if abs, err := filepath.Abs(*path); err == nil {
+			*path = abs
+		}
+	}
+
+	secret, err := loadSecretKey(config.SecretKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load deploy key secret")
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid deploy key secret")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid deploy key secret")
+	}
+
+	return &DeployKeys{
+		config:   config,
+		logger:   logger,
+		database: database,
+		aead:     aead,
+	}, nil
+}
+
+// loadSecretKey reads the 32-byte secret key from a file, generating it first
+// if the file does not exist
+func loadSecretKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(secret) != 32 {
+			return nil, fmt.Errorf("%s does not hold a base64-encoded 32-byte key", path)
+		}
+		return secret, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(secret) + "\n"
+	if err := os.WriteFile(path, []byte(encoded), 0600); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Generate creates a new ed25519 deploy key for an app, replacing its
+// current key
+func (k *DeployKeys) Generate(ctx context.Context, appID string) (*db.DeployKey, error) {
+	fields := errors.FieldMap{"app_id": appID}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to generate deploy key")
+		k.logger.Error(ctx, wrappedErr, "Deploy key generation failed", fields)
+		return nil, wrappedErr
+	}
+
+	comment := "skyline-" + appID
+	encrypted, err := k.encrypt(appID, marshalOpenSSHPrivateKey(private, comment))
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to encrypt deploy key")
+		k.logger.Error(ctx, wrappedErr, "Deploy key generation failed", fields)
+		return nil, wrappedErr
+	}
+
+	key := &db.DeployKey{
+		AppID:               appID,
+		PublicKey:           marshalAuthorizedKey(public) + " " + comment,
+		Fingerprint:         fingerprintSHA256(public),
+		EncryptedPrivateKey: encrypted,
+	}
+	if err := k.database.SetDeployKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	k.logger.Info(ctx, "Deploy key generated", errors.WithField(fields, "fingerprint", key.Fingerprint))
+	return key, nil
+}
+
+// sshCommand returns the GIT_SSH_COMMAND that fetches with the deploy key of
+// an app, and a function removing the key from disk again. Apps without a
+// deploy key get an empty command.
+func (k *DeployKeys) sshCommand(ctx context.Context, appID string) (string, func(), error) {
+	noop := func() {}
+
+	key, err := k.database.GetDeployKey(ctx, appID)
+	if errors.Is(err, errors.ErrRecordNotFound) {
+		return "", noop, nil
+	}
+	if err != nil {
+		return "", noop, err
+	}
+
+	private, err := k.decrypt(appID, key.EncryptedPrivateKey)
+	if err != nil {
+		return "", noop, errors.Wrap(err, "failed to decrypt deploy key")
+	}
+
+	if err := os.MkdirAll(k.config.KeyDir, 0700); err != nil {
+		return "", noop, errors.Wrap(err, "failed to create deploy key directory")
+	}
+	file, err := os.CreateTemp(k.config.KeyDir, "deploy-key-*")
+	if err != nil {
+		return "", noop, errors.Wrap(err, "failed to write deploy key")
+	}
+	cleanup := func() { os.Remove(file.Name()) }
+	_, err = file.Write(private)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", noop, errors.Wrap(err, "failed to write deploy key")
+	}
+
+	// Only the deploy key is offered, and unknown hosts are recorded in the
+	// managed known_hosts file on first connection
+	command := strings.Join([]string{
+		shellQuote(k.config.SSHBinary),
+		"-F", "/dev/null",
+		"-i", shellQuote(file.Name()),
+		"-o", "IdentitiesOnly=yes",
+		"-o", "IdentityAgent=none",
+		"-o", "UserKnownHostsFile=" + shellQuote(k.config.KnownHostsFile),
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", "BatchMode=yes",
+	}, " ")
+
+	deployLogFromContext(ctx).Printf("Using deploy key %s", key.Fingerprint)
+	return command, cleanup, nil
+}
+
+// encrypt seals a private key, bound to its app
+func (k *DeployKeys) encrypt(appID string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, []byte(appID)), nil
+}
+
+// decrypt opens a private key sealed by encrypt
+func (k *DeployKeys) decrypt(appID string, ciphertext []byte) ([]byte, error) {
+	size := k.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, errors.New("encrypted deploy key is truncated")
+	}
+	return k.aead.Open(nil, ciphertext[:size], ciphertext[size:], []byte(appID))
+}
+
+type gitSSHCommandKey struct{}
+
+// withGitSSHCommand returns a context whose git commands connect with an
+// SSH command
+func withGitSSHCommand(ctx context.Context, command string) context.Context {
+	return context.WithValue(ctx, gitSSHCommandKey{}, command)
+}
+
+// gitSSHCommandFromContext returns the SSH command carried by ctx, if any
+func gitSSHCommandFromContext(ctx context.Context) string {
+	command, _ := ctx.Value(gitSSHCommandKey{}).(string)
+	return command
+}
+
+// isSSHURL reports whether git reaches a repository over SSH: ssh:// URLs
+// and the scp-like user@host:path syntax
+func isSSHURL(repoURL string) bool {
+	if strings.HasPrefix(repoURL, "ssh://") || strings.HasPrefix(repoURL, "git+ssh://") {
+		return true
+	}
+	if strings.Contains(repoURL, "://") {
+		return false
+	}
+	colon := strings.Index(repoURL, ":")
+	slash := strings.Index(repoURL, "/")
+	return colon > 0 && (slash < 0 || colon < slash)
+}
+
+// shellQuote quotes a word for the shell git runs GIT_SSH_COMMAND with
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// sshString appends a string in the SSH wire format
+func sshString(buf []byte, s []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// ed25519WireKey returns the public key in the SSH wire format
+func ed25519WireKey(public ed25519.PublicKey) []byte {
+	return sshString(sshString(nil, []byte("ssh-ed25519")), public)
+}
+
+// marshalAuthorizedKey returns the public key as in an authorized_keys file
+func marshalAuthorizedKey(public ed25519.PublicKey) string {
+	return "ssh-ed25519 " + base64.StdEncoding.EncodeToString(ed25519WireKey(public))
+}
+
+// fingerprintSHA256 returns the fingerprint ssh-keygen -l shows for a key
+func fingerprintSHA256(public ed25519.PublicKey) string {
+	sum := sha256.Sum256(ed25519WireKey(public))
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// marshalOpenSSHPrivateKey encodes an unencrypted private key in the
+// openssh-key-v1 format ssh reads
+func marshalOpenSSHPrivateKey(private ed25519.PrivateKey, comment string) []byte {
+	public := private.Public().(ed25519.PublicKey)
+
+	check := make([]byte, 4)
+	rand.Read(check)
+
+	var section []byte
+	section = append(section, check...)
+	section = append(section, check...)
+	section = sshString(section, []byte("ssh-ed25519"))
+	section = sshString(section, public)
+	section = sshString(section, private)
+	section = sshString(section, []byte(comment))
+	for i := byte(1); len(section)%8 != 0; i++ {
+		section = append(section, i)
+	}
+
+	data := []byte("openssh-key-v1\x00")
+	data = sshString(data, []byte("none"))
+	data = sshString(data, []byte("none"))
+	data = sshString(data, nil)
+	data = binary.BigEndian.AppendUint32(data, 1)
+	data = sshString(data, ed25519WireKey(public))
+	data = sshString(data, section)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: data})
+}
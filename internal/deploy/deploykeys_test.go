@@ -0,0 +1,126 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danbruder/skyline/internal/db"
+)
+
+func TestDeployKeys(t *testing.T) {
+	ctx := context.Background()
+	logger := newMockLogger(t)
+	dir := t.TempDir()
+
+	database, err := db.New(ctx, filepath.Join(dir, "test.db"), logger)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	app := &db.App{Name: "keys-test", RepoURL: "git@github.com:example/repo.git", Branch: "main", Domain: "example.com"}
+	if err := database.CreateApp(ctx, app); err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	config := DeployKeyConfig{
+		SecretKeyFile:  filepath.Join(dir, "secret.key"),
+		KnownHostsFile: filepath.Join(dir, "known_hosts"),
+		KeyDir:         filepath.Join(dir, "ssh"),
+	}
+	keys, err := NewDeployKeys(config, logger, database)
+	if err != nil {
+		t.Fatalf("NewDeployKeys() error = %v", err)
+	}
+
+	// Apps without a key fetch without one
+	command, cleanup, err := keys.sshCommand(ctx, app.ID)
+	if err != nil || command != "" {
+		t.Fatalf("sshCommand() without key = %q, %v, want no command", command, err)
+	}
+	cleanup()
+
+	key, err := keys.Generate(ctx, app.ID)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if !strings.HasPrefix(key.PublicKey, "ssh-ed25519 ") || !strings.HasSuffix(key.PublicKey, " skyline-"+app.ID) {
+		t.Errorf("Generate() public key = %q", key.PublicKey)
+	}
+	if strings.Contains(string(key.EncryptedPrivateKey), "OPENSSH PRIVATE KEY") {
+		t.Error("Generate() stored the private key unencrypted")
+	}
+
+	// The key survives reopening with the same secret
+	keys, err = NewDeployKeys(config, logger, database)
+	if err != nil {
+		t.Fatalf("NewDeployKeys() reopening error = %v", err)
+	}
+	command, cleanup, err = keys.sshCommand(ctx, app.ID)
+	if err != nil {
+		t.Fatalf("sshCommand() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(config.KeyDir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("key directory holds %v, %v, want one key", entries, err)
+	}
+	keyFile := filepath.Join(config.KeyDir, entries[0].Name())
+	if !strings.Contains(command, "-i '"+keyFile+"'") || !strings.Contains(command, "IdentitiesOnly=yes") {
+		t.Errorf("sshCommand() = %q, want it to use %s only", command, keyFile)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	// ssh reads the key and derives the stored public key from it
+	if sshKeygen, err := exec.LookPath("ssh-keygen"); err == nil {
+		output, err := exec.Command(sshKeygen, "-y", "-f", keyFile).Output()
+		if err != nil {
+			t.Fatalf("ssh-keygen -y error = %v", err)
+		}
+		if got := strings.TrimSpace(string(output)); got != key.PublicKey {
+			t.Errorf("ssh-keygen -y = %q, want %q", got, key.PublicKey)
+		}
+
+		output, err = exec.Command(sshKeygen, "-l", "-f", keyFile).Output()
+		if err != nil {
+			t.Fatalf("ssh-keygen -l error = %v", err)
+		}
+		if !strings.Contains(string(output), key.Fingerprint) {
+			t.Errorf("ssh-keygen -l = %q, want fingerprint %s", output, key.Fingerprint)
+		}
+	}
+
+	cleanup()
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("key file still exists after cleanup: %v", err)
+	}
+
+	// A key is bound to its app
+	if _, err := keys.decrypt("other-app", key.EncryptedPrivateKey); err == nil {
+		t.Error("decrypt() for another app succeeded")
+	}
+}
+
+func TestIsSSHURL(t *testing.T) {
+	tests := map[string]bool{
+		"git@github.com:example/repo.git":          true,
+		"ssh://git@gitlab.com/example/repo.git":    true,
+		"git+ssh://git@example.com/repo.git":       true,
+		"github.com:example/repo":                  true,
+		"https://github.com/example/repo":          false,
+		"http://git.example.com:3000/example/repo": false,
+		"/srv/git/repo.git":                        false,
+		"./repo:with-colon":                        false,
+	}
+	for url, want := range tests {
+		if got := isSSHURL(url); got != want {
+			t.Errorf("isSSHURL(%q) = %v, want %v", url, got, want)
+		}
+	}
+}
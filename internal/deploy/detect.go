@@ -0,0 +1,388 @@
+package deploy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Runtime settings an app gets when nothing else is known
+const defaultPort = 8080
+
+var (
+	// goSQLiteDrivers are Go packages that open SQLite databases
+	goSQLiteDrivers = []string{
+		"github.com/mattn/go-sqlite3",
+		"modernc.org/sqlite",
+		"github.com/glebarez/go-sqlite",
+		"github.com/ncruces/go-sqlite3",
+		"zombiezen.com/go/sqlite",
+		"crawshaw.io/sqlite",
+	}
+
+	// goCgoPackages are Go modules that only build with cgo
+	goCgoPackages = []string{"github.com/mattn/go-sqlite3"}
+
+	// rustSQLiteCrates are crates that link or open SQLite databases
+	rustSQLiteCrates = []string{"libsqlite3-sys", "rusqlite", "sqlx-sqlite", "sqlite", "sqlite3-sys"}
+
+	// goPortPatterns find the address a Go app listens on
+	goPortPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`ListenAndServe(?:TLS)?\(\s*"[^"]*:(\d+)"`),
+		regexp.MustCompile(`\bListen\(\s*"tcp[46]?"\s*,\s*"[^"]*:(\d+)"`),
+		regexp.MustCompile(`\.(?:Run|Start|Listen)\(\s*"[^"]*:(\d+)"`),
+		regexp.MustCompile(`\bAddr\s*:\s*"[^"]*:(\d+)"`),
+		regexp.MustCompile(`(?i)\bport\s*(?::=|=)\s*"(\d+)"`),
+	}
+
+	// rustPortPatterns find the address a Rust app listens on
+	rustPortPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\.bind\(\s*"[^"]*:(\d+)"`),
+		regexp.MustCompile(`\.bind\(\s*\(\s*"[^"]*"\s*,\s*(\d+)\s*\)`),
+		regexp.MustCompile(`SocketAddr::from\(\s*\(\s*\[[^\]]*\]\s*,\s*(\d+)\s*\)`),
+		regexp.MustCompile(`var\("PORT"\)[^;]*unwrap_or[^(]*\(\s*"?(\d+)`),
+		regexp.MustCompile(`\blet\s+(?:mut\s+)?port\b[^=;]*=\s*"?(\d+)`),
+	}
+
+	// sqliteFeaturePattern finds SQLite features of a Cargo dependency
+	sqliteFeaturePattern = regexp.MustCompile(`"[\w-]*sqlite[\w-]*"`)
+
+	// Directories that never hold the app's own sources
+	ignoredSourceDirs = map[string]bool{"vendor": true, "target": true, "node_modules": true, "testdata": true}
+)
+
+// Detection records a runtime setting of an app and why it was chosen, so
+// operators can see where settings came from
+type Detection struct {
+	Setting string `json:"setting"` // database, port or static
+	Value   string `json:"value"`
+	Reason  string `json:"reason"`
+}
+
+// runtimeInfo holds the runtime settings of an app
+type runtimeInfo struct {
+	HasDatabase bool
+	Port        int
+	HasStatic   bool
+	StaticDir   string
+	Detections  []Detection
+}
+
+// detect records a runtime setting and writes it to the deployment log
+func (r *runtimeInfo) detect(ctx context.Context, setting, value, reason string) {
+	r.Detections = append(r.Detections, Detection{Setting: setting, Value: value, Reason: reason})
+	deployLogFromContext(ctx).Printf("Detected %s: %s (%s)", setting, value, reason)
+}
+
+// detectRuntime determines whether the app uses a database, the port it
+// listens on and its static assets, preferring what the manifest declares.
+// The stack detects database use and the port from the source.
+func detectRuntime(ctx context.Context, sourceDir string, manifest *Manifest,
+	usesSQLite func() (bool, string), listenPort func() (int, string)) runtimeInfo {
+	var info runtimeInfo
+
+	if len(manifest.Databases) > 0 {
+		info.HasDatabase = true
+		info.detect(ctx, "database", "yes", "databases declared in "+ManifestFile)
+	} else {
+		var reason string
+		info.HasDatabase, reason = usesSQLite()
+		info.detect(ctx, "database", yesNo(info.HasDatabase), reason)
+	}
+
+	if manifest.Run.Port != 0 {
+		info.Port = manifest.Run.Port
+		info.detect(ctx, "port", strconv.Itoa(info.Port), "run.port declared in "+ManifestFile)
+	} else {
+		var reason string
+		info.Port, reason = listenPort()
+		info.detect(ctx, "port", strconv.Itoa(info.Port), reason)
+	}
+
+	if manifest.Static != "" {
+		info.HasStatic, info.StaticDir = true, filepath.Join(sourceDir, manifest.Static)
+		info.detect(ctx, "static", manifest.Static, "static declared in "+ManifestFile)
+	} else if info.HasStatic, info.StaticDir = detectStaticAssets(sourceDir); info.HasStatic {
+		info.detect(ctx, "static", filepath.Base(info.StaticDir), "directory found in the source")
+	}
+
+	return info
+}
+
+// goUsesSQLite checks the dependencies of a Go main package for a SQLite
+// driver. It asks go list for the packages the main package imports, and
+// falls back to the requirements in go.mod when go list fails.
+func (b *Builder) goUsesSQLite(ctx context.Context, sourceDir, mainDir string, env []string) (bool, string) {
+	cmd := commandContext(ctx, b.config.GoBinary, "list", "-deps", "-f", "{{.ImportPath}}", ".")
+	cmd.Dir = mainDir
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err == nil {
+		for _, pkg := range strings.Fields(string(output)) {
+			for _, driver := range goSQLiteDrivers {
+				if pkg == driver || strings.HasPrefix(pkg, driver+"/") {
+					return true, fmt.Sprintf("main package depends on %s", pkg)
+				}
+			}
+		}
+		return false, "no SQLite driver among the dependencies of the main package"
+	}
+
+	requires, modErr := goModRequires(sourceDir)
+	if modErr != nil {
+		return false, "no go.mod to read dependencies from"
+	}
+	for _, module := range requires {
+		for _, driver := range goSQLiteDrivers {
+			if module == driver {
+				return true, fmt.Sprintf("go.mod requires %s", module)
+			}
+		}
+	}
+	return false, "no SQLite driver required in go.mod"
+}
+
+// goRequiresCgo checks whether a Go module requires a package that only
+// builds with cgo, such as the mattn/go-sqlite3 driver
+func goRequiresCgo(dir string) bool {
+	requires, err := goModRequires(dir)
+	if err != nil {
+		return false
+	}
+	for _, module := range requires {
+		for _, pkg := range goCgoPackages {
+			if module == pkg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// goModRequires returns the module paths required in the go.mod of a
+// directory, including indirect requirements
+func goModRequires(dir string) ([]string, error) {
+	file, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var requires []string
+	inBlock := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+
+		switch {
+		case len(fields) == 0:
+		case inBlock && fields[0] == ")":
+			inBlock = false
+		case inBlock:
+			requires = append(requires, strings.Trim(fields[0], `"`))
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+		case fields[0] == "require" && len(fields) >= 3:
+			requires = append(requires, strings.Trim(fields[1], `"`))
+		}
+	}
+
+	return requires, scanner.Err()
+}
+
+// rustUsesSQLite checks the crates of a Rust app for SQLite. Cargo.lock lists
+// every crate that is linked in; without it the dependencies and features in
+// Cargo.toml are checked.
+func rustUsesSQLite(dir string) (bool, string) {
+	if crates, err := cargoLockPackages(dir); err == nil {
+		for _, crate := range crates {
+			for _, sqlite := range rustSQLiteCrates {
+				if crate == sqlite {
+					return true, fmt.Sprintf("Cargo.lock contains %s", crate)
+				}
+			}
+		}
+		return false, "no SQLite crate in Cargo.lock"
+	}
+
+	deps, err := cargoDependencies(dir)
+	if err != nil {
+		return false, "no Cargo.toml to read dependencies from"
+	}
+	for _, dep := range deps {
+		for _, sqlite := range rustSQLiteCrates {
+			if dep.Name == sqlite {
+				return true, fmt.Sprintf("Cargo.toml depends on %s", dep.Name)
+			}
+		}
+		if dep.SQLiteFeature {
+			return true, fmt.Sprintf("Cargo.toml enables the sqlite feature of %s", dep.Name)
+		}
+	}
+	return false, "no SQLite crate in Cargo.toml"
+}
+
+// cargoLockPackages returns the names of the packages in Cargo.lock
+func cargoLockPackages(dir string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "Cargo.lock"))
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []string
+	inPackage := false
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inPackage = line == "[[package]]"
+			continue
+		}
+		if key, value, ok := tomlKeyValue(line); inPackage && ok && key == "name" {
+			packages = append(packages, strings.Trim(value, `"`))
+		}
+	}
+
+	return packages, nil
+}
+
+// cargoDependency is a dependency declared in Cargo.toml
+type cargoDependency struct {
+	Name          string
+	SQLiteFeature bool
+}
+
+// cargoDependencies returns the dependencies declared in Cargo.toml, in
+// [dependencies], [workspace.dependencies], target-specific tables and
+// [dependencies.<name>] tables
+func cargoDependencies(dir string) ([]cargoDependency, error) {
+	content, err := os.ReadFile(filepath.Join(dir, "Cargo.toml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var deps []cargoDependency
+	inDependencies := false
+	current := -1 // Dependency of a [dependencies.<name>] table
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			table := strings.Trim(line, "[] ")
+			inDependencies, current = false, -1
+
+			switch {
+			case table == "dependencies" || strings.HasSuffix(table, ".dependencies"):
+				inDependencies = true
+			case strings.Contains(table, "dependencies."):
+				name := table[strings.LastIndex(table, "dependencies.")+len("dependencies."):]
+				deps = append(deps, cargoDependency{Name: strings.Trim(name, `"`)})
+				current = len(deps) - 1
+			}
+			continue
+		}
+
+		key, value, ok := tomlKeyValue(line)
+		switch {
+		case !ok:
+		case inDependencies:
+			deps = append(deps, cargoDependency{Name: key, SQLiteFeature: hasSQLiteFeature(value)})
+		case current >= 0 && key == "features":
+			deps[current].SQLiteFeature = hasSQLiteFeature(value)
+		}
+	}
+
+	return deps, nil
+}
+
+// tomlKeyValue splits a TOML key/value line
+func tomlKeyValue(line string) (string, string, bool) {
+	if strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", "", false
+	}
+	return strings.Trim(strings.TrimSpace(key), `"`), strings.TrimSpace(value), true
+}
+
+// hasSQLiteFeature checks a dependency value for a SQLite feature, as in
+// sqlx = { version = "0.7", features = ["sqlite"] }
+func hasSQLiteFeature(value string) bool {
+	return sqliteFeaturePattern.MatchString(value)
+}
+
+// findListenPort scans the source files with an extension below dirs, in
+// order, for the port an app listens on. It returns the default port when
+// no listen address is found.
+func findListenPort(sourceDir string, dirs []string, ext string, patterns []*regexp.Regexp) (int, string) {
+	seen := make(map[string]bool)
+
+	for _, dir := range dirs {
+		var port int
+		var reason string
+
+		filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if entry.IsDir() {
+				if path != dir && (strings.HasPrefix(entry.Name(), ".") || ignoredSourceDirs[entry.Name()]) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(path) != ext || strings.HasSuffix(path, "_test.go") || seen[path] {
+				return nil
+			}
+			seen[path] = true
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			for _, pattern := range patterns {
+				match := pattern.FindSubmatchIndex(content)
+				if match == nil {
+					continue
+				}
+				n, err := strconv.Atoi(string(content[match[2]:match[3]]))
+				if err != nil || n < 1 || n > 65535 {
+					continue
+				}
+
+				rel, err := filepath.Rel(sourceDir, path)
+				if err != nil {
+					rel = path
+				}
+				line := strings.Count(string(content[:match[0]]), "\n") + 1
+				port = n
+				reason = fmt.Sprintf("%s:%d: %s", filepath.ToSlash(rel), line, content[match[0]:match[1]])
+				return filepath.SkipAll
+			}
+			return nil
+		})
+
+		if port != 0 {
+			return port, reason
+		}
+	}
+
+	return defaultPort, "no listen address found in the source, using the default"
+}
+
+// yesNo formats a boolean setting
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,240 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danbruder/skyline/internal/db"
+)
+
+func TestGoModRequires(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": `module example.com/app
+
+go 1.21
+
+require github.com/go-chi/chi/v5 v5.0.10
+
+require (
+	// Database
+	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	"modernc.org/sqlite" v1.28.0
+)
+`})
+
+	got, err := goModRequires(dir)
+	if err != nil {
+		t.Fatalf("goModRequires() error = %v", err)
+	}
+	want := []string{"github.com/go-chi/chi/v5", "github.com/mattn/go-sqlite3", "modernc.org/sqlite"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("goModRequires() = %q, want %q", got, want)
+	}
+	if !goRequiresCgo(dir) {
+		t.Error("goRequiresCgo() = false for a module requiring go-sqlite3")
+	}
+}
+
+func TestGoUsesSQLite(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":  "module example.com/app\n\ngo 1.21\n\nrequire modernc.org/sqlite v1.28.0\n",
+		"main.go": "package main\n\nimport \"net/http\"\n\nfunc main() { http.ListenAndServe(\":3000\", nil) }\n",
+	})
+
+	// Without go list, the requirements in go.mod decide
+	b := NewBuilder(BuildConfig{GoBinary: filepath.Join(dir, "missing-go"), OutputDir: filepath.Join(t.TempDir(), "builds")}, newMockLogger(t))
+	got, reason := b.goUsesSQLite(ctx, dir, dir, nil)
+	if !got || reason != "go.mod requires modernc.org/sqlite" {
+		t.Errorf("goUsesSQLite() without go list = %v (%s), want the go.mod requirement", got, reason)
+	}
+
+	// go list knows the main package does not import the driver
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not installed")
+	}
+	b = NewBuilder(BuildConfig{GoBinary: goBinary, OutputDir: filepath.Join(t.TempDir(), "builds")}, newMockLogger(t))
+	got, reason = b.goUsesSQLite(ctx, dir, dir, append(b.buildEnv(db.BuildSettings{}), "GOFLAGS=-mod=mod", "GOPROXY=off"))
+	if got {
+		t.Errorf("goUsesSQLite() with go list = true (%s), want false for an unused requirement", reason)
+	}
+}
+
+func TestRustUsesSQLite(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		want       bool
+		wantReason string
+	}{
+		{
+			name: "Cargo.lock",
+			files: map[string]string{
+				"Cargo.toml": "[dependencies]\nsqlx = { version = \"0.7\" }\n",
+				"Cargo.lock": "[[package]]\nname = \"app\"\nversion = \"0.1.0\"\n\n[[package]]\nname = \"libsqlite3-sys\"\nversion = \"0.27.0\"\n",
+			},
+			want:       true,
+			wantReason: "Cargo.lock contains libsqlite3-sys",
+		},
+		{
+			name: "Cargo.lock without SQLite",
+			files: map[string]string{
+				"Cargo.toml": "[dependencies]\nrusqlite = \"0.30\"\n",
+				"Cargo.lock": "[[package]]\nname = \"app\"\n\n[metadata]\nname = \"rusqlite\"\n",
+			},
+			wantReason: "no SQLite crate in Cargo.lock",
+		},
+		{
+			name:       "Cargo.toml feature",
+			files:      map[string]string{"Cargo.toml": "[package]\nname = \"sqlite-tools\"\n\n[dependencies.sqlx]\nversion = \"0.7\"\nfeatures = [\"runtime-tokio\", \"sqlite\"]\n"},
+			want:       true,
+			wantReason: "Cargo.toml enables the sqlite feature of sqlx",
+		},
+		{
+			name:       "Cargo.toml target dependency",
+			files:      map[string]string{"Cargo.toml": "[target.'cfg(unix)'.dependencies]\n\"rusqlite\" = { version = \"0.30\", features = [\"bundled\"] }\n"},
+			want:       true,
+			wantReason: "Cargo.toml depends on rusqlite",
+		},
+		{
+			name:       "Package name only",
+			files:      map[string]string{"Cargo.toml": "[package]\nname = \"sqlite-browser\"\n\n[dependencies]\nserde = \"1\"\n"},
+			wantReason: "no SQLite crate in Cargo.toml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			got, reason := rustUsesSQLite(dir)
+			if got != tt.want || reason != tt.wantReason {
+				t.Errorf("rustUsesSQLite() = %v (%s), want %v (%s)", got, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestFindListenPort(t *testing.T) {
+	tests := []struct {
+		name       string
+		ext        string
+		files      map[string]string
+		want       int
+		wantReason string
+	}{
+		{
+			name: "Nested Go package",
+			ext:  ".go",
+			files: map[string]string{
+				"go.mod":                   "module example.com/app\n",
+				"internal/web/server.go":   "package web\n\nfunc Run() error {\n\treturn http.ListenAndServe(\"0.0.0.0:4000\", nil)\n}\n",
+				"internal/web/web_test.go": "package web\n\nvar addr = \":9999\"\nfunc TestRun() { http.ListenAndServe(\":9999\", nil) }\n",
+			},
+			want:       4000,
+			wantReason: `internal/web/server.go:4: ListenAndServe("0.0.0.0:4000"`,
+		},
+		{
+			name:       "Go PORT fallback",
+			ext:        ".go",
+			files:      map[string]string{"main.go": "package main\n\nfunc main() {\n\tport := os.Getenv(\"PORT\")\n\tif port == \"\" {\n\t\tport = \"5000\"\n\t}\n}\n"},
+			want:       5000,
+			wantReason: `main.go:6: port = "5000"`,
+		},
+		{
+			name:       "Rust tuple bind",
+			ext:        ".rs",
+			files:      map[string]string{"src/main.rs": "fn main() {\n    HttpServer::new(app).bind((\"0.0.0.0\", 7000))?.run()\n}\n"},
+			want:       7000,
+			wantReason: `src/main.rs:2: .bind(("0.0.0.0", 7000)`,
+		},
+		{
+			name: "Vendored code is ignored",
+			ext:  ".go",
+			files: map[string]string{
+				"main.go":            "package main\n",
+				"vendor/lib/lib.go":  "package lib\n\nvar _ = http.ListenAndServe(\":6000\", nil)\n",
+				"invalid/invalid.go": "package invalid\n\nvar _ = http.ListenAndServe(\":99999\", nil)\n",
+			},
+			want:       defaultPort,
+			wantReason: "no listen address found in the source, using the default",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			patterns := goPortPatterns
+			if tt.ext == ".rs" {
+				patterns = rustPortPatterns
+			}
+			got, reason := findListenPort(dir, []string{dir}, tt.ext, patterns)
+			if got != tt.want || reason != tt.wantReason {
+				t.Errorf("findListenPort() = %d (%s), want %d (%s)", got, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestDetectRuntime(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"public/index.html": ""})
+
+	manifest := &Manifest{Run: ManifestRun{Port: 3000}}
+	info := detectRuntime(ctx, dir, manifest,
+		func() (bool, string) { return true, "go.mod requires modernc.org/sqlite" },
+		func() (int, string) { t.Error("port detected although the manifest declares it"); return 0, "" })
+
+	if !info.HasDatabase || info.Port != 3000 || !info.HasStatic || info.StaticDir != filepath.Join(dir, "public") {
+		t.Errorf("detectRuntime() = %+v, want database, port 3000 and static assets", info)
+	}
+
+	var reasons []string
+	for _, detection := range info.Detections {
+		reasons = append(reasons, detection.Setting+": "+detection.Reason)
+	}
+	want := "database: go.mod requires modernc.org/sqlite\nport: run.port declared in skyline.yml\nstatic: directory found in the source"
+	if got := strings.Join(reasons, "\n"); got != want {
+		t.Errorf("detectRuntime() detections =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDetectStaticAssetsSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	writeFiles(t, outside, map[string]string{"secret.key": "secret"})
+
+	// Symlinked asset directories are not served
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"assets/app.css": ""})
+	if err := os.Symlink(outside, filepath.Join(dir, "static")); err != nil {
+		t.Fatal(err)
+	}
+	if hasStatic, staticDir := detectStaticAssets(dir); !hasStatic || staticDir != filepath.Join(dir, "assets") {
+		t.Errorf("detectStaticAssets() = %v, %q, want the assets directory", hasStatic, staticDir)
+	}
+
+	// Neither are links inside them that leave the source tree
+	if err := os.Symlink(filepath.Join(outside, "secret.key"), filepath.Join(dir, "assets", "secret.key")); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(t.TempDir(), "static")
+	if err := copyPublishedDir(filepath.Join(dir, "assets"), dst, dir); err == nil {
+		t.Error("copyPublishedDir() copied assets linking outside the source tree")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "secret.key")); err == nil {
+		t.Error("secret.key was copied into the static assets")
+	}
+}
@@ -0,0 +1,65 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// commandContext creates a command that runs in its own process group. When
+// ctx is done the whole group is killed, so cancelling a deployment also stops
+// the processes git and the compilers spawn.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	sandboxFromContext(ctx).apply(cmd)
+
+	return cmd
+}
+
+// runCommand runs cmd and returns its combined output like CombinedOutput,
+// while streaming stdout and stderr line by line into the deployment log
+// carried by ctx. Known secrets are masked in both.
+func runCommand(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	log := deployLogFromContext(ctx)
+	output := &lockedBuffer{}
+
+	stdout := log.Writer(StreamStdout)
+	stderr := log.Writer(StreamStderr)
+	cmd.Stdout = io.MultiWriter(output, stdout)
+	cmd.Stderr = io.MultiWriter(output, stderr)
+
+	err := cmd.Run()
+	stdout.Close()
+	stderr.Close()
+
+	return []byte(log.redact(string(output.Bytes()))), err
+}
+
+// lockedBuffer is a bytes.Buffer that is safe for concurrent writes
+type lockedBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+// Write appends p to the buffer
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+// Bytes returns the buffered bytes
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Bytes()
+}
@@ -0,0 +1,141 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// Deployment log streams
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamSystem = "system"
+)
+
+type deployLogKey struct{}
+
+// DeployLog records the output of a deployment line by line, persisting each
+// line as soon as it is complete so it can be followed while the deployment
+// runs. Known secrets are masked before lines are persisted. A nil
+// *DeployLog discards everything.
+type DeployLog struct {
+	database     *db.Database
+	logger       errors.Logger
+	redactor     *Redactor
+	deploymentID string
+	seq          int
+	failed       bool
+	mu           sync.Mutex
+}
+
+// NewDeployLog creates a new DeployLog for a deployment
+func NewDeployLog(database *db.Database, logger errors.Logger, redactor *Redactor, deploymentID string) *DeployLog {
+	return &DeployLog{
+		database:     database,
+		logger:       logger,
+		redactor:     redactor,
+		deploymentID: deploymentID,
+	}
+}
+
+// WithDeployLog returns a context that carries a deployment log
+func WithDeployLog(ctx context.Context, log *DeployLog) context.Context {
+	return context.WithValue(ctx, deployLogKey{}, log)
+}
+
+// deployLogFromContext returns the deployment log carried by ctx, or nil
+func deployLogFromContext(ctx context.Context) *DeployLog {
+	log, _ := ctx.Value(deployLogKey{}).(*DeployLog)
+	return log
+}
+
+// Printf records a system line
+func (l *DeployLog) Printf(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+
+	for _, line := range strings.Split(fmt.Sprintf(format, args...), "\n") {
+		l.append(StreamSystem, line)
+	}
+}
+
+// Writer returns a writer that records each line written to it on a stream.
+// The returned writer must be closed to record a trailing partial line.
+func (l *DeployLog) Writer(stream string) io.WriteCloser {
+	return &lineWriter{log: l, stream: stream}
+}
+
+// redact masks the known secrets in s
+func (l *DeployLog) redact(s string) string {
+	if l == nil {
+		return s
+	}
+	return l.redactor.Redact(s)
+}
+
+// append persists a single line
+func (l *DeployLog) append(stream, line string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	err := l.database.AppendDeploymentLog(context.Background(), &db.DeploymentLogLine{
+		DeploymentID: l.deploymentID,
+		Seq:          l.seq,
+		Stream:       stream,
+		Line:         l.redactor.Redact(line),
+	})
+
+	// Only warn once so a broken database does not flood the logs
+	if err != nil && !l.failed {
+		l.failed = true
+		l.logger.Warn(context.Background(), "Failed to persist deployment log line",
+			errors.FieldMap{"deployment_id": l.deploymentID, "error": err.Error()})
+	}
+}
+
+// lineWriter splits written bytes into lines for a DeployLog
+type lineWriter struct {
+	log    *DeployLog
+	stream string
+	buf    []byte
+}
+
+// Write records every complete line in p
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.log.append(w.stream, strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Close records any trailing partial line
+func (w *lineWriter) Close() error {
+	if len(w.buf) > 0 {
+		w.log.append(w.stream, strings.TrimRight(string(w.buf), "\r"))
+		w.buf = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package deploy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestFile is the name of the manifest at the root of an app repository
+const ManifestFile = "skyline.yml"
+
+var (
+	databaseNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	envNamePattern      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Manifest declares how an app is built and run. Anything it leaves out is
+// detected from the source as before.
+type Manifest struct {
+	Build       ManifestBuild      `yaml:"build" json:"build"`
+	Run         ManifestRun        `yaml:"run" json:"run"`
+	HealthCheck string             `yaml:"health_check" json:"health_check,omitempty"` // HTTP path
+	Static      string             `yaml:"static" json:"static,omitempty"`             // Static assets directory, or the site of a static app
+	SPA         bool               `yaml:"spa" json:"spa,omitempty"`                   // Serve index.html for unknown paths of a static app
+	ServeStatic bool               `yaml:"serve_static" json:"serve_static,omitempty"` // Let the proxy serve the static assets directory
+	StaticPath  string             `yaml:"static_path" json:"static_path,omitempty"`   // URL path of the static assets, /static by default
+	Databases   []ManifestDatabase `yaml:"databases" json:"databases,omitempty"`
+	Hooks       ManifestHooks      `yaml:"hooks" json:"hooks"`
+}
+
+// ManifestBuild declares what to build
+type ManifestBuild struct {
+	Type    string `yaml:"type" json:"type,omitempty"`       // go, rust, node, python or a buildpack name
+	Target  string `yaml:"target" json:"target,omitempty"`   // Go, Node or Python project directory, or Cargo binary name
+	Command string `yaml:"command" json:"command,omitempty"` // Shell command that builds a static app
+}
+
+// ManifestRun declares how the built app is started
+type ManifestRun struct {
+	Args []string `yaml:"args" json:"args,omitempty"` // $PORT and other variables are expanded
+	Port int      `yaml:"port" json:"port,omitempty"`
+}
+
+// ManifestDatabase declares a SQLite database of the app
+type ManifestDatabase struct {
+	Name string `yaml:"name" json:"name"`
+	Env  string `yaml:"env" json:"env,omitempty"` // Variable holding the database URL
+}
+
+// ManifestHooks declares shell commands run around a deployment
+type ManifestHooks struct {
+	PreDeploy  []string `yaml:"pre_deploy" json:"pre_deploy,omitempty"`   // Before traffic switches to the release
+	PostDeploy []string `yaml:"post_deploy" json:"post_deploy,omitempty"` // After traffic switched to the release
+}
+
+// ManifestError lists the problems found in a manifest
+type ManifestError struct {
+	Problems []string
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", ManifestFile, strings.Join(e.Problems, "; "))
+}
+
+// LoadManifest reads and validates the manifest of a source tree. It returns
+// nil without an error when the repository has no manifest.
+func LoadManifest(sourceDir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(sourceDir, ManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ManifestFile, err)
+	}
+
+	var manifest Manifest
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&manifest); err != nil && err != io.EOF {
+		return nil, &ManifestError{Problems: []string{err.Error()}}
+	}
+
+	if err := manifest.Validate(sourceDir); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// Validate checks the manifest against the source tree it belongs to
+func (m *Manifest) Validate(sourceDir string) error {
+	var problems []string
+	problemf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if m.Build.Target != "" {
+		if m.Build.Type == "rust" {
+			if strings.ContainsAny(m.Build.Target, `/\ `) {
+				problemf("build.target %q must be a Cargo binary name", m.Build.Target)
+			}
+		} else if err := checkSourceDir(sourceDir, m.Build.Target); err != nil {
+			problemf("build.target: %v", err)
+		}
+	}
+
+	if m.Run.Port < 0 || m.Run.Port > 65535 {
+		problemf("run.port %d is out of range", m.Run.Port)
+	}
+
+	if m.HealthCheck != "" && !strings.HasPrefix(m.HealthCheck, "/") {
+		problemf("health_check %q must be a path starting with /", m.HealthCheck)
+	}
+
+	if m.Build.Command != "" && m.Build.Type != "static" {
+		problemf("build.command is only supported with build.type static")
+	}
+
+	if m.Static != "" {
+		// The build command of a static app may create the directory
+		check := checkSourceDir
+		if m.Build.Command != "" {
+			check = checkRelativePath
+		}
+		if err := check(sourceDir, m.Static); err != nil {
+			problemf("static: %v", err)
+		}
+	}
+
+	if m.SPA && m.Build.Type != "static" {
+		problemf("spa is only supported with build.type static")
+	}
+
+	if m.StaticPath != "" {
+		if !m.ServeStatic {
+			problemf("static_path requires serve_static")
+		}
+		if !strings.HasPrefix(m.StaticPath, "/") || strings.Trim(m.StaticPath, "/") == "" || strings.ContainsAny(m.StaticPath, "*?{} ") {
+			problemf("static_path %q must be a path below / such as /static", m.StaticPath)
+		}
+	}
+
+	names := make(map[string]bool)
+	envs := make(map[string]bool)
+	for i, db := range m.Databases {
+		if !databaseNamePattern.MatchString(db.Name) {
+			problemf("databases[%d].name %q must be lowercase letters, digits, - or _", i, db.Name)
+		} else if names[db.Name] {
+			problemf("databases[%d].name %q is declared twice", i, db.Name)
+		}
+		names[db.Name] = true
+
+		if db.Env != "" {
+			if !envNamePattern.MatchString(db.Env) {
+				problemf("databases[%d].env %q is not a valid variable name", i, db.Env)
+			} else if envs[db.Env] {
+				problemf("databases[%d].env %q is used twice", i, db.Env)
+			}
+			envs[db.Env] = true
+		}
+	}
+
+	for i, hook := range m.Hooks.PreDeploy {
+		if strings.TrimSpace(hook) == "" {
+			problemf("hooks.pre_deploy[%d] is empty", i)
+		}
+	}
+	for i, hook := range m.Hooks.PostDeploy {
+		if strings.TrimSpace(hook) == "" {
+			problemf("hooks.post_deploy[%d] is empty", i)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ManifestError{Problems: problems}
+	}
+
+	return nil
+}
+
+// checkSourceDir checks that a relative path stays inside the source tree
+// and is a directory
+func checkSourceDir(sourceDir, path string) error {
+	if err := checkRelativePath(sourceDir, path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(filepath.Join(sourceDir, filepath.Clean(path)))
+	if err != nil {
+		return fmt.Errorf("%q does not exist", path)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", path)
+	}
+
+	return nil
+}
+
+// checkRelativePath checks that a relative path stays inside the source tree
+func checkRelativePath(sourceDir, path string) error {
+	if filepath.IsAbs(path) {
+		return fmt.Errorf("%q must be relative to the repository root", path)
+	}
+
+	clean := filepath.Clean(path)
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%q points outside the repository", path)
+	}
+
+	return nil
+}
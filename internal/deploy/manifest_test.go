@@ -0,0 +1,164 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	tests := []struct {
+		name         string
+		manifest     string
+		wantManifest *Manifest
+		wantProblems int
+	}{
+		{
+			name: "No manifest",
+		},
+		{
+			name: "Valid manifest",
+			manifest: `
+build:
+  type: go
+  target: cmd/server
+run:
+  args: ["serve", "--addr", ":$PORT"]
+  port: 3000
+health_check: /healthz
+static: public
+databases:
+  - name: app
+  - name: cache
+    env: CACHE_DB
+hooks:
+  pre_deploy: ["bin/app migrate"]
+`,
+			wantManifest: &Manifest{
+				Build:       ManifestBuild{Type: "go", Target: "cmd/server"},
+				Run:         ManifestRun{Args: []string{"serve", "--addr", ":$PORT"}, Port: 3000},
+				HealthCheck: "/healthz",
+				Static:      "public",
+				Databases:   []ManifestDatabase{{Name: "app"}, {Name: "cache", Env: "CACHE_DB"}},
+				Hooks:       ManifestHooks{PreDeploy: []string{"bin/app migrate"}},
+			},
+		},
+		{
+			name: "Static site built into a new directory",
+			manifest: `
+build:
+  type: static
+  command: npm ci && npm run build
+static: dist
+spa: true
+`,
+			wantManifest: &Manifest{
+				Build:  ManifestBuild{Type: "static", Command: "npm ci && npm run build"},
+				Static: "dist",
+				SPA:    true,
+			},
+		},
+		{
+			name: "Static settings without a static app",
+			manifest: `
+build:
+  command: make
+spa: true
+`,
+			wantProblems: 2,
+		},
+		{
+			name: "Static assets served by the proxy",
+			manifest: `
+static: public
+serve_static: true
+static_path: /assets
+`,
+			wantManifest: &Manifest{Static: "public", ServeStatic: true, StaticPath: "/assets"},
+		},
+		{
+			name:         "Static path without serving static assets",
+			manifest:     "static_path: assets/*\n",
+			wantProblems: 2,
+		},
+		{
+			name:         "Empty manifest",
+			manifest:     "",
+			wantManifest: &Manifest{},
+		},
+		{
+			name:         "Unknown field",
+			manifest:     "ports: 8080\n",
+			wantProblems: 1,
+		},
+		{
+			name: "Invalid settings",
+			manifest: `
+build:
+  type: python
+  target: ../outside
+run:
+  port: 70000
+health_check: healthz
+static: missing
+databases:
+  - name: App
+  - name: cache
+    env: 1BAD
+hooks:
+  post_deploy: [" "]
+`,
+			wantProblems: 7,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, sub := range []string{"cmd/server", "public"} {
+				if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+					t.Fatalf("Failed to create directory: %v", err)
+				}
+			}
+			if tt.name != "No manifest" {
+				if err := os.WriteFile(filepath.Join(dir, ManifestFile), []byte(tt.manifest), 0644); err != nil {
+					t.Fatalf("Failed to write manifest: %v", err)
+				}
+			}
+
+			manifest, err := LoadManifest(dir)
+			if tt.wantProblems > 0 {
+				invalid, ok := err.(*ManifestError)
+				if !ok {
+					t.Fatalf("LoadManifest() error = %v, want ManifestError", err)
+				}
+				if len(invalid.Problems) != tt.wantProblems {
+					t.Errorf("LoadManifest() problems = %q, want %d", invalid.Problems, tt.wantProblems)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("LoadManifest() error = %v", err)
+			}
+			if !reflect.DeepEqual(manifest, tt.wantManifest) {
+				t.Errorf("LoadManifest() = %+v, want %+v", manifest, tt.wantManifest)
+			}
+		})
+	}
+}
+
+func TestRunArgs(t *testing.T) {
+	state := deployState{Manifest: &Manifest{Run: ManifestRun{Args: []string{"serve", "--addr=:$PORT", "${MISSING}x"}}}}
+
+	got := runArgs(state, []string{"PORT=9000", "HOME=/tmp"})
+	want := []string{"serve", "--addr=:9000", "x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("runArgs() = %q, want %q", got, want)
+	}
+
+	if got := runArgs(deployState{}, nil); got != nil {
+		t.Errorf("runArgs() without manifest = %q, want nil", got)
+	}
+}
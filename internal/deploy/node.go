@@ -0,0 +1,230 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// Node.js apps are deployed as a whole directory, including node_modules,
+// and started with their start script or main entry instead of a binary.
+const (
+	nodeAppDir      = "app"
+	nodeDefaultPort = 3000
+)
+
+// packageJSON is the part of package.json the Node builder reads
+type packageJSON struct {
+	Main         string            `json:"main"`
+	Scripts      map[string]string `json:"scripts"`
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+// nodePackageManager installs dependencies and runs scripts of a Node app
+type nodePackageManager struct {
+	Name    string
+	Install []string
+}
+
+// nodeBuilder builds Node.js applications
+type nodeBuilder struct {
+	b *Builder
+}
+
+func (n *nodeBuilder) Name() string {
+	return "node"
+}
+
+func (n *nodeBuilder) Detect(ctx context.Context, sourceDir string) bool {
+	return isNodeApp(sourceDir)
+}
+
+func (n *nodeBuilder) Build(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
+	return n.b.buildNodeApp(ctx, sourceDir, outputDir, manifest, settings)
+}
+
+// buildNodeApp builds a Node.js application
+func (b *Builder) buildNodeApp(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
+	fields := errors.FieldMap{
+		"source_dir": sourceDir,
+		"output_dir": outputDir,
+		"type":       "node",
+	}
+	deployLog := deployLogFromContext(ctx)
+
+	// A target names the package directory, e.g. in a monorepo
+	appRoot := sourceDir
+	if manifest.Build.Target != "" {
+		appRoot = filepath.Join(sourceDir, manifest.Build.Target)
+	}
+
+	pkg, err := readPackageJSON(appRoot)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, "failed to read package.json")
+		b.logger.Error(ctx, wrappedErr, "Node project detection failed", fields)
+		return BuildResult{}, wrappedErr
+	}
+
+	// Set up build environment
+	env := b.buildEnv(settings)
+
+	pm := detectPackageManager(appRoot)
+	fields["package_manager"] = pm.Name
+
+	b.logger.Info(ctx, "Building Node application", fields)
+
+	// Install dependencies as pinned by the lockfile
+	deployLog.Printf("$ %s %s", pm.Name, strings.Join(pm.Install, " "))
+	cmd := commandContext(ctx, pm.Name, pm.Install...)
+	cmd.Dir = appRoot
+	cmd.Env = env
+	output, err := runCommand(ctx, cmd)
+	if err != nil {
+		wrappedErr := errors.Wrap(err, fmt.Sprintf("%s install failed: %s", pm.Name, output))
+		b.logger.Error(ctx, wrappedErr, "Node dependency installation failed", fields)
+		return BuildResult{}, wrappedErr
+	}
+
+	// Run the build script if there is one
+	if _, ok := pkg.Scripts["build"]; ok {
+		deployLog.Printf("$ %s run build", pm.Name)
+		cmd := commandContext(ctx, pm.Name, "run", "build")
+		cmd.Dir = appRoot
+		cmd.Env = env
+		output, err := runCommand(ctx, cmd)
+		if err != nil {
+			wrappedErr := errors.Wrap(err, fmt.Sprintf("%s run build failed: %s", pm.Name, output))
+			b.logger.Error(ctx, wrappedErr, "Node build failed", fields)
+			return BuildResult{}, wrappedErr
+		}
+	}
+
+	command, err := nodeStartCommand(appRoot, pkg, pm)
+	if err != nil {
+		b.logger.Error(ctx, err, "Node start command not found", fields)
+		return BuildResult{}, err
+	}
+	fields["command"] = strings.Join(command, " ")
+
+	// Copy the app with its dependencies to the output directory
+	appDir := filepath.Join(outputDir, nodeAppDir)
+	if err := copyAppDir(appRoot, appDir, ".git"); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to copy Node application")
+		b.logger.Error(ctx, wrappedErr, "Application copy failed", fields)
+		return BuildResult{}, wrappedErr
+	}
+
+	// Determine database, port and static assets
+	hasDB := len(manifest.Databases) > 0 || nodeUsesSQLite(pkg)
+	port := manifest.Run.Port
+	if port == 0 {
+		port = nodeDefaultPort
+	}
+	hasStatic, staticDir := detectStaticAssets(appDir)
+	if manifest.Static != "" {
+		destStaticDir := filepath.Join(outputDir, "static")
+		if err := copyPublishedDir(filepath.Join(sourceDir, manifest.Static), destStaticDir, sourceDir); err != nil {
+			b.logger.Warn(ctx, "Failed to copy static assets", errors.WithField(fields, "error", err.Error()))
+		} else {
+			hasStatic, staticDir = true, destStaticDir
+		}
+	}
+	fields["has_database"] = hasDB
+	fields["port"] = port
+	fields["has_static"] = hasStatic
+
+	result := BuildResult{
+		Type:        "node",
+		AppDir:      appDir,
+		Toolchain:   toolchainVersion(ctx, env, "node", "--version"),
+		BuildFlags:  append([]string{pm.Name}, pm.Install...),
+		Command:     command,
+		BinDirs:     []string{"node_modules/.bin"},
+		Environment: map[string]string{"NODE_ENV": "production"},
+		Port:        port,
+		HasDatabase: hasDB,
+		HasStatic:   hasStatic,
+		StaticDir:   staticDir,
+		Manifest:    manifest,
+	}
+
+	b.logger.Info(ctx, "Node application built successfully", fields)
+	return result, nil
+}
+
+// isNodeApp checks if the directory contains a Node.js application
+func isNodeApp(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "package.json"))
+	return err == nil
+}
+
+// readPackageJSON reads the package.json of a Node app
+func readPackageJSON(dir string) (packageJSON, error) {
+	var pkg packageJSON
+
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return pkg, err
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return pkg, err
+	}
+
+	return pkg, nil
+}
+
+// detectPackageManager picks the package manager of a Node app from its
+// lockfile, falling back to npm
+func detectPackageManager(dir string) nodePackageManager {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	switch {
+	case exists("pnpm-lock.yaml"):
+		return nodePackageManager{Name: "pnpm", Install: []string{"install", "--frozen-lockfile"}}
+	case exists("yarn.lock"):
+		return nodePackageManager{Name: "yarn", Install: []string{"install", "--frozen-lockfile"}}
+	case exists("package-lock.json"), exists("npm-shrinkwrap.json"):
+		return nodePackageManager{Name: "npm", Install: []string{"ci"}}
+	default:
+		return nodePackageManager{Name: "npm", Install: []string{"install"}}
+	}
+}
+
+// nodeStartCommand returns the command that starts a Node app: its start
+// script, its main entry or a conventional server file
+func nodeStartCommand(dir string, pkg packageJSON, pm nodePackageManager) ([]string, error) {
+	if _, ok := pkg.Scripts["start"]; ok {
+		return []string{pm.Name, "start"}, nil
+	}
+
+	if pkg.Main != "" {
+		return []string{"node", pkg.Main}, nil
+	}
+
+	for _, name := range []string{"server.js", "index.js", "app.js"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return []string{"node", name}, nil
+		}
+	}
+
+	return nil, errors.New("package.json has no start script or main entry, and there is no server.js, index.js or app.js")
+}
+
+// nodeUsesSQLite checks the dependencies of a Node app for a SQLite driver
+func nodeUsesSQLite(pkg packageJSON) bool {
+	for _, dep := range []string{"sqlite3", "better-sqlite3", "sqlite", "@libsql/client"} {
+		if _, ok := pkg.Dependencies[dep]; ok {
+			return true
+		}
+	}
+	return false
+}
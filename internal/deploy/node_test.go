@@ -0,0 +1,159 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danbruder/skyline/internal/db"
+)
+
+func TestDetectPackageManager(t *testing.T) {
+	tests := []struct {
+		name     string
+		lockfile string
+		want     string
+		install  string
+	}{
+		{"pnpm", "pnpm-lock.yaml", "pnpm", "install --frozen-lockfile"},
+		{"yarn", "yarn.lock", "yarn", "install --frozen-lockfile"},
+		{"npm with lockfile", "package-lock.json", "npm", "ci"},
+		{"npm without lockfile", "", "npm", "install"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.lockfile != "" {
+				if err := os.WriteFile(filepath.Join(dir, tt.lockfile), nil, 0644); err != nil {
+					t.Fatalf("Failed to write lockfile: %v", err)
+				}
+			}
+
+			pm := detectPackageManager(dir)
+			if pm.Name != tt.want || strings.Join(pm.Install, " ") != tt.install {
+				t.Errorf("detectPackageManager() = %s %v, want %s %s", pm.Name, pm.Install, tt.want, tt.install)
+			}
+		})
+	}
+}
+
+func TestNodeStartCommand(t *testing.T) {
+	npm := nodePackageManager{Name: "npm"}
+
+	tests := []struct {
+		name    string
+		pkg     packageJSON
+		file    string
+		want    []string
+		wantErr bool
+	}{
+		{"Start script", packageJSON{Main: "lib/index.js", Scripts: map[string]string{"start": "node lib/index.js"}}, "", []string{"npm", "start"}, false},
+		{"Main entry", packageJSON{Main: "lib/index.js"}, "", []string{"node", "lib/index.js"}, false},
+		{"Server file", packageJSON{}, "server.js", []string{"node", "server.js"}, false},
+		{"Nothing to start", packageJSON{}, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.file != "" {
+				if err := os.WriteFile(filepath.Join(dir, tt.file), nil, 0644); err != nil {
+					t.Fatalf("Failed to write %s: %v", tt.file, err)
+				}
+			}
+
+			got, err := nodeStartCommand(dir, tt.pkg, npm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nodeStartCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nodeStartCommand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildNodeApp(t *testing.T) {
+	ctx := context.Background()
+
+	// A fake npm that records its arguments and installs a linked binary
+	binDir := t.TempDir()
+	npm := `#!/bin/sh
+echo "$@" >> npm.log
+mkdir -p node_modules/tool node_modules/.bin
+[ -L node_modules/.bin/tool ] || ln -s ../tool/cli.js node_modules/.bin/tool
+`
+	if err := os.WriteFile(filepath.Join(binDir, "npm"), []byte(npm), 0755); err != nil {
+		t.Fatalf("Failed to write npm: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	sourceDir := t.TempDir()
+	files := map[string]string{
+		"package.json":      `{"scripts": {"build": "tsc", "start": "node dist/server.js"}, "dependencies": {"better-sqlite3": "^9.0.0"}}`,
+		"package-lock.json": `{}`,
+		".git/HEAD":         "ref: refs/heads/main",
+	}
+	for name, content := range files {
+		path := filepath.Join(sourceDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	b := NewBuilder(BuildConfig{OutputDir: t.TempDir(), Sandbox: testSandbox}, newMockLogger(t))
+	result, err := b.DetectAndBuild(ctx, sourceDir, "app/1", nil, db.BuildSettings{})
+	if err != nil {
+		t.Fatalf("DetectAndBuild() error = %v", err)
+	}
+
+	if result.Type != "node" || result.BinaryPath != "" {
+		t.Errorf("DetectAndBuild() = %+v, want a node app without a binary", result)
+	}
+	if !reflect.DeepEqual(result.Command, []string{"npm", "start"}) {
+		t.Errorf("Command = %v, want [npm start]", result.Command)
+	}
+	if result.Port != nodeDefaultPort || !result.HasDatabase || result.Environment["NODE_ENV"] != "production" {
+		t.Errorf("DetectAndBuild() = %+v, want default port, database and production environment", result)
+	}
+
+	// npm ran in a copy of the source, which was deployed
+	log, err := os.ReadFile(filepath.Join(result.AppDir, "npm.log"))
+	if err != nil {
+		t.Fatalf("npm did not run: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sourceDir, "npm.log")); !os.IsNotExist(err) {
+		t.Error("npm ran in the source directory")
+	}
+	if string(log) != "ci\nrun build\n" {
+		t.Errorf("npm ran %q, want ci and run build", log)
+	}
+
+	// The app directory is deployed as it is, links included
+	if _, err := os.Stat(filepath.Join(result.AppDir, ".git")); !os.IsNotExist(err) {
+		t.Error("AppDir contains .git")
+	}
+	target, err := os.Readlink(filepath.Join(result.AppDir, "node_modules", ".bin", "tool"))
+	if err != nil || target != "../tool/cli.js" {
+		t.Errorf("node_modules/.bin/tool -> %q (%v), want ../tool/cli.js", target, err)
+	}
+
+	// Directory artifacts can be verified for the build cache
+	sum, err := artifactChecksum(result.artifactPath())
+	if err != nil {
+		t.Fatalf("artifactChecksum() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(result.AppDir, "package.json"), []byte(`{}`), 0644); err != nil {
+		t.Fatalf("Failed to modify artifact: %v", err)
+	}
+	if modified, _ := artifactChecksum(result.artifactPath()); modified == sum {
+		t.Error("artifactChecksum() did not change with the artifact")
+	}
+}
@@ -2,7 +2,12 @@ package deploy
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/danbruder/skyline/internal/db"
@@ -11,11 +16,16 @@ import (
 	"github.com/google/uuid"
 )
 
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
 // PipelineConfig contains configuration for the deployment pipeline
 type PipelineConfig struct {
-	SourceDir string
-	BuildDir  string
-	Timeout   time.Duration
+	SourceDir  string
+	BuildDir   string
+	Timeout    time.Duration
+	MaxBuilds  int      // Builds running at once; more wait for a worker
+	KeepBuilds int      // Build artifacts kept per app for later deployments
+	Secrets    []string // Masked in deployment logs and records
 }
 
 // Pipeline orchestrates the deployment process
@@ -27,6 +37,10 @@ type Pipeline struct {
 	fetcher  SourceFetcher
 	builder  AppBuilder
 	deployer AppDeployer
+	keys     *DeployKeys
+	redactor *Redactor
+	queue    *DeployQueue
+	builds   *BuildPool
 }
 
 // NewPipeline creates a new deployment pipeline
@@ -38,6 +52,7 @@ func NewPipeline(
 	fetcher SourceFetcher,
 	builder AppBuilder,
 	deployer AppDeployer,
+	keys *DeployKeys,
 ) *Pipeline {
 	// Set defaults
 	if config.SourceDir == "" {
@@ -49,8 +64,14 @@ func NewPipeline(
 	if config.Timeout == 0 {
 		config.Timeout = 15 * time.Minute
 	}
+	if config.MaxBuilds == 0 {
+		config.MaxBuilds = 2
+	}
+	if config.KeepBuilds == 0 {
+		config.KeepBuilds = 5
+	}
 
-	return &Pipeline{
+	p := &Pipeline{
 		config:   config,
 		logger:   logger,
 		database: database,
@@ -58,27 +79,185 @@ func NewPipeline(
 		fetcher:  fetcher,
 		builder:  builder,
 		deployer: deployer,
+		keys:     keys,
+		redactor: NewRedactor(config.Secrets...),
+		builds:   NewBuildPool(config.MaxBuilds),
+	}
+	p.queue = NewDeployQueue(logger, database, p.RunDeployment)
+
+	return p
+}
+
+// Enqueue schedules a deployment on the app's deployment queue
+func (p *Pipeline) Enqueue(deployment *db.Deployment) {
+	p.queue.Enqueue(deployment)
+}
+
+// CancelDeployment cancels a pending or running deployment
+func (p *Pipeline) CancelDeployment(deploymentID string) error {
+	return p.queue.Cancel(deploymentID)
+}
+
+// RecoverDeployments fails the deployments left pending or in progress by a
+// previous run, which were lost with its queue
+func (p *Pipeline) RecoverDeployments(ctx context.Context) error {
+	failed, err := p.database.FailUnfinishedDeployments(ctx, "Deployment interrupted by a restart of Skyline")
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		p.logger.Warn(ctx, "Failed deployments interrupted by a restart", errors.FieldMap{"count": failed})
 	}
+	return nil
+}
+
+// GenerateDeployKey creates a new SSH deploy key for an app, replacing its
+// current key
+func (p *Pipeline) GenerateDeployKey(ctx context.Context, appID string) (*db.DeployKey, error) {
+	return p.keys.Generate(ctx, appID)
 }
 
-// DeployApp handles the full deployment process
-func (p *Pipeline) DeployApp(ctx context.Context, appID, commit string) error {
+// BuildQueue returns the builds that are running and waiting for a worker
+func (p *Pipeline) BuildQueue() BuildQueueStatus {
+	return p.builds.Status()
+}
+
+// CreateDeployment creates a pending deployment record for an app
+func (p *Pipeline) CreateDeployment(ctx context.Context, appID, commit string) (*db.Deployment, error) {
 	fields := errors.FieldMap{
 		"app_id": appID,
 		"commit": commit,
 	}
 
+	deployment := &db.Deployment{
+		ID:        uuid.New().String(),
+		AppID:     appID,
+		CommitSHA: commit,
+		Status:    "pending",
+		StartedAt: time.Now(),
+	}
+
+	if err := p.database.CreateDeployment(ctx, deployment); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to create deployment record")
+		p.logger.Error(ctx, wrappedErr, "Deployment record creation failed", fields)
+		return nil, wrappedErr
+	}
+
+	return deployment, nil
+}
+
+// CreateRollback creates a pending rollback of an app to the release of an
+// earlier successful deployment. Without a target deployment the most recent
+// successful release other than the current one is used.
+func (p *Pipeline) CreateRollback(ctx context.Context, appID, targetID string) (*db.Deployment, error) {
+	fields := errors.FieldMap{
+		"app_id":    appID,
+		"target_id": targetID,
+	}
+
+	var target *db.Deployment
+	if targetID != "" {
+		deployment, err := p.database.GetDeployment(ctx, targetID)
+		if err != nil {
+			return nil, err
+		}
+		if deployment.AppID != appID {
+			return nil, errors.Wrap(errors.ErrRecordNotFound, "deployment does not belong to app")
+		}
+		if deployment.Status != "success" {
+			return nil, errors.Wrap(errors.ErrInvalidData, "only successful deployments can be rolled back to")
+		}
+		if !p.deployer.HasRelease(appID, deployment.ReleaseID) {
+			return nil, errors.Wrap(errors.ErrResourceUnavailable, "release of deployment is no longer available")
+		}
+		target = deployment
+	} else {
+		deployments, err := p.database.ListDeployments(ctx, appID)
+		if err != nil {
+			return nil, err
+		}
+
+		current, _ := p.deployer.CurrentRelease(appID)
+		for _, deployment := range deployments {
+			if deployment.Status == "success" && deployment.ReleaseID != current &&
+				p.deployer.HasRelease(appID, deployment.ReleaseID) {
+				target = deployment
+				break
+			}
+		}
+		if target == nil {
+			return nil, errors.Wrap(errors.ErrResourceUnavailable, "no earlier release to roll back to")
+		}
+	}
+
+	deployment := &db.Deployment{
+		ID:        uuid.New().String(),
+		AppID:     appID,
+		Type:      db.DeploymentTypeRollback,
+		ReleaseID: target.ReleaseID,
+		CommitSHA: target.CommitSHA,
+		Status:    "pending",
+		StartedAt: time.Now(),
+	}
+
+	if err := p.database.CreateDeployment(ctx, deployment); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to create deployment record")
+		p.logger.Error(ctx, wrappedErr, "Deployment record creation failed", fields)
+		return nil, wrappedErr
+	}
+
+	return deployment, nil
+}
+
+// RunDeployment runs the fetch, build and deploy stages for an existing
+// deployment record, updating it as the pipeline progresses. Rollbacks skip
+// fetch and build and reactivate their release.
+func (p *Pipeline) RunDeployment(ctx context.Context, deployment *db.Deployment) error {
+	appID := deployment.AppID
+	commit := deployment.CommitSHA
+	deployID := deployment.ID
+
+	fields := errors.FieldMap{
+		"app_id":        appID,
+		"commit":        commit,
+		"deployment_id": deployID,
+		"type":          deployment.Type,
+		"release_id":    deployment.ReleaseID,
+	}
+
+	// Stream the output of every stage into the deployment log
+	deployLog := NewDeployLog(p.database, p.logger, p.redactor, deployID)
+	ctx = WithDeployLog(ctx, deployLog)
+
 	// Create timeout context
 	timeoutCtx, cancel := context.WithTimeout(ctx, p.config.Timeout)
 	defer cancel()
 
 	p.logger.Info(timeoutCtx, "Starting deployment pipeline", fields)
 
+	// Update deployment status. Records are written even after the
+	// deployment was cancelled or timed out.
+	recordCtx := context.WithoutCancel(ctx)
+	updateDeployment := func(status, logs string) {
+		deployment.Status = status
+		deployment.Logs = p.redactor.Redact(logs)
+		if status != "in_progress" {
+			deployment.EndedAt = time.Now()
+		}
+
+		if err := p.database.UpdateDeployment(recordCtx, deployment); err != nil {
+			p.logger.Warn(recordCtx, "Failed to update deployment record",
+				errors.WithField(fields, "error", err.Error()))
+		}
+	}
+
 	// Get app from database
 	app, err := p.database.GetApp(timeoutCtx, appID)
 	if err != nil {
 		wrappedErr := errors.Wrap(err, "failed to get app details")
 		p.logger.Error(timeoutCtx, wrappedErr, "App retrieval failed", fields)
+		updateDeployment("failed", fmt.Sprintf("App retrieval failed: %v", err))
 		return wrappedErr
 	}
 
@@ -86,23 +265,7 @@ func (p *Pipeline) DeployApp(ctx context.Context, appID, commit string) error {
 	fields["repo_url"] = app.RepoURL
 	fields["branch"] = app.Branch
 
-	// Create deployment record
-	deployID := uuid.New().String()
-	deployment := &db.Deployment{
-		ID:        deployID,
-		AppID:     appID,
-		CommitSHA: commit,
-		Status:    "pending",
-		StartedAt: time.Now(),
-	}
-
-	if err := p.database.CreateDeployment(timeoutCtx, deployment); err != nil {
-		wrappedErr := errors.Wrap(err, "failed to create deployment record")
-		p.logger.Error(timeoutCtx, wrappedErr, "Deployment record creation failed", fields)
-		return wrappedErr
-	}
-
-	fields["deployment_id"] = deployID
+	updateDeployment("in_progress", "")
 
 	// Publish deployment started event
 	p.eventBus.Publish(events.Event{
@@ -115,32 +278,27 @@ func (p *Pipeline) DeployApp(ctx context.Context, appID, commit string) error {
 		},
 	})
 
-	// Update deployment status
-	updateDeployment := func(status, logs string) {
-		deployment.Status = status
-		deployment.Logs = logs
-		deployment.EndedAt = time.Now()
-
-		if err := p.database.UpdateDeployment(timeoutCtx, deployment); err != nil {
-			p.logger.Warn(timeoutCtx, "Failed to update deployment record",
-				errors.WithField(fields, "error", err.Error()))
+	// Mark the deployment as failed and publish an event
+	fail := func(stage string, err error) error {
+		if ctx.Err() == context.Canceled {
+			deployLog.Printf("Deployment cancelled during %s", stage)
+			p.logger.Info(recordCtx, "Deployment cancelled", errors.WithField(fields, "stage", stage))
+			updateDeployment("cancelled", fmt.Sprintf("Deployment cancelled during %s", stage))
+			return errors.Wrap(ctx.Err(), "deployment cancelled")
 		}
-	}
 
-	// Step 1: Fetch source code
-	p.logger.Info(timeoutCtx, "Fetching source code", fields)
+		deployLog.Printf("%s failed: %v", strings.ToUpper(stage[:1])+stage[1:], err)
 
-	sourceDir, err := p.fetcher.FetchSource(timeoutCtx, app.RepoURL, app.Branch, commit)
-	if err != nil {
-		wrappedErr := errors.Wrap(err, "source fetching failed")
-		p.logger.Error(timeoutCtx, wrappedErr, "Source fetching failed", fields)
+		wrappedErr := errors.Wrap(err, fmt.Sprintf("%s failed", stage))
+		p.logger.Error(recordCtx, wrappedErr, "Deployment pipeline failed",
+			errors.WithField(fields, "stage", stage))
 
-		updateDeployment("failed", fmt.Sprintf("Source fetching failed: %v", err))
+		updateDeployment("failed", fmt.Sprintf("%s failed: %v", strings.ToUpper(stage[:1])+stage[1:], err))
 
 		p.eventBus.Publish(events.Event{
 			Type:    events.AppFailed,
 			AppID:   appID,
-			Message: fmt.Sprintf("Deployment of app %s failed: source fetching error", app.Name),
+			Message: fmt.Sprintf("Deployment of app %s failed: %s error", app.Name, stage),
 			Data: map[string]interface{}{
 				"deployment_id": deployID,
 				"error":         err.Error(),
@@ -150,55 +308,156 @@ func (p *Pipeline) DeployApp(ctx context.Context, appID, commit string) error {
 		return wrappedErr
 	}
 
-	// Step 2: Build application
-	p.logger.Info(timeoutCtx, "Building application", fields)
+	if deployment.Type == db.DeploymentTypeRollback {
+		deployLog.Printf("==> Rolling back to release %s (commit %s)", deployment.ReleaseID, commit)
+		p.logger.Info(timeoutCtx, "Rolling back application", fields)
 
-	buildResult, err := p.builder.DetectAndBuild(timeoutCtx, sourceDir, appID)
-	if err != nil {
-		wrappedErr := errors.Wrap(err, "build failed")
-		p.logger.Error(timeoutCtx, wrappedErr, "Build failed", fields)
+		if err := p.deployer.Rollback(timeoutCtx, appID, deployment.ReleaseID); err != nil {
+			return fail("rollback", err)
+		}
 
-		updateDeployment("failed", fmt.Sprintf("Build failed: %v", err))
+		deployLog.Printf("Rollback completed successfully")
+		updateDeployment("success", "Rollback completed successfully")
 
 		p.eventBus.Publish(events.Event{
-			Type:    events.AppFailed,
+			Type:    events.AppDeployed,
 			AppID:   appID,
-			Message: fmt.Sprintf("Deployment of app %s failed: build error", app.Name),
+			Message: fmt.Sprintf("Successfully rolled back app %s", app.Name),
 			Data: map[string]interface{}{
 				"deployment_id": deployID,
-				"error":         err.Error(),
+				"release_id":    deployment.ReleaseID,
+				"commit":        commit,
 			},
 		})
 
-		return wrappedErr
+		p.logger.Info(timeoutCtx, "Rollback completed successfully", fields)
+		return nil
 	}
 
-	fields["app_type"] = buildResult.Type
+	// Build settings of the app, if it has any
+	var settings db.BuildSettings
+	if stored, err := p.database.GetBuildSettings(timeoutCtx, appID); err == nil {
+		settings = *stored
+	} else if !errors.Is(err, errors.ErrRecordNotFound) {
+		return fail("loading build settings", err)
+	}
 
-	// Step 3: Deploy application
-	p.logger.Info(timeoutCtx, "Deploying application", fields)
+	// Reuse an existing artifact for this commit and build settings
+	settingsHash := p.builder.SettingsHash(settings)
+	buildResult, buildID, cached := p.findBuild(timeoutCtx, app.RepoURL, commit, settingsHash)
 
-	if err := p.deployer.Deploy(timeoutCtx, buildResult, appID); err != nil {
-		wrappedErr := errors.Wrap(err, "deployment failed")
-		p.logger.Error(timeoutCtx, wrappedErr, "Deployment failed", fields)
+	var sourceDir string
+	if cached {
+		p.touchBuild(appID, buildID)
+		deployLog.Printf("==> Reusing build of %s, skipping fetch and build", commit)
+		p.logger.Info(timeoutCtx, "Reusing existing build, skipping fetch and build",
+			errors.WithField(fields, "artifact_path", buildResult.artifactPath()))
+	} else {
+		// Step 1: Fetch source code
+		deployLog.Printf("==> Fetching %s (branch %s)", app.RepoURL, app.Branch)
+		p.logger.Info(timeoutCtx, "Fetching source code", fields)
+
+		// Repositories reached over SSH are fetched with the deploy key of
+		// the app
+		fetchCtx := timeoutCtx
+		if isSSHURL(app.RepoURL) {
+			sshCommand, cleanup, err := p.keys.sshCommand(timeoutCtx, appID)
+			if err != nil {
+				return fail("loading deploy key", err)
+			}
+			defer cleanup()
+			fetchCtx = withGitSSHCommand(timeoutCtx, sshCommand)
+		}
 
-		updateDeployment("failed", fmt.Sprintf("Deployment failed: %v", err))
+		sourceDir, err = p.fetcher.FetchSource(fetchCtx, app.RepoURL, app.Branch, commit)
+		if err != nil {
+			return fail("source fetching", err)
+		}
 
-		p.eventBus.Publish(events.Event{
-			Type:    events.AppFailed,
-			AppID:   appID,
-			Message: fmt.Sprintf("Deployment of app %s failed: deployment error", app.Name),
-			Data: map[string]interface{}{
-				"deployment_id": deployID,
-				"error":         err.Error(),
-			},
-		})
+		// Each deployment gets a worktree of its own, which the artifact
+		// no longer needs once it is built
+		defer p.fetcher.CleanupSource(recordCtx, sourceDir)
 
-		return wrappedErr
+		// Deployments of a branch record the commit that was fetched
+		resolved, err := sourceCommit(timeoutCtx, sourceDir)
+		if err != nil {
+			return fail("resolving commit", err)
+		}
+		if resolved != commit {
+			deployLog.Printf("Deploying commit %s", resolved)
+			commit = resolved
+			fields["commit"] = commit
+			deployment.CommitSHA = commit
+			updateDeployment("in_progress", "")
+
+			// Only now is the commit of the branch known to look up its build
+			buildResult, buildID, cached = p.findBuild(timeoutCtx, app.RepoURL, commit, settingsHash)
+			if cached {
+				p.touchBuild(appID, buildID)
+				deployLog.Printf("==> Reusing build of %s, skipping build", commit)
+				p.logger.Info(timeoutCtx, "Reusing existing build, skipping build",
+					errors.WithField(fields, "artifact_path", buildResult.artifactPath()))
+			}
+		}
+	}
+
+	if !cached {
+		// Declared settings take precedence over detection
+		manifest, err := LoadManifest(sourceDir)
+		if err != nil {
+			var invalid *ManifestError
+			if errors.As(err, &invalid) {
+				for _, problem := range invalid.Problems {
+					deployLog.Printf("%s: %s", ManifestFile, problem)
+				}
+			}
+			return fail("manifest validation", err)
+		}
+		if manifest != nil {
+			deployLog.Printf("Using settings from %s", ManifestFile)
+		}
+
+		// Step 2: Build application into a directory of its own, so
+		// recorded artifacts are never overwritten by later builds
+		deployLog.Printf("==> Building application")
+		p.logger.Info(timeoutCtx, "Building application", fields)
+
+		// Stamp the build with the commit that was actually fetched
+		version := buildVersion{Commit: commit, DeployID: deployID}
+
+		// Wait for a build worker, so concurrent builds cannot exhaust the host
+		release, err := p.builds.Acquire(timeoutCtx, BuildJob{DeploymentID: deployID, AppID: appID, Commit: commit})
+		if err != nil {
+			return fail("waiting for a build worker", err)
+		}
+
+		buildID = uuid.New().String()
+		buildResult, err = p.builder.DetectAndBuild(withBuildVersion(timeoutCtx, version), sourceDir,
+			filepath.Join(appID, buildID), manifest, settings)
+		release()
+		if err != nil {
+			os.RemoveAll(filepath.Join(p.buildsDir(appID), buildID))
+			return fail("build", err)
+		}
+
+		p.recordBuild(timeoutCtx, buildID, app.RepoURL, commit, settingsHash, buildResult)
+	}
+
+	fields["app_type"] = buildResult.Type
+	p.recordProvenance(recordCtx, deployID, buildID, buildResult, cached)
+
+	// Step 3: Deploy application
+	deployLog.Printf("==> Deploying %s application", buildResult.Type)
+	p.logger.Info(timeoutCtx, "Deploying application", fields)
+
+	if err := p.deployer.Deploy(timeoutCtx, buildResult, appID, deployment.ReleaseID); err != nil {
+		return fail("deployment", err)
 	}
 
 	// Update deployment record as successful
+	deployLog.Printf("Deployment completed successfully")
 	updateDeployment("success", "Deployment completed successfully")
+	p.pruneBuilds(recordCtx, appID, buildID)
 
 	// Publish deployment completed event
 	p.eventBus.Publish(events.Event{
@@ -215,6 +474,88 @@ func (p *Pipeline) DeployApp(ctx context.Context, appID, commit string) error {
 	return nil
 }
 
+// findBuild looks up a recorded artifact for a commit and verifies it is
+// still intact on disk
+func (p *Pipeline) findBuild(ctx context.Context, repoURL, commit, settingsHash string) (BuildResult, string, bool) {
+	var result BuildResult
+
+	// Branch names and HEAD move, so only exact commits can be reused
+	if !isCommitSHA(commit) {
+		return result, "", false
+	}
+
+	fields := errors.FieldMap{
+		"repo_url": repoURL,
+		"commit":   commit,
+	}
+
+	build, err := p.database.FindBuild(ctx, repoURL, commit, settingsHash)
+	if err != nil {
+		return result, "", false
+	}
+	fields["build_id"] = build.ID
+
+	checksum, err := artifactChecksum(build.ArtifactPath)
+	if err != nil || checksum != build.Checksum {
+		p.logger.Warn(ctx, "Recorded build artifact is missing or modified, rebuilding", fields)
+		return result, "", false
+	}
+
+	if err := json.Unmarshal([]byte(build.Result), &result); err != nil {
+		p.logger.Warn(ctx, "Recorded build result is invalid, rebuilding",
+			errors.WithField(fields, "error", err.Error()))
+		return result, "", false
+	}
+
+	// Builds recorded before commits were resolved are from the requested one
+	if result.Commit == "" {
+		result.Commit = commit
+	}
+
+	return result, build.ID, true
+}
+
+// recordBuild stores a build artifact so later deployments of the same
+// commit can skip fetch and build
+func (p *Pipeline) recordBuild(ctx context.Context, buildID, repoURL, commit, settingsHash string, result BuildResult) {
+	if !isCommitSHA(commit) {
+		return
+	}
+
+	fields := errors.FieldMap{
+		"build_id": buildID,
+		"repo_url": repoURL,
+		"commit":   commit,
+	}
+
+	checksum, err := artifactChecksum(result.artifactPath())
+	if err != nil {
+		p.logger.Warn(ctx, "Failed to checksum build artifact", errors.WithField(fields, "error", err.Error()))
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		p.logger.Warn(ctx, "Failed to serialize build result", errors.WithField(fields, "error", err.Error()))
+		return
+	}
+
+	build := &db.Build{
+		ID:           buildID,
+		RepoURL:      repoURL,
+		CommitSHA:    commit,
+		SettingsHash: settingsHash,
+		Type:         result.Type,
+		ArtifactPath: result.artifactPath(),
+		Checksum:     checksum,
+		Result:       string(data),
+	}
+
+	if err := p.database.CreateBuild(ctx, build); err != nil {
+		p.logger.Warn(ctx, "Failed to record build", errors.WithField(fields, "error", err.Error()))
+	}
+}
+
 // UndeployApp handles the full undeployment process
 func (p *Pipeline) UndeployApp(ctx context.Context, appID string) error {
 	fields := errors.FieldMap{
@@ -281,58 +622,75 @@ func (p *Pipeline) UndeployApp(ctx context.Context, appID string) error {
 	return nil
 }
 
-// ProcessWebhook processes a GitHub webhook event
-func (p *Pipeline) ProcessWebhook(ctx context.Context, event WebhookEvent) error {
+// ProcessWebhook processes a webhook event of a git provider and returns the
+// deployments it triggered
+func (p *Pipeline) ProcessWebhook(ctx context.Context, event WebhookEvent) ([]*db.Deployment, error) {
 	fields := errors.FieldMap{
+		"provider":   event.Provider,
 		"event_type": event.Type,
 		"repo_url":   event.RepoURL,
 		"branch":     event.Branch,
 		"commit":     event.CommitSHA,
 	}
 
-	p.logger.Info(ctx, "Processing GitHub webhook event", fields)
+	p.logger.Info(ctx, "Processing webhook event", fields)
 
 	// Find apps using this repository and branch
 	apps, err := p.database.ListApps(ctx)
 	if err != nil {
 		wrappedErr := errors.Wrap(err, "failed to list apps")
 		p.logger.Error(ctx, wrappedErr, "App listing failed", fields)
-		return wrappedErr
+		return nil, wrappedErr
 	}
 
-	matchingApps := 0
+	deployments := make([]*db.Deployment, 0)
 	for _, app := range apps {
 		// Check if repo and branch match
-		if app.RepoURL == event.RepoURL && app.Branch == event.Branch {
+		if sameRepo(app.RepoURL, event.RepoURL) && app.Branch == event.Branch {
 			appFields := errors.WithField(fields, "app_id", app.ID)
 			appFields = errors.WithField(appFields, "app_name", app.Name)
 
 			p.logger.Info(ctx, "Found matching app for webhook event", appFields)
 
-			// Trigger deployment in a goroutine
-			go func(appID, commit string) {
-				deployCtx := context.Background()
-				if err := p.DeployApp(deployCtx, appID, commit); err != nil {
-					p.logger.Error(deployCtx, err, "Webhook-triggered deployment failed",
-						errors.FieldMap{
-							"app_id":       appID,
-							"commit":       commit,
-							"webhook_type": event.Type,
-						})
-				}
-			}(app.ID, event.CommitSHA)
+			deployment, err := p.CreateDeployment(ctx, app.ID, event.CommitSHA)
+			if err != nil {
+				continue
+			}
+
+			// Queue the deployment behind any running deployment of the app
+			p.Enqueue(deployment)
 
-			matchingApps++
+			deployments = append(deployments, deployment)
 		}
 	}
 
-	fields["matching_apps"] = matchingApps
+	fields["matching_apps"] = len(deployments)
 	p.logger.Info(ctx, "Webhook processing completed", fields)
-	return nil
+	return deployments, nil
+}
+
+// sameRepo reports whether two repository URLs name the same repository.
+// Providers report web URLs, which apps may have been created with a .git
+// suffix or a trailing slash.
+func sameRepo(a, b string) bool {
+	return normalizeRepoURL(a) == normalizeRepoURL(b)
+}
+
+// normalizeRepoURL returns a repository URL without .git suffix or trailing
+// slash, in lower case
+func normalizeRepoURL(repoURL string) string {
+	repoURL = strings.TrimSuffix(strings.TrimSuffix(repoURL, "/"), ".git")
+	return strings.ToLower(repoURL)
+}
+
+// isCommitSHA reports whether commit is a full hex commit hash
+func isCommitSHA(commit string) bool {
+	return commitSHAPattern.MatchString(commit)
 }
 
-// WebhookEvent contains information about a GitHub webhook event
+// WebhookEvent contains information about a webhook event of a git provider
 type WebhookEvent struct {
+	Provider  string // github, gitlab, gitea or bitbucket
 	Type      string // push, pull_request, etc.
 	RepoURL   string // Repository URL
 	Branch    string // Branch name
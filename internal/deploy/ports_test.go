@@ -0,0 +1,183 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/internal/supervisor"
+)
+
+// stagedSupervisor reports a fixed status for staged processes
+type stagedSupervisor struct {
+	SupervisorClient
+	status string
+}
+
+func (s *stagedSupervisor) GetStagedStatus(appID string) (string, error) {
+	return s.status, nil
+}
+
+func TestWaitForReady(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	listening := server.Listener.Addr().(*net.TCPAddr).Port
+
+	closed, err := freePort()
+	if err != nil {
+		t.Fatalf("freePort() error = %v", err)
+	}
+
+	if portAvailable(listening) {
+		t.Errorf("portAvailable(%d) = true for a port in use", listening)
+	}
+
+	tests := []struct {
+		name    string
+		status  string
+		port    int
+		path    string
+		wantErr bool
+	}{
+		{name: "Listening", status: "running", port: listening},
+		{name: "Not listening", status: "running", port: closed, wantErr: true},
+		{name: "Crashed", status: "crashed", port: listening, wantErr: true},
+		{name: "Healthy path", status: "running", port: listening, path: "/healthz"},
+		{name: "Unhealthy path", status: "running", port: listening, path: "/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDeployer(DeployConfig{HealthTimeout: 500 * time.Millisecond}, newMockLogger(t), nil,
+				&stagedSupervisor{status: tt.status}, nil, nil)
+
+			err := d.waitForReady(ctx, "app", tt.port, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("waitForReady() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// listeningSupervisor listens on the port of the processes it stages, and
+// records the port of the processes it starts
+type listeningSupervisor struct {
+	SupervisorClient
+	t    *testing.T
+	port int
+}
+
+func (s *listeningSupervisor) StartApp(appID string, command supervisor.Command) error {
+	for _, env := range command.Env {
+		if port, ok := strings.CutPrefix(env, "PORT="); ok {
+			s.port, _ = strconv.Atoi(port)
+		}
+	}
+	return nil
+}
+
+func (s *listeningSupervisor) StageApp(appID string, command supervisor.Command) error {
+	s.StartApp(appID, command)
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", s.port))
+	if err != nil {
+		return err
+	}
+	s.t.Cleanup(func() { listener.Close() })
+	return nil
+}
+
+func (s *listeningSupervisor) GetStagedStatus(appID string) (string, error) { return "running", nil }
+func (s *listeningSupervisor) PromoteApp(appID string, drain time.Duration) error {
+	return nil
+}
+func (s *listeningSupervisor) SetHealthCheck(appID string, check supervisor.HealthCheck) {}
+func (s *listeningSupervisor) RemoveHealthCheck(appID string)                            {}
+
+// routingProxy records the port an app is routed to
+type routingProxy struct {
+	ProxyClient
+	port int
+}
+
+func (p *routingProxy) AddRoute(appID, domain string, port int) error {
+	p.port = port
+	return nil
+}
+
+func TestActivateKeepsConfiguredPort(t *testing.T) {
+	ctx := context.Background()
+	logger := newMockLogger(t)
+
+	database, err := db.New(ctx, filepath.Join(t.TempDir(), "test.db"), logger)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	// The process of the previous release still listens on the configured port
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer busy.Close()
+	configured := busy.Addr().(*net.TCPAddr).Port
+
+	app := &db.App{Name: "port-test", RepoURL: "https://github.com/example/repo", Branch: "main",
+		Domain: "example.com", Port: configured}
+	if err := database.CreateApp(ctx, app); err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	processes := &listeningSupervisor{t: t}
+	proxy := &routingProxy{}
+	d := NewDeployer(DeployConfig{AppsDir: t.TempDir(), HealthTimeout: time.Second}, logger, database,
+		processes, proxy, nil)
+
+	releaseID := "20240101-000000"
+	if err := os.MkdirAll(d.releaseDir(app.ID, releaseID), 0755); err != nil {
+		t.Fatalf("Failed to create release: %v", err)
+	}
+	if err := d.activate(ctx, app, releaseID, deployState{Type: "go", Port: configured}); err != nil {
+		t.Fatalf("activate() error = %v", err)
+	}
+	if proxy.port == configured || proxy.port != processes.port {
+		t.Fatalf("routed to port %d, want the spare port %d the release listens on", proxy.port, processes.port)
+	}
+
+	// The app keeps its port, and the release records both
+	stored, err := database.GetApp(ctx, app.ID)
+	if err != nil {
+		t.Fatalf("GetApp() error = %v", err)
+	}
+	if stored.Port != configured {
+		t.Errorf("app port = %d after deploying, want the configured %d", stored.Port, configured)
+	}
+	state, err := d.loadState(d.releaseDir(app.ID, releaseID))
+	if err != nil || state.Port != configured || state.LivePort != proxy.port {
+		t.Errorf("release state = %+v, %v, want port %d live on %d", state, err, configured, proxy.port)
+	}
+
+	// Restarts listen on the port the proxy routes to
+	processes.port = 0
+	if err := d.Start(ctx, app.ID); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if processes.port != proxy.port {
+		t.Errorf("restarted on port %d, want %d", processes.port, proxy.port)
+	}
+}
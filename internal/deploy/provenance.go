@@ -0,0 +1,139 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// Version information is injected into Go binaries as
+//
+//	-X main.commitSHA=<sha> -X main.deployID=<id>
+//
+// so apps that declare these variables can report the code they run. Reused
+// builds keep the deploy ID of the deployment that built them. All apps get
+// SKYLINE_COMMIT_SHA and SKYLINE_DEPLOY_ID in their environment.
+
+type buildVersionKey struct{}
+
+// buildVersion identifies the code and deployment a build is made for
+type buildVersion struct {
+	Commit   string
+	DeployID string
+}
+
+// withBuildVersion returns a context whose builds are stamped with a version
+func withBuildVersion(ctx context.Context, version buildVersion) context.Context {
+	return context.WithValue(ctx, buildVersionKey{}, version)
+}
+
+// buildVersionFromContext returns the version carried by ctx, if any
+func buildVersionFromContext(ctx context.Context) buildVersion {
+	version, _ := ctx.Value(buildVersionKey{}).(buildVersion)
+	return version
+}
+
+// ldflags returns the linker flags that inject the version into a Go binary
+func (v buildVersion) ldflags() string {
+	var flags []string
+	if v.Commit != "" {
+		flags = append(flags, "-X main.commitSHA="+v.Commit)
+	}
+	if v.DeployID != "" {
+		flags = append(flags, "-X main.deployID="+v.DeployID)
+	}
+	return strings.Join(flags, " ")
+}
+
+// toolchainVersion returns the first line a compiler prints about its
+// version, or an empty string if it cannot be run
+func toolchainVersion(ctx context.Context, env []string, name string, args ...string) string {
+	cmd := commandContext(ctx, name, args...)
+	cmd.Env = env
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return line
+}
+
+// sourceCommit resolves the commit checked out in a source tree
+func sourceCommit(ctx context.Context, sourceDir string) (string, error) {
+	cmd := commandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = sourceDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// artifactSize returns the size of a build artifact in bytes. For a
+// directory it is the total size of the regular files inside.
+func artifactSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// recordProvenance stores how the artifact a deployment installs was built
+func (p *Pipeline) recordProvenance(ctx context.Context, deploymentID, buildID string, result BuildResult, reused bool) {
+	fields := errors.FieldMap{
+		"deployment_id": deploymentID,
+		"build_id":      buildID,
+	}
+
+	path := result.artifactPath()
+	checksum, err := artifactChecksum(path)
+	if err != nil {
+		p.logger.Warn(ctx, "Failed to checksum build artifact", errors.WithField(fields, "error", err.Error()))
+		return
+	}
+	size, err := artifactSize(path)
+	if err != nil {
+		p.logger.Warn(ctx, "Failed to measure build artifact", errors.WithField(fields, "error", err.Error()))
+		return
+	}
+
+	provenance := &db.Provenance{
+		DeploymentID:   deploymentID,
+		BuildID:        buildID,
+		CommitSHA:      result.Commit,
+		AppType:        result.Type,
+		Toolchain:      result.Toolchain,
+		BuildFlags:     result.BuildFlags,
+		DurationMS:     result.Duration.Milliseconds(),
+		ArtifactSHA256: checksum,
+		ArtifactSize:   size,
+		Reused:         reused,
+	}
+
+	deployLogFromContext(ctx).Printf("Artifact sha256:%s (%d bytes) built from commit %s", checksum, size, result.Commit)
+
+	if err := p.database.CreateProvenance(ctx, provenance); err != nil {
+		p.logger.Warn(ctx, "Failed to record build provenance", errors.WithField(fields, "error", err.Error()))
+	}
+}
+
+// versionEnv returns the variables that tell an app which code it runs
+func versionEnv(releaseID string, state deployState) []string {
+	env := []string{fmt.Sprintf("SKYLINE_DEPLOY_ID=%s", releaseID)}
+	if state.Commit != "" {
+		env = append(env, fmt.Sprintf("SKYLINE_COMMIT_SHA=%s", state.Commit))
+	}
+	return env
+}
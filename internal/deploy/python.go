@@ -0,0 +1,421 @@
+package deploy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// Python apps are deployed as a whole directory with a virtualenv of their
+// own in .venv. Dependencies are built into wheels first, which are kept in
+// WheelCacheDir, and the virtualenv is installed from those wheels only. When
+// the package index cannot be reached, builds fall back to the cached wheels.
+const (
+	pythonAppDir      = "app"
+	pythonVenvDir     = ".venv"
+	pythonWheelsDir   = "wheels"
+	pythonDefaultPort = 8000
+)
+
+var (
+	// pythonAppPattern matches the application object of common frameworks,
+	// e.g. app = Flask(__name__)
+	pythonAppPattern = regexp.MustCompile(`(?m)^(\w+)\s*=\s*(Flask|FastAPI|Starlette|Quart|Litestar)\(`)
+	// pythonDjangoPattern matches the application of Django's wsgi.py and asgi.py
+	pythonDjangoPattern = regexp.MustCompile(`(?m)^(\w+)\s*=\s*get_(wsgi|asgi)_application\(`)
+)
+
+// pythonEntryPoint is the application object a WSGI or ASGI server runs
+type pythonEntryPoint struct {
+	Module string // e.g. mysite.wsgi
+	Object string // e.g. application
+	ASGI   bool
+}
+
+// pythonBuilder builds Python applications
+type pythonBuilder struct {
+	b *Builder
+}
+
+func (p *pythonBuilder) Name() string {
+	return "python"
+}
+
+func (p *pythonBuilder) Detect(ctx context.Context, sourceDir string) bool {
+	return isPythonApp(sourceDir)
+}
+
+func (p *pythonBuilder) Build(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
+	return p.b.buildPythonApp(ctx, sourceDir, outputDir, manifest, settings)
+}
+
+// buildPythonApp builds a Python application
+func (b *Builder) buildPythonApp(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
+	fields := errors.FieldMap{
+		"source_dir": sourceDir,
+		"output_dir": outputDir,
+		"type":       "python",
+	}
+	deployLog := deployLogFromContext(ctx)
+
+	// A target names the project directory, e.g. in a monorepo
+	appRoot := sourceDir
+	if manifest.Build.Target != "" {
+		appRoot = filepath.Join(sourceDir, manifest.Build.Target)
+	}
+	if !isPythonApp(appRoot) {
+		err := errors.New("requirements.txt or pyproject.toml not found")
+		b.logger.Error(ctx, err, "Python project detection failed", fields)
+		return BuildResult{}, err
+	}
+
+	b.logger.Info(ctx, "Building Python application", fields)
+
+	// Set up build environment
+	env := b.buildEnv(settings)
+	env = append(env, "PIP_DISABLE_PIP_VERSION_CHECK=1")
+
+	run := func(name string, args ...string) error {
+		deployLog.Printf("$ %s %s", filepath.Base(name), strings.Join(args, " "))
+		cmd := commandContext(ctx, name, args...)
+		cmd.Dir = appRoot
+		cmd.Env = env
+		output, err := runCommand(ctx, cmd)
+		if err != nil {
+			return fmt.Errorf("%s failed: %w: %s", filepath.Base(name), err, output)
+		}
+		return nil
+	}
+
+	// Copy the app and create its virtualenv next to it
+	appDir := filepath.Join(outputDir, pythonAppDir)
+	if err := copyAppDir(appRoot, appDir, ".git", pythonVenvDir); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to copy Python application")
+		b.logger.Error(ctx, wrappedErr, "Application copy failed", fields)
+		return BuildResult{}, wrappedErr
+	}
+	if err := sandboxFromContext(ctx).chown(appDir); err != nil {
+		b.logger.Error(ctx, err, "Application copy failed", fields)
+		return BuildResult{}, err
+	}
+
+	venvDir, err := filepath.Abs(filepath.Join(appDir, pythonVenvDir))
+	if err != nil {
+		return BuildResult{}, errors.Wrap(err, "failed to resolve virtualenv directory")
+	}
+	if err := run(b.config.PythonBinary, "-m", "venv", venvDir); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to create virtualenv")
+		b.logger.Error(ctx, wrappedErr, "Virtualenv creation failed", fields)
+		return BuildResult{}, wrappedErr
+	}
+	python := filepath.Join(venvDir, "bin", "python")
+
+	// Build the dependencies into wheels, from the index when it can be
+	// reached and from the wheel cache otherwise
+	wheelsDir, err := filepath.Abs(filepath.Join(outputDir, pythonWheelsDir))
+	if err != nil {
+		return BuildResult{}, errors.Wrap(err, "failed to resolve wheels directory")
+	}
+	if err := b.buildWheels(ctx, run, python, appRoot, wheelsDir); err != nil {
+		b.logger.Error(ctx, err, "Python dependency build failed", fields)
+		return BuildResult{}, err
+	}
+
+	// Install the wheels into the virtualenv without touching the network
+	wheels, _ := filepath.Glob(filepath.Join(wheelsDir, "*.whl"))
+	if len(wheels) > 0 {
+		args := append([]string{"-m", "pip", "install", "--no-index", "--no-deps"}, wheels...)
+		if err := run(python, args...); err != nil {
+			wrappedErr := errors.Wrap(err, "failed to install dependencies")
+			b.logger.Error(ctx, wrappedErr, "Python dependency installation failed", fields)
+			return BuildResult{}, wrappedErr
+		}
+	}
+	os.RemoveAll(wheelsDir)
+
+	// The virtualenv is copied into every release, so its scripts must not
+	// point at the build directory
+	if err := relocateVenv(venvDir); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to make virtualenv relocatable")
+		b.logger.Error(ctx, wrappedErr, "Virtualenv relocation failed", fields)
+		return BuildResult{}, wrappedErr
+	}
+
+	command, err := pythonStartCommand(appDir)
+	if err != nil {
+		b.logger.Error(ctx, err, "Python start command not found", fields)
+		return BuildResult{}, err
+	}
+	fields["command"] = strings.Join(command, " ")
+
+	// Determine database, port and static assets
+	hasDB := len(manifest.Databases) > 0 || pythonUsesSQLite(appRoot)
+	port := manifest.Run.Port
+	if port == 0 {
+		port = pythonDefaultPort
+	}
+	hasStatic, staticDir := detectStaticAssets(appDir)
+	if manifest.Static != "" {
+		destStaticDir := filepath.Join(outputDir, "static")
+		if err := copyPublishedDir(filepath.Join(sourceDir, manifest.Static), destStaticDir, sourceDir); err != nil {
+			b.logger.Warn(ctx, "Failed to copy static assets", errors.WithField(fields, "error", err.Error()))
+		} else {
+			hasStatic, staticDir = true, destStaticDir
+		}
+	}
+	fields["has_database"] = hasDB
+	fields["port"] = port
+	fields["has_static"] = hasStatic
+
+	result := BuildResult{
+		Type:        "python",
+		AppDir:      appDir,
+		Toolchain:   toolchainVersion(ctx, env, b.config.PythonBinary, "--version"),
+		Command:     command,
+		BinDirs:     []string{filepath.Join(pythonVenvDir, "bin")},
+		Environment: map[string]string{"PYTHONUNBUFFERED": "1"},
+		Port:        port,
+		HasDatabase: hasDB,
+		HasStatic:   hasStatic,
+		StaticDir:   staticDir,
+		Manifest:    manifest,
+	}
+
+	b.logger.Info(ctx, "Python application built successfully", fields)
+	return result, nil
+}
+
+// buildWheels builds wheels of the dependencies of a Python app, and of the
+// app itself if it is a pyproject.toml project, and keeps them in the wheel
+// cache for later builds
+func (b *Builder) buildWheels(ctx context.Context, run func(string, ...string) error, python, appRoot, wheelsDir string) error {
+	args := []string{"-m", "pip", "wheel", "--wheel-dir", wheelsDir}
+	if b.config.WheelCacheDir != "" {
+		if err := os.MkdirAll(b.config.WheelCacheDir, 0755); err != nil {
+			return errors.Wrap(err, "failed to create wheel cache")
+		}
+		cacheDir, err := filepath.Abs(b.config.WheelCacheDir)
+		if err != nil {
+			return errors.Wrap(err, "failed to resolve wheel cache")
+		}
+		args = append(args, "--find-links", cacheDir)
+	}
+
+	if _, err := os.Stat(filepath.Join(appRoot, "pyproject.toml")); err == nil {
+		args = append(args, ".")
+	} else {
+		args = append(args, "-r", "requirements.txt")
+	}
+
+	online := args
+	if b.config.PythonIndexURL != "" {
+		online = append([]string{args[0], args[1], args[2], "--index-url", b.config.PythonIndexURL}, args[3:]...)
+	}
+
+	if err := run(python, online...); err != nil {
+		if b.config.WheelCacheDir == "" {
+			return errors.Wrap(err, "failed to build dependencies")
+		}
+
+		deployLogFromContext(ctx).Printf("Package index unavailable, building from the wheel cache")
+		offline := append([]string{args[0], args[1], args[2], "--no-index"}, args[3:]...)
+		if err := run(python, offline...); err != nil {
+			return errors.Wrap(err, "failed to build dependencies from the wheel cache")
+		}
+	}
+
+	// Keep the wheels for offline builds
+	if b.config.WheelCacheDir != "" {
+		wheels, _ := filepath.Glob(filepath.Join(wheelsDir, "*.whl"))
+		for _, wheel := range wheels {
+			cached := filepath.Join(b.config.WheelCacheDir, filepath.Base(wheel))
+			if _, err := os.Stat(cached); err == nil {
+				continue
+			}
+			if err := copyFile(wheel, cached); err != nil {
+				b.logger.Warn(ctx, "Failed to cache wheel", errors.FieldMap{
+					"wheel": filepath.Base(wheel),
+					"error": err.Error(),
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+// relocateVenv rewrites the scripts of a virtualenv to find its interpreter
+// on PATH instead of at the absolute path it was created at
+func relocateVenv(venvDir string) error {
+	binDir := filepath.Join(venvDir, "bin") + string(filepath.Separator)
+
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		path := filepath.Join(binDir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(string(content), "#!") {
+			continue
+		}
+
+		// pip writes "#!<venv>/bin/python", or an exec line for long paths
+		script := strings.Replace(string(content), "#!"+binDir, "#!/usr/bin/env ", 1)
+		script = strings.Replace(script, `'exec' "`+binDir, `'exec' "`, 1)
+		if script == string(content) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(script), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isPythonApp checks if the directory contains a Python application
+func isPythonApp(dir string) bool {
+	for _, name := range []string{"requirements.txt", "pyproject.toml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// pythonStartCommand returns the command that starts a Python app: the web
+// process of its Procfile, or a WSGI or ASGI server for its entry point. It
+// runs through the shell so $PORT is expanded and the virtualenv's tools are
+// found on PATH.
+func pythonStartCommand(appDir string) ([]string, error) {
+	if command := procfileCommand(appDir, "web"); command != "" {
+		return []string{"sh", "-c", "exec " + command}, nil
+	}
+
+	entry, err := findPythonEntryPoint(appDir)
+	if err != nil {
+		return nil, err
+	}
+	target := entry.Module + ":" + entry.Object
+
+	hasServer := func(name string) bool {
+		return isExecutable(filepath.Join(appDir, pythonVenvDir, "bin", name))
+	}
+
+	switch {
+	case entry.ASGI && hasServer("uvicorn"):
+		return []string{"sh", "-c", fmt.Sprintf(`exec uvicorn %s --host 0.0.0.0 --port "$PORT"`, target)}, nil
+	case entry.ASGI && hasServer("gunicorn"):
+		return []string{"sh", "-c", fmt.Sprintf(`exec gunicorn --bind "0.0.0.0:$PORT" --worker-class uvicorn.workers.UvicornWorker %s`, target)}, nil
+	case !entry.ASGI && hasServer("gunicorn"):
+		return []string{"sh", "-c", fmt.Sprintf(`exec gunicorn --bind "0.0.0.0:$PORT" %s`, target)}, nil
+	case entry.ASGI:
+		return nil, fmt.Errorf("found ASGI application %s, but neither uvicorn nor gunicorn is installed", target)
+	default:
+		return nil, fmt.Errorf("found WSGI application %s, but gunicorn is not installed", target)
+	}
+}
+
+// procfileCommand returns the command of a process type in a Procfile
+func procfileCommand(dir, process string) string {
+	file, err := os.Open(filepath.Join(dir, "Procfile"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, command, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(name) == process {
+			return strings.TrimSpace(command)
+		}
+	}
+
+	return ""
+}
+
+// findPythonEntryPoint looks for the application object in the top-level
+// modules of an app and in the project packages of a Django app
+func findPythonEntryPoint(appDir string) (pythonEntryPoint, error) {
+	// Django keeps wsgi.py and asgi.py in the project package
+	if _, err := os.Stat(filepath.Join(appDir, "manage.py")); err == nil {
+		for _, name := range []string{"wsgi.py", "asgi.py"} {
+			matches, _ := filepath.Glob(filepath.Join(appDir, "*", name))
+			sort.Strings(matches)
+			for _, match := range matches {
+				content, err := os.ReadFile(match)
+				if err != nil {
+					continue
+				}
+				if m := pythonDjangoPattern.FindSubmatch(content); m != nil {
+					pkg := filepath.Base(filepath.Dir(match))
+					return pythonEntryPoint{
+						Module: pkg + "." + strings.TrimSuffix(name, ".py"),
+						Object: string(m[1]),
+						ASGI:   string(m[2]) == "asgi",
+					}, nil
+				}
+			}
+		}
+	}
+
+	for _, name := range []string{"app.py", "main.py", "wsgi.py", "asgi.py", "server.py"} {
+		content, err := os.ReadFile(filepath.Join(appDir, name))
+		if err != nil {
+			continue
+		}
+
+		module := strings.TrimSuffix(name, ".py")
+		if m := pythonAppPattern.FindSubmatch(content); m != nil {
+			return pythonEntryPoint{
+				Module: module,
+				Object: string(m[1]),
+				ASGI:   string(m[2]) != "Flask",
+			}, nil
+		}
+		if m := pythonDjangoPattern.FindSubmatch(content); m != nil {
+			return pythonEntryPoint{
+				Module: module,
+				Object: string(m[1]),
+				ASGI:   string(m[2]) == "asgi",
+			}, nil
+		}
+	}
+
+	return pythonEntryPoint{}, errors.New("no Procfile web process and no WSGI or ASGI application found")
+}
+
+// pythonUsesSQLite checks the declared dependencies of a Python app for a
+// SQLite driver
+func pythonUsesSQLite(dir string) bool {
+	for _, name := range []string{"requirements.txt", "pyproject.toml"} {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil && strings.Contains(strings.ToLower(string(content)), "sqlite") {
+			return true
+		}
+	}
+	return false
+}
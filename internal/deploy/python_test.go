@@ -0,0 +1,233 @@
+package deploy
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danbruder/skyline/internal/db"
+)
+
+func TestFindPythonEntryPoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    pythonEntryPoint
+		wantErr bool
+	}{
+		{
+			name:  "Flask",
+			files: map[string]string{"app.py": "from flask import Flask\n\napp = Flask(__name__)\n"},
+			want:  pythonEntryPoint{Module: "app", Object: "app"},
+		},
+		{
+			name:  "FastAPI",
+			files: map[string]string{"main.py": "from fastapi import FastAPI\napi = FastAPI()\n"},
+			want:  pythonEntryPoint{Module: "main", Object: "api", ASGI: true},
+		},
+		{
+			name: "Django",
+			files: map[string]string{
+				"manage.py":       "",
+				"mysite/wsgi.py":  "application = get_wsgi_application()\n",
+				"mysite/asgi.py":  "application = get_asgi_application()\n",
+				"mysite/urls.py":  "",
+				"polls/models.py": "",
+			},
+			want: pythonEntryPoint{Module: "mysite.wsgi", Object: "application"},
+		},
+		{
+			name:    "No application",
+			files:   map[string]string{"main.py": "print('hello')\n"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			got, err := findPythonEntryPoint(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findPythonEntryPoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("findPythonEntryPoint() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPythonStartCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "Procfile",
+			files: map[string]string{"Procfile": "release: python manage.py migrate\nweb: gunicorn app:app\n"},
+			want:  "exec gunicorn app:app",
+		},
+		{
+			name: "WSGI with gunicorn",
+			files: map[string]string{
+				"app.py":             "app = Flask(__name__)\n",
+				".venv/bin/gunicorn": "#!/bin/sh\n",
+			},
+			want: `exec gunicorn --bind "0.0.0.0:$PORT" app:app`,
+		},
+		{
+			name: "ASGI with uvicorn",
+			files: map[string]string{
+				"main.py":           "app = FastAPI()\n",
+				".venv/bin/uvicorn": "#!/bin/sh\n",
+			},
+			want: `exec uvicorn main:app --host 0.0.0.0 --port "$PORT"`,
+		},
+		{
+			name:    "No server installed",
+			files:   map[string]string{"app.py": "app = Flask(__name__)\n"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			for name := range tt.files {
+				if strings.HasPrefix(name, ".venv/bin/") {
+					os.Chmod(filepath.Join(dir, name), 0755)
+				}
+			}
+
+			got, err := pythonStartCommand(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pythonStartCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, []string{"sh", "-c", tt.want}) {
+				t.Errorf("pythonStartCommand() = %q, want sh -c %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPythonAppOffline(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not installed")
+	}
+	if err := exec.Command(python, "-m", "venv", "--help").Run(); err != nil {
+		t.Skip("python3 venv module not installed")
+	}
+
+	ctx := context.Background()
+
+	// The wheel cache holds a server package that installs a gunicorn script
+	cacheDir := t.TempDir()
+	writeWheel(t, filepath.Join(cacheDir, "fakeserver-1.0-py3-none-any.whl"), map[string]string{
+		"fakeserver/__init__.py":                    "def main():\n    print('serving')\n",
+		"fakeserver-1.0.dist-info/METADATA":         "Metadata-Version: 2.1\nName: fakeserver\nVersion: 1.0\n",
+		"fakeserver-1.0.dist-info/WHEEL":            "Wheel-Version: 1.0\nGenerator: test\nRoot-Is-Purelib: true\nTag: py3-none-any\n",
+		"fakeserver-1.0.dist-info/entry_points.txt": "[console_scripts]\ngunicorn = fakeserver:main\n",
+		"fakeserver-1.0.dist-info/RECORD":           "",
+	})
+
+	sourceDir := t.TempDir()
+	writeFiles(t, sourceDir, map[string]string{
+		"requirements.txt": "fakeserver==1.0\n",
+		"app.py":           "app = Flask(__name__)\n",
+	})
+
+	// The index cannot be reached, so the build has to use the cache
+	b := NewBuilder(BuildConfig{
+		OutputDir:      t.TempDir(),
+		PythonIndexURL: "http://127.0.0.1:1/simple",
+		WheelCacheDir:  cacheDir,
+		EnvVars:        map[string]string{"PIP_RETRIES": "0", "PIP_TIMEOUT": "1"},
+		Sandbox:        testSandbox,
+	}, newMockLogger(t))
+
+	result, err := b.DetectAndBuild(ctx, sourceDir, "app/1", nil, db.BuildSettings{})
+	if err != nil {
+		t.Fatalf("DetectAndBuild() error = %v", err)
+	}
+
+	if result.Type != "python" || result.Port != pythonDefaultPort {
+		t.Errorf("DetectAndBuild() = %+v, want a python app on the default port", result)
+	}
+	if want := []string{"sh", "-c", `exec gunicorn --bind "0.0.0.0:$PORT" app:app`}; !reflect.DeepEqual(result.Command, want) {
+		t.Errorf("Command = %q, want %q", result.Command, want)
+	}
+
+	// Scripts in the virtualenv find their interpreter on PATH
+	script, err := os.ReadFile(filepath.Join(result.AppDir, ".venv", "bin", "gunicorn"))
+	if err != nil {
+		t.Fatalf("gunicorn was not installed: %v", err)
+	}
+	if !strings.HasPrefix(string(script), "#!/usr/bin/env python") {
+		t.Errorf("gunicorn starts with %q, want a relocatable interpreter line", strings.SplitN(string(script), "\n", 2)[0])
+	}
+
+	// The release runs from a copy of the app directory
+	releaseDir := filepath.Join(t.TempDir(), "app")
+	if err := copyDir(result.AppDir, releaseDir); err != nil {
+		t.Fatalf("Failed to copy app: %v", err)
+	}
+	cmd := exec.Command(filepath.Join(releaseDir, ".venv", "bin", "gunicorn"))
+	cmd.Dir = releaseDir
+	cmd.Env = append(os.Environ(), "PATH="+filepath.Join(releaseDir, ".venv", "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+	output, err := cmd.CombinedOutput()
+	if err != nil || strings.TrimSpace(string(output)) != "serving" {
+		t.Errorf("gunicorn in the copied release = %q (%v), want serving", output, err)
+	}
+}
+
+// writeFiles writes files below a directory
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+// writeWheel writes a wheel archive with the given files
+func writeWheel(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create wheel: %v", err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s to wheel: %v", name, err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write %s to wheel: %v", name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to write wheel: %v", err)
+	}
+}
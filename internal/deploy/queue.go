@@ -0,0 +1,157 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// RunFunc runs a single deployment
+type RunFunc func(ctx context.Context, deployment *db.Deployment) error
+
+// DeployQueue runs deployments one at a time per app. While a deployment is
+// running, only the newest pending deployment of each type is kept; older
+// pending ones of the same type are marked as superseded. A push never
+// supersedes a pending rollback, nor a rollback a pending deploy.
+type DeployQueue struct {
+	logger   errors.Logger
+	database *db.Database
+	run      RunFunc
+	apps     map[string]*appQueue
+	mu       sync.Mutex
+}
+
+// appQueue holds the running and pending deployments of a single app, the
+// pending ones in the order they were queued
+type appQueue struct {
+	running *db.Deployment
+	cancel  context.CancelFunc
+	pending []*db.Deployment
+}
+
+// NewDeployQueue creates a new DeployQueue
+func NewDeployQueue(logger errors.Logger, database *db.Database, run RunFunc) *DeployQueue {
+	return &DeployQueue{
+		logger:   logger,
+		database: database,
+		run:      run,
+		apps:     make(map[string]*appQueue),
+	}
+}
+
+// Enqueue schedules a deployment, starting it right away if no other
+// deployment of the same app is running
+func (q *DeployQueue) Enqueue(deployment *db.Deployment) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	aq, exists := q.apps[deployment.AppID]
+	if !exists {
+		aq = &appQueue{}
+		q.apps[deployment.AppID] = aq
+	}
+
+	if aq.running == nil {
+		q.start(aq, deployment)
+		return
+	}
+
+	pending := aq.pending[:0]
+	for _, queued := range aq.pending {
+		if queued.Type == deployment.Type {
+			q.finish(queued, "superseded", fmt.Sprintf("Superseded by deployment %s", deployment.ID))
+			continue
+		}
+		pending = append(pending, queued)
+	}
+	aq.pending = append(pending, deployment)
+
+	q.logger.Info(context.Background(), "Deployment queued behind running deployment", errors.FieldMap{
+		"app_id":        deployment.AppID,
+		"deployment_id": deployment.ID,
+		"running_id":    aq.running.ID,
+	})
+}
+
+// Cancel cancels a pending or running deployment. A running deployment is
+// stopped by cancelling its context, which kills any running git or build
+// process.
+func (q *DeployQueue) Cancel(deploymentID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, aq := range q.apps {
+		if aq.running != nil && aq.running.ID == deploymentID {
+			aq.cancel()
+			return nil
+		}
+
+		for i, pending := range aq.pending {
+			if pending.ID == deploymentID {
+				q.finish(pending, "cancelled", "Deployment cancelled before it started")
+				aq.pending = append(aq.pending[:i], aq.pending[i+1:]...)
+				return nil
+			}
+		}
+	}
+
+	return errors.Wrap(errors.ErrRecordNotFound, "deployment is not pending or running")
+}
+
+// start runs a deployment in the background. Must be called with q.mu held.
+func (q *DeployQueue) start(aq *appQueue, deployment *db.Deployment) {
+	ctx, cancel := context.WithCancel(context.Background())
+	aq.running = deployment
+	aq.cancel = cancel
+
+	go q.work(aq, ctx, deployment)
+}
+
+// work runs deployments of an app until none are pending
+func (q *DeployQueue) work(aq *appQueue, ctx context.Context, deployment *db.Deployment) {
+	for {
+		if err := q.run(ctx, deployment); err != nil {
+			q.logger.Error(ctx, err, "Queued deployment failed", errors.FieldMap{
+				"app_id":        deployment.AppID,
+				"deployment_id": deployment.ID,
+			})
+		}
+
+		q.mu.Lock()
+		aq.cancel()
+
+		if len(aq.pending) == 0 {
+			delete(q.apps, deployment.AppID)
+			q.mu.Unlock()
+			return
+		}
+
+		next := aq.pending[0]
+		aq.pending = aq.pending[1:]
+		ctx, aq.cancel = context.WithCancel(context.Background())
+		aq.running = next
+		q.mu.Unlock()
+
+		deployment = next
+	}
+}
+
+// finish marks a deployment that will never run as ended. Must be called
+// with q.mu held.
+func (q *DeployQueue) finish(deployment *db.Deployment, status, logs string) {
+	deployment.Status = status
+	deployment.Logs = logs
+	deployment.EndedAt = time.Now()
+
+	ctx := context.Background()
+	if err := q.database.UpdateDeployment(ctx, deployment); err != nil {
+		q.logger.Warn(ctx, "Failed to update deployment record", errors.FieldMap{
+			"deployment_id": deployment.ID,
+			"error":         err.Error(),
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package deploy
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danbruder/skyline/internal/db"
+)
+
+func TestDeployQueue(t *testing.T) {
+	ctx := context.Background()
+	logger := newMockLogger(t)
+
+	database, err := db.New(ctx, filepath.Join(t.TempDir(), "test.db"), logger)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	app := &db.App{Name: "queue-test", RepoURL: "https://github.com/example/repo", Branch: "main", Domain: "example.com"}
+	if err := database.CreateApp(ctx, app); err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	// Each run blocks until its context is cancelled or it is released
+	started := make(chan string, 4)
+	release := make(chan struct{})
+	queue := NewDeployQueue(logger, database, func(ctx context.Context, deployment *db.Deployment) error {
+		started <- deployment.ID
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-release:
+			return nil
+		}
+	})
+
+	deployments := make([]*db.Deployment, 3)
+	for i := range deployments {
+		deployments[i] = &db.Deployment{AppID: app.ID, CommitSHA: "HEAD"}
+		if err := database.CreateDeployment(ctx, deployments[i]); err != nil {
+			t.Fatalf("Failed to create deployment: %v", err)
+		}
+	}
+	rollback := &db.Deployment{AppID: app.ID, Type: db.DeploymentTypeRollback, ReleaseID: deployments[0].ID}
+	if err := database.CreateDeployment(ctx, rollback); err != nil {
+		t.Fatalf("Failed to create rollback: %v", err)
+	}
+
+	waitStarted := func(want string) {
+		t.Helper()
+		select {
+		case got := <-started:
+			if got != want {
+				t.Fatalf("started deployment %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("deployment %s did not start", want)
+		}
+	}
+
+	queue.Enqueue(deployments[0])
+	waitStarted(deployments[0].ID)
+
+	// The second deployment is superseded by the third while the first runs,
+	// but the rollback queued between them is not
+	queue.Enqueue(deployments[1])
+	queue.Enqueue(rollback)
+	queue.Enqueue(deployments[2])
+
+	superseded, err := database.GetDeployment(ctx, deployments[1].ID)
+	if err != nil {
+		t.Fatalf("Failed to get deployment: %v", err)
+	}
+	if superseded.Status != "superseded" {
+		t.Errorf("pending deployment status = %s, want superseded", superseded.Status)
+	}
+
+	if err := queue.Cancel(deployments[1].ID); err == nil {
+		t.Errorf("Cancel() of a superseded deployment should fail")
+	}
+
+	// Cancelling the running deployment starts the pending ones in order
+	if err := queue.Cancel(deployments[0].ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	waitStarted(rollback.ID)
+	release <- struct{}{}
+	waitStarted(deployments[2].ID)
+
+	close(release)
+}
+
+func TestRecoverDeployments(t *testing.T) {
+	ctx := context.Background()
+	logger := newMockLogger(t)
+
+	database, err := db.New(ctx, filepath.Join(t.TempDir(), "test.db"), logger)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	app := &db.App{Name: "recover-test", RepoURL: "https://github.com/example/repo", Branch: "main", Domain: "example.com"}
+	if err := database.CreateApp(ctx, app); err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	// Deployments as a previous run left them
+	statuses := map[string]string{
+		"pending":     "failed",
+		"in_progress": "failed",
+		"success":     "success",
+		"cancelled":   "cancelled",
+	}
+	deployments := make(map[string]*db.Deployment)
+	for status := range statuses {
+		deployment := &db.Deployment{AppID: app.ID, CommitSHA: "HEAD", Status: status}
+		if err := database.CreateDeployment(ctx, deployment); err != nil {
+			t.Fatalf("Failed to create deployment: %v", err)
+		}
+		deployments[status] = deployment
+	}
+
+	p := &Pipeline{logger: logger, database: database}
+	if err := p.RecoverDeployments(ctx); err != nil {
+		t.Fatalf("RecoverDeployments() error = %v", err)
+	}
+
+	for status, want := range statuses {
+		deployment, err := database.GetDeployment(ctx, deployments[status].ID)
+		if err != nil {
+			t.Fatalf("Failed to get deployment: %v", err)
+		}
+		if deployment.Status != want {
+			t.Errorf("%s deployment status = %s, want %s", status, deployment.Status, want)
+		}
+		if want == "failed" && deployment.EndedAt.IsZero() {
+			t.Errorf("%s deployment has no end time", status)
+		}
+	}
+}
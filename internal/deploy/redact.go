@@ -0,0 +1,39 @@
+package deploy
+
+import (
+	"sort"
+	"strings"
+)
+
+// redactedSecret replaces secrets in redacted text
+const redactedSecret = "***"
+
+// Redactor masks known secrets, like the tokens of git hosts, in text before
+// it reaches logs and deployment records. A nil *Redactor masks nothing.
+type Redactor struct {
+	replacer *strings.Replacer
+}
+
+// NewRedactor creates a Redactor for secrets. Empty secrets are ignored.
+func NewRedactor(secrets ...string) *Redactor {
+	// Longer secrets go first so a secret containing another is masked whole
+	secrets = append([]string(nil), secrets...)
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+
+	var pairs []string
+	for _, secret := range secrets {
+		if secret != "" {
+			pairs = append(pairs, secret, redactedSecret)
+		}
+	}
+
+	return &Redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// Redact returns s with every secret masked
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
@@ -0,0 +1,23 @@
+package deploy
+
+import "testing"
+
+func TestRedactor(t *testing.T) {
+	redactor := NewRedactor("token", "", "token-with-suffix", "hunter2")
+
+	tests := map[string]string{
+		"fatal: https://token@github.com/acme/app": "fatal: https://***@github.com/acme/app",
+		"password token-with-suffix":               "password ***",
+		"hunter2 and token":                        "*** and ***",
+		"nothing secret":                           "nothing secret",
+	}
+	for input, want := range tests {
+		if got := redactor.Redact(input); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	if got := (*Redactor)(nil).Redact("token"); got != "token" {
+		t.Errorf("nil Redact() = %q, want the input", got)
+	}
+}
@@ -0,0 +1,112 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// LanguageBuilder detects and builds applications of a single stack
+type LanguageBuilder interface {
+	// Name identifies the stack, e.g. in the build.type setting of skyline.yml
+	Name() string
+	// Detect reports whether the source tree belongs to the stack
+	Detect(ctx context.Context, sourceDir string) bool
+	// Build builds the source tree into the output directory with the
+	// build settings of the app
+	Build(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error)
+}
+
+// Register adds a language builder. Builders are tried in the order they were
+// registered, before external builders.
+func (b *Builder) Register(builder LanguageBuilder) {
+	b.builders = append(b.builders, builder)
+}
+
+// languageBuilders returns the registered and external builders in
+// detection order
+func (b *Builder) languageBuilders() []LanguageBuilder {
+	builders := append([]LanguageBuilder(nil), b.builders...)
+	for _, builder := range b.externalBuilders() {
+		builders = append(builders, builder)
+	}
+	return builders
+}
+
+// selectBuilder returns the builder declared in the manifest, or the first
+// builder that detects the source tree
+func (b *Builder) selectBuilder(ctx context.Context, sourceDir string, manifest *Manifest) (LanguageBuilder, error) {
+	deployLog := deployLogFromContext(ctx)
+	builders := b.languageBuilders()
+
+	if manifest.Build.Type != "" {
+		for _, builder := range builders {
+			if builder.Name() == manifest.Build.Type {
+				deployLog.Printf("Building %s application declared in %s", builder.Name(), ManifestFile)
+				return builder, nil
+			}
+		}
+
+		return nil, fmt.Errorf("no builder for application type %q declared in %s (available: %s)",
+			manifest.Build.Type, ManifestFile, builderNames(builders))
+	}
+
+	for _, builder := range builders {
+		if builder.Detect(ctx, sourceDir) {
+			b.logger.Info(ctx, "Detected application type", errors.FieldMap{
+				"source_dir": sourceDir,
+				"type":       builder.Name(),
+			})
+			deployLog.Printf("Detected %s application", builder.Name())
+			return builder, nil
+		}
+	}
+
+	return nil, errors.New("unsupported application type")
+}
+
+// builderNames lists the names of builders
+func builderNames(builders []LanguageBuilder) string {
+	names := make([]string, len(builders))
+	for i, builder := range builders {
+		names[i] = builder.Name()
+	}
+	return strings.Join(names, ", ")
+}
+
+// goBuilder builds Go applications
+type goBuilder struct {
+	b *Builder
+}
+
+func (g *goBuilder) Name() string {
+	return "go"
+}
+
+func (g *goBuilder) Detect(ctx context.Context, sourceDir string) bool {
+	return isGoApp(sourceDir)
+}
+
+func (g *goBuilder) Build(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
+	return g.b.buildGoApp(ctx, sourceDir, outputDir, manifest, settings)
+}
+
+// rustBuilder builds Rust applications
+type rustBuilder struct {
+	b *Builder
+}
+
+func (r *rustBuilder) Name() string {
+	return "rust"
+}
+
+func (r *rustBuilder) Detect(ctx context.Context, sourceDir string) bool {
+	return isRustApp(sourceDir)
+}
+
+func (r *rustBuilder) Build(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
+	return r.b.buildRustApp(ctx, sourceDir, outputDir, manifest, settings)
+}
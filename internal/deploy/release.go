@@ -0,0 +1,167 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// Each deployment is installed into its own immutable release directory:
+//
+//	<AppsDir>/<appID>/releases/<releaseID>/bin/app
+//	<AppsDir>/<appID>/releases/<releaseID>/app (apps deployed as a directory)
+//	<AppsDir>/<appID>/releases/<releaseID>/site (static sites)
+//	<AppsDir>/<appID>/releases/<releaseID>/static
+//	<AppsDir>/<appID>/releases/<releaseID>/release.json
+//	<AppsDir>/<appID>/current -> releases/<releaseID>
+//	<AppsDir>/<appID>/static  -> current/static
+
+// deployState records how a release was built
+type deployState struct {
+	Type        string            `json:"type"`
+	Commit      string            `json:"commit,omitempty"`   // Commit the release was built from
+	Command     []string          `json:"command,omitempty"`  // Start command in the app directory
+	BinDirs     []string          `json:"bin_dirs,omitempty"` // Directories in the app directory added to PATH
+	Site        bool              `json:"site,omitempty"`     // Static site served by the proxy
+	Port        int               `json:"port"`
+	LivePort    int               `json:"live_port,omitempty"` // Port the process listens on once the release went live
+	HasDatabase bool              `json:"has_database"`
+	HasStatic   bool              `json:"has_static"`
+	Environment map[string]string `json:"environment,omitempty"`
+	Manifest    *Manifest         `json:"manifest,omitempty"`
+}
+
+// releaseDir returns the directory of a release
+func (d *Deployer) releaseDir(appID, releaseID string) string {
+	return filepath.Join(d.config.AppsDir, appID, "releases", releaseID)
+}
+
+// HasRelease reports whether a release is still installed
+func (d *Deployer) HasRelease(appID, releaseID string) bool {
+	if releaseID == "" {
+		return false
+	}
+
+	_, err := os.Stat(filepath.Join(d.releaseDir(appID, releaseID), "release.json"))
+	return err == nil
+}
+
+// CurrentRelease returns the ID of the release the current pointer refers to
+func (d *Deployer) CurrentRelease(appID string) (string, error) {
+	target, err := os.Readlink(filepath.Join(d.config.AppsDir, appID, "current"))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Base(target), nil
+}
+
+// switchCurrent atomically points the current pointer at a release
+func (d *Deployer) switchCurrent(appID, releaseID string) error {
+	appDir := filepath.Join(d.config.AppsDir, appID)
+	currentPath := filepath.Join(appDir, "current")
+	tmpPath := currentPath + ".tmp"
+
+	os.Remove(tmpPath)
+	if err := os.Symlink(filepath.Join("releases", releaseID), tmpPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, currentPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Apps read static assets relative to their working directory
+	staticPath := filepath.Join(appDir, "static")
+	if _, err := os.Lstat(staticPath); os.IsNotExist(err) {
+		if err := os.Symlink(filepath.Join("current", "static"), staticPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveState writes the state of a release to its directory
+func (d *Deployer) saveState(releaseDir string, state deployState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(releaseDir, "release.json"), data, 0644)
+}
+
+// loadState reads the state of a release from its directory
+func (d *Deployer) loadState(releaseDir string) (deployState, error) {
+	var state deployState
+
+	data, err := os.ReadFile(filepath.Join(releaseDir, "release.json"))
+	if err != nil {
+		return state, err
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("invalid release state: %w", err)
+	}
+
+	return state, nil
+}
+
+// pruneReleases removes the oldest releases beyond the retention limit,
+// never removing the current release
+func (d *Deployer) pruneReleases(ctx context.Context, appID string) {
+	releasesDir := filepath.Join(d.config.AppsDir, appID, "releases")
+	entries, err := os.ReadDir(releasesDir)
+	if err != nil || len(entries) <= d.config.KeepReleases {
+		return
+	}
+
+	current, _ := d.CurrentRelease(appID)
+
+	type release struct {
+		id      string
+		modTime int64
+	}
+	releases := make([]release, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		releases = append(releases, release{id: entry.Name(), modTime: info.ModTime().UnixNano()})
+	}
+
+	// Newest first
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].modTime > releases[j].modTime
+	})
+
+	for i, r := range releases {
+		if i < d.config.KeepReleases || r.id == current {
+			continue
+		}
+
+		d.removeRelease(ctx, appID, r.id)
+	}
+}
+
+// removeRelease removes the directory of a release
+func (d *Deployer) removeRelease(ctx context.Context, appID, releaseID string) {
+	if releaseID == "" {
+		return
+	}
+
+	if err := os.RemoveAll(d.releaseDir(appID, releaseID)); err != nil {
+		d.logger.Warn(ctx, "Failed to remove release", errors.FieldMap{
+			"app_id":     appID,
+			"release_id": releaseID,
+			"error":      err.Error(),
+		})
+	}
+}
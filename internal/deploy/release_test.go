@@ -0,0 +1,140 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danbruder/skyline/internal/db"
+)
+
+func TestReleases(t *testing.T) {
+	ctx := context.Background()
+	d := NewDeployer(DeployConfig{AppsDir: t.TempDir(), KeepReleases: 2}, newMockLogger(t), nil, nil, nil, nil)
+
+	// Install four releases, oldest first
+	releases := []string{"r1", "r2", "r3", "r4"}
+	for i, id := range releases {
+		dir := d.releaseDir("app", id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create release: %v", err)
+		}
+		if err := d.saveState(dir, deployState{Type: "go", Port: 8080 + i}); err != nil {
+			t.Fatalf("Failed to save release state: %v", err)
+		}
+		modTime := time.Now().Add(time.Duration(i-len(releases)) * time.Minute)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set release time: %v", err)
+		}
+	}
+
+	// Roll back to the oldest release, then switch between releases
+	for _, id := range []string{"r4", "r1"} {
+		if err := d.switchCurrent("app", id); err != nil {
+			t.Fatalf("switchCurrent(%s) error = %v", id, err)
+		}
+		current, err := d.CurrentRelease("app")
+		if err != nil || current != id {
+			t.Fatalf("CurrentRelease() = %q, %v, want %q", current, err, id)
+		}
+	}
+
+	state, err := d.loadState(filepath.Join(d.config.AppsDir, "app", "current"))
+	if err != nil || state.Port != 8080 {
+		t.Fatalf("loadState(current) = %+v, %v, want port 8080", state, err)
+	}
+
+	// The two newest releases are kept along with the current one
+	d.pruneReleases(ctx, "app")
+	for _, id := range releases {
+		want := id != "r2"
+		if got := d.HasRelease("app", id); got != want {
+			t.Errorf("HasRelease(%s) = %v, want %v", id, got, want)
+		}
+	}
+
+	if d.HasRelease("app", "") {
+		t.Error("HasRelease() with an empty release ID = true, want false")
+	}
+}
+
+func TestFailedReleasesRemoved(t *testing.T) {
+	ctx := context.Background()
+	logger := newMockLogger(t)
+
+	database, err := db.New(ctx, filepath.Join(t.TempDir(), "test.db"), logger)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	app := &db.App{Name: "release-test", RepoURL: "https://github.com/example/repo", Branch: "main", Domain: "example.com"}
+	if err := database.CreateApp(ctx, app); err != nil {
+		t.Fatalf("Failed to create app: %v", err)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("freePort() error = %v", err)
+	}
+	binary := filepath.Join(t.TempDir(), "app")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write binary: %v", err)
+	}
+
+	d := NewDeployer(DeployConfig{AppsDir: t.TempDir(), DataDir: t.TempDir(), KeepReleases: 1,
+		HealthTimeout: time.Second}, logger, database, &listeningSupervisor{t: t}, &routingProxy{}, nil)
+
+	if err := d.Deploy(ctx, BuildResult{Type: "go", BinaryPath: binary, Port: port}, app.ID, "live"); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	// A release that fails before going live leaves nothing behind, so the
+	// live release stays within the retention limit
+	missing := filepath.Join(t.TempDir(), "missing")
+	if err := d.Deploy(ctx, BuildResult{Type: "go", BinaryPath: missing, Port: port}, app.ID, "failed"); err == nil {
+		t.Fatal("Deploy() of a missing binary succeeded")
+	}
+	if d.HasRelease(app.ID, "failed") {
+		t.Error("failed release is still installed")
+	}
+	if _, err := os.Stat(d.releaseDir(app.ID, "failed")); !os.IsNotExist(err) {
+		t.Errorf("failed release directory exists, stat error = %v", err)
+	}
+	if current, err := d.CurrentRelease(app.ID); err != nil || current != "live" || !d.HasRelease(app.ID, "live") {
+		t.Errorf("CurrentRelease() = %q, %v, want the live release kept", current, err)
+	}
+}
+
+func TestHookEnvScrubbed(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("SKYLINE_HOOK_SENTINEL", "hunter2")
+
+	d := NewDeployer(DeployConfig{AppsDir: t.TempDir(), DataDir: t.TempDir()}, newMockLogger(t), nil, nil, nil, nil)
+	app := &db.App{ID: "app", Environment: []db.EnvVar{{Key: "APP_SETTING", Value: "1"}}}
+	releaseDir := d.releaseDir(app.ID, "r1")
+	if err := os.MkdirAll(releaseDir, 0755); err != nil {
+		t.Fatalf("Failed to create release: %v", err)
+	}
+
+	if err := d.runHooks(ctx, app, "r1", deployState{Type: "go", Port: 8080}, []string{"env > hook.env"}); err != nil {
+		t.Fatalf("runHooks() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(releaseDir, "hook.env"))
+	if err != nil {
+		t.Fatalf("hook did not run: %v", err)
+	}
+
+	env := string(content)
+	if strings.Contains(env, "SKYLINE_HOOK_SENTINEL") {
+		t.Error("hook environment contains a variable of Skyline's environment")
+	}
+	for _, want := range []string{"APP_SETTING=1", "PORT=8080", "SKYLINE_DEPLOY_ID=r1", "PATH=", "HOME="} {
+		if !strings.Contains(env, want) {
+			t.Errorf("hook environment lacks %s:\n%s", want, env)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// sandboxPassEnv are the variables of Skyline's environment builds see, so
+// they find their tools. Everything else, including secrets, is scrubbed.
+var sandboxPassEnv = []string{
+	"PATH", "LANG", "LC_ALL", "TZ", "SSL_CERT_FILE", "SSL_CERT_DIR",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+	"GOROOT", "GOTOOLCHAIN", "RUSTUP_HOME", "RUSTUP_TOOLCHAIN",
+}
+
+// offlineEnv makes package managers use their caches instead of the network
+var offlineEnv = []string{
+	"GOPROXY=off",
+	"CARGO_NET_OFFLINE=true",
+	"PIP_NO_INDEX=1",
+	"npm_config_offline=true",
+	"YARN_ENABLE_OFFLINE_MODE=1",
+}
+
+// Default limits of a build
+const (
+	defaultBuildCPUs         = 2
+	defaultBuildMemoryMB     = 4096
+	defaultBuildMaxProcesses = 1024
+)
+
+// cgroup2SuperMagic is the file system type of cgroup v2 mounts
+const cgroup2SuperMagic = 0x63677270
+
+// SandboxConfig confines the commands of builds. Builds run with a scrubbed
+// environment in a copy of the source, with CPU, memory and process limits,
+// and as an unprivileged user when Skyline runs as root. Only network
+// isolation is opt-in.
+type SandboxConfig struct {
+	User         string   // Unprivileged user builds run as; required when Skyline runs as root
+	AllowRoot    bool     // Build as root without a build user, giving builds the whole host
+	HomeDir      string   // HOME of builds, holding module and package caches
+	CgroupDir    string   // cgroup v2 directory the cgroups of builds are created in
+	CPUs         float64  // CPU limit of a build
+	MemoryMB     int      // Memory limit of a build
+	MaxProcesses int      // Limit on processes and threads of a build
+	Offline      bool     // Build without network, from pre-populated caches
+	PassEnv      []string // Further variables of Skyline's environment builds see
+}
+
+// SandboxWarnings describes what builds can do with the sandbox as
+// configured, to be shown when Skyline starts
+func (b *Builder) SandboxWarnings() []string {
+	c := b.config.Sandbox
+	var warnings []string
+	switch {
+	case c.User == "" && os.Geteuid() == 0 && !c.AllowRoot:
+		warnings = append(warnings, "builds fail because Skyline runs as root; set deploy.sandbox.user to an unprivileged user")
+	case c.User == "" && os.Geteuid() == 0:
+		warnings = append(warnings, "builds run as root because deploy.sandbox.allow_root is set, and can read and change everything on the host; set deploy.sandbox.user to an unprivileged user")
+	case c.User == "" && os.Geteuid() != 0:
+		warnings = append(warnings, "builds run as the Skyline user and can read its data directory, including the database and deploy key secret; run Skyline as root with deploy.sandbox.user set to an unprivileged user")
+	}
+	return warnings
+}
+
+type sandboxKey struct{}
+
+// sandbox confines the commands of a single build. A nil *sandbox confines
+// nothing.
+type sandbox struct {
+	config     SandboxConfig
+	logger     errors.Logger
+	credential *syscall.Credential
+	cgroupDir  string
+	cgroup     *os.File
+	prlimit    []string // Command setting rlimits, where cgroups are not available
+	workDir    string
+}
+
+// withSandbox returns a context whose commands run in a sandbox
+func withSandbox(ctx context.Context, s *sandbox) context.Context {
+	return context.WithValue(ctx, sandboxKey{}, s)
+}
+
+// sandboxFromContext returns the sandbox carried by ctx, or nil
+func sandboxFromContext(ctx context.Context) *sandbox {
+	s, _ := ctx.Value(sandboxKey{}).(*sandbox)
+	return s
+}
+
+// newSandbox creates the sandbox of a build: it resolves the build user and
+// creates a cgroup with the configured limits, or falls back to rlimits. A
+// build that cannot be confined fails rather than running unconfined.
+func (b *Builder) newSandbox(ctx context.Context, buildID string) (*sandbox, error) {
+	s := &sandbox{config: b.config.Sandbox, logger: b.logger}
+
+	if s.config.User == "" && os.Geteuid() == 0 && !s.config.AllowRoot {
+		return nil, fmt.Errorf("builds do not run as root; set deploy.sandbox.user to an unprivileged build user")
+	}
+
+	dirs := append([]string{s.config.HomeDir}, b.cacheDirs()...)
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Wrap(err, "failed to create build home and cache directories")
+		}
+	}
+
+	if s.config.User != "" {
+		if os.Geteuid() != 0 {
+			return nil, fmt.Errorf("building as user %s requires Skyline to run as root", s.config.User)
+		}
+
+		u, err := user.Lookup(s.config.User)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to look up build user")
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid uid of build user")
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid gid of build user")
+		}
+		s.credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), NoSetGroups: true}
+
+		for _, dir := range dirs {
+			if err := os.Chown(dir, int(uid), int(gid)); err != nil {
+				return nil, errors.Wrap(err, "failed to hand build home and cache directories to the build user")
+			}
+		}
+	}
+
+	cgroupErr := s.createCgroup(strings.ReplaceAll(buildID, "/", "-"))
+	if cgroupErr != nil {
+		s.Close(ctx)
+
+		// Without cgroups every process of the build gets the limits
+		// instead of the build as a whole
+		prlimit, err := exec.LookPath("prlimit")
+		if err != nil {
+			return nil, fmt.Errorf("failed to limit build: no cgroup (%v) and no prlimit to set rlimits (%v)", cgroupErr, err)
+		}
+		b.logger.Debug(ctx, "Limiting build with rlimits", errors.FieldMap{"reason": cgroupErr.Error()})
+		s.prlimit = s.config.rlimits(prlimit, b.config.BuildTimeout)
+	}
+
+	return s, nil
+}
+
+// rlimits returns the prlimit command that applies the limits of the sandbox
+// to a single process: its address space, its CPU time over the build
+// timeout, and the processes of the build user
+func (c SandboxConfig) rlimits(prlimit string, timeout time.Duration) []string {
+	limit := func(name string, value uint64) string {
+		return fmt.Sprintf("--%s=%d:%d", name, value, value)
+	}
+	return []string{
+		prlimit,
+		limit("as", uint64(c.MemoryMB)*1024*1024),
+		limit("cpu", uint64(c.CPUs*timeout.Seconds())),
+		limit("nproc", uint64(c.MaxProcesses)),
+		"--",
+	}
+}
+
+// cgroup2Available reports whether a directory is, or would be created, in
+// a cgroup v2 hierarchy
+func cgroup2Available(dir string) bool {
+	for {
+		var fs syscall.Statfs_t
+		if err := syscall.Statfs(dir, &fs); err == nil {
+			return fs.Type == cgroup2SuperMagic
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// createCgroup creates the cgroup commands of the build start in, with
+// limits on CPU, memory and processes
+func (s *sandbox) createCgroup(name string) error {
+	if !cgroup2Available(s.config.CgroupDir) {
+		return fmt.Errorf("%s is not in a cgroup v2 hierarchy", s.config.CgroupDir)
+	}
+	if err := os.MkdirAll(s.config.CgroupDir, 0755); err != nil {
+		return err
+	}
+	// The controllers may already be enabled; limits fail below if they
+	// are not available at all
+	os.WriteFile(filepath.Join(s.config.CgroupDir, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0644)
+
+	dir := filepath.Join(s.config.CgroupDir, name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	s.cgroupDir = dir
+
+	limits := map[string]string{
+		"cpu.max":         fmt.Sprintf("%d 100000", int(s.config.CPUs*100000)),
+		"memory.max":      strconv.Itoa(s.config.MemoryMB * 1024 * 1024),
+		"memory.swap.max": "0",
+		"pids.max":        strconv.Itoa(s.config.MaxProcesses),
+	}
+	for file, value := range limits {
+		err := os.WriteFile(filepath.Join(s.cgroupDir, file), []byte(value), 0644)
+		if err != nil && !(file == "memory.swap.max" && os.IsNotExist(err)) {
+			return fmt.Errorf("failed to set %s: %w", file, err)
+		}
+	}
+
+	cgroup, err := os.Open(s.cgroupDir)
+	if err != nil {
+		return err
+	}
+	s.cgroup = cgroup
+	return nil
+}
+
+// prepare gives the build a copy of the source tree to build in, so builds
+// never write to the fetched source, and hands it and the output directory
+// to the build user. It returns the directory to build in.
+func (s *sandbox) prepare(sourceDir, outputDir string) (string, error) {
+	if s == nil {
+		return sourceDir, nil
+	}
+
+	workDir, err := os.MkdirTemp(s.config.HomeDir, "work-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create build directory")
+	}
+	s.workDir = workDir
+
+	// The .git file of a worktree points into the mirror, which the build
+	// user cannot use; builds are stamped with their commit instead
+	buildDir := filepath.Join(workDir, "src")
+	if err := copyAppDir(sourceDir, buildDir, ".git"); err != nil {
+		return "", errors.Wrap(err, "failed to copy source to build directory")
+	}
+
+	for _, dir := range []string{workDir, outputDir} {
+		if err := s.chown(dir); err != nil {
+			return "", err
+		}
+	}
+
+	return buildDir, nil
+}
+
+// chown hands a directory tree created by Skyline to the build user
+func (s *sandbox) chown(dir string) error {
+	if s == nil || s.credential == nil {
+		return nil
+	}
+
+	uid, gid := int(s.credential.Uid), int(s.credential.Gid)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to hand build directory to the build user")
+	}
+	return nil
+}
+
+// apply confines a command to the sandbox
+func (s *sandbox) apply(cmd *exec.Cmd) {
+	if s == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	if s.credential != nil {
+		cmd.SysProcAttr.Credential = s.credential
+	}
+
+	if s.cgroup != nil {
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(s.cgroup.Fd())
+	}
+
+	// prlimit sets the limits on itself and runs the command in its place
+	if s.prlimit != nil && cmd.Err == nil {
+		cmd.Args = append(append(append([]string(nil), s.prlimit...), cmd.Path), cmd.Args[1:]...)
+		cmd.Path = s.prlimit[0]
+	}
+
+	// A network namespace of its own has no interfaces but a loopback that
+	// is down. Without root, a user namespace allows creating it.
+	if s.config.Offline {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+		if os.Geteuid() != 0 {
+			cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+			cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+			cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+		}
+	}
+}
+
+// Close kills what is left of the build and removes its cgroup and build
+// directory
+func (s *sandbox) Close(ctx context.Context) {
+	if s == nil {
+		return
+	}
+
+	if s.cgroup != nil {
+		s.cgroup.Close()
+		s.cgroup = nil
+	}
+	if s.cgroupDir != "" {
+		// Killed processes leave the cgroup shortly after
+		os.WriteFile(filepath.Join(s.cgroupDir, "cgroup.kill"), []byte("1"), 0644)
+		err := os.Remove(s.cgroupDir)
+		for i := 0; err != nil && i < 20; i++ {
+			time.Sleep(50 * time.Millisecond)
+			err = os.Remove(s.cgroupDir)
+		}
+		if err != nil {
+			s.logger.Warn(ctx, "Failed to remove build cgroup",
+				errors.FieldMap{"cgroup": s.cgroupDir, "error": err.Error()})
+		}
+		s.cgroupDir = ""
+	}
+
+	if s.workDir != "" {
+		if err := os.RemoveAll(s.workDir); err != nil {
+			s.logger.Warn(ctx, "Failed to remove build directory",
+				errors.FieldMap{"dir": s.workDir, "error": err.Error()})
+		}
+	}
+}
+
+// env returns the base environment of build commands: HOME in the build
+// home directory, the variables builds may see and, offline, the settings
+// that keep package managers off the network
+func (c SandboxConfig) env() []string {
+	env := []string{"HOME=" + c.HomeDir}
+	for _, name := range append(append([]string(nil), sandboxPassEnv...), c.PassEnv...) {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	if c.Offline {
+		env = append(env, offlineEnv...)
+	}
+	return env
+}
@@ -0,0 +1,214 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/danbruder/skyline/internal/db"
+)
+
+// testSandbox lets builds of tests, which may run as root, run without a
+// build user
+var testSandbox = SandboxConfig{AllowRoot: true}
+
+func TestBuildEnvScrubbed(t *testing.T) {
+	t.Setenv("SKYLINE_SECRET", "hunter2")
+	t.Setenv("HTTPS_PROXY", "http://proxy:3128")
+	t.Setenv("NPM_TOKEN", "npm-secret")
+
+	home := t.TempDir()
+	b := NewBuilder(BuildConfig{
+		EnvVars: map[string]string{"CONFIGURED": "1"},
+		Sandbox: SandboxConfig{HomeDir: home, PassEnv: []string{"NPM_TOKEN"}, Offline: true},
+	}, newMockLogger(t))
+
+	env := b.buildEnv(db.BuildSettings{Env: map[string]string{"APP": "2"}})
+	got := make(map[string]string)
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		got[key] = value
+	}
+
+	want := map[string]string{
+		"HOME":        home,
+		"HTTPS_PROXY": "http://proxy:3128",
+		"NPM_TOKEN":   "npm-secret",
+		"CONFIGURED":  "1",
+		"APP":         "2",
+		"GOPROXY":     "off",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("build environment %s = %q, want %q", key, got[key], value)
+		}
+	}
+	if _, ok := got["SKYLINE_SECRET"]; ok {
+		t.Error("build environment contains SKYLINE_SECRET")
+	}
+}
+
+func TestSandboxCommand(t *testing.T) {
+	ctx := context.Background()
+
+	config := SandboxConfig{HomeDir: t.TempDir(), Offline: true}
+	wantUID := os.Getuid()
+	if os.Geteuid() == 0 {
+		if nobody, err := user.Lookup("nobody"); err == nil {
+			config.User = "nobody"
+			wantUID, _ = strconv.Atoi(nobody.Uid)
+		}
+	}
+
+	b := NewBuilder(BuildConfig{OutputDir: filepath.Join(t.TempDir(), "builds"), Sandbox: config}, newMockLogger(t))
+	s, err := b.newSandbox(ctx, "app/1")
+	if err != nil {
+		t.Fatalf("newSandbox() error = %v", err)
+	}
+	defer s.Close(ctx)
+
+	// The build user gets a copy of the source to build in
+	sourceDir := t.TempDir()
+	writeFiles(t, sourceDir, map[string]string{"main.go": "package main\n"})
+	buildDir, err := s.prepare(sourceDir, t.TempDir())
+	if err != nil {
+		t.Fatalf("prepare() error = %v", err)
+	}
+	if config.User != "" {
+		info, err := os.Stat(filepath.Join(buildDir, "main.go"))
+		if err != nil {
+			t.Fatalf("prepare() did not copy the source: %v", err)
+		}
+		if uid := info.Sys().(*syscall.Stat_t).Uid; int(uid) != wantUID {
+			t.Errorf("source copy is owned by %d, want %d", uid, wantUID)
+		}
+	}
+
+	cmd := commandContext(withSandbox(ctx, s), "sh", "-c", "id -u; tail -n +3 /proc/net/dev")
+	output, err := cmd.Output()
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL) {
+		t.Skipf("namespaces not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("sandboxed command error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if lines[0] != strconv.Itoa(wantUID) {
+		t.Errorf("sandboxed command ran as %s, want %d", lines[0], wantUID)
+	}
+	for _, line := range lines[1:] {
+		if name, _, _ := strings.Cut(strings.TrimSpace(line), ":"); name != "lo" {
+			t.Errorf("offline command sees network interface %s", name)
+		}
+	}
+
+	s.Close(ctx)
+	if buildDir != sourceDir {
+		if _, err := os.Stat(buildDir); !os.IsNotExist(err) {
+			t.Errorf("Close() left the build directory behind")
+		}
+	}
+}
+
+func TestSandboxLimits(t *testing.T) {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil || os.Geteuid() != 0 {
+		t.Skip("cgroup v2 not available")
+	}
+	ctx := context.Background()
+
+	cgroupDir := filepath.Join("/sys/fs/cgroup", "skyline-test-"+strconv.Itoa(os.Getpid()))
+	defer os.Remove(cgroupDir)
+
+	b := NewBuilder(BuildConfig{OutputDir: filepath.Join(t.TempDir(), "builds"), Sandbox: SandboxConfig{
+		HomeDir:      t.TempDir(),
+		CgroupDir:    cgroupDir,
+		MaxProcesses: 4,
+	}}, newMockLogger(t))
+	s, err := b.newSandbox(ctx, "app/1")
+	if err != nil {
+		t.Skipf("cgroup limits not available: %v", err)
+	}
+	defer s.Close(ctx)
+
+	output, err := commandContext(withSandbox(ctx, s), "cat", "/proc/self/cgroup").Output()
+	if err != nil {
+		t.Fatalf("sandboxed command error = %v", err)
+	}
+	if !strings.Contains(string(output), "skyline-test-") {
+		t.Errorf("sandboxed command runs in cgroup %q, want the build cgroup", output)
+	}
+}
+
+func TestSandboxRlimits(t *testing.T) {
+	if _, err := exec.LookPath("prlimit"); err != nil {
+		t.Skip("prlimit not available")
+	}
+	ctx := context.Background()
+
+	// A cgroup directory outside of cgroup v2 falls back to rlimits
+	b := NewBuilder(BuildConfig{OutputDir: filepath.Join(t.TempDir(), "builds"), BuildTimeout: time.Minute,
+		Sandbox: SandboxConfig{AllowRoot: true, HomeDir: t.TempDir(), CgroupDir: t.TempDir(), MemoryMB: 1024, MaxProcesses: 64}},
+		newMockLogger(t))
+	s, err := b.newSandbox(ctx, "app/1")
+	if err != nil {
+		t.Fatalf("newSandbox() error = %v", err)
+	}
+	defer s.Close(ctx)
+
+	output, err := commandContext(withSandbox(ctx, s), "cat", "/proc/self/limits").Output()
+	if err != nil {
+		t.Fatalf("sandboxed command error = %v", err)
+	}
+	want := map[string]string{
+		"Max address space": strconv.Itoa(1024 * 1024 * 1024),
+		"Max processes":     "64",
+		"Max cpu time":      strconv.Itoa(defaultBuildCPUs * 60),
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		for name, limit := range want {
+			if strings.HasPrefix(line, name) {
+				if fields := strings.Fields(strings.TrimPrefix(line, name)); len(fields) == 0 || fields[0] != limit {
+					t.Errorf("sandboxed command has %s", line)
+				}
+				delete(want, name)
+			}
+		}
+	}
+	if len(want) != 0 {
+		t.Errorf("sandboxed command has no limits %v:\n%s", want, output)
+	}
+}
+
+func TestSandboxRequiresBuildUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("builds only need a build user as root")
+	}
+
+	b := NewBuilder(BuildConfig{OutputDir: filepath.Join(t.TempDir(), "builds")}, newMockLogger(t))
+	if _, err := b.newSandbox(context.Background(), "app/1"); err == nil {
+		t.Error("newSandbox() as root without a build user succeeded")
+	}
+}
+
+func TestSandboxWarnings(t *testing.T) {
+	b := NewBuilder(BuildConfig{OutputDir: filepath.Join(t.TempDir(), "builds"), Sandbox: testSandbox}, newMockLogger(t))
+	if warnings := b.SandboxWarnings(); len(warnings) != 1 {
+		t.Errorf("SandboxWarnings() without a build user = %q, want one", warnings)
+	}
+
+	b = NewBuilder(BuildConfig{OutputDir: filepath.Join(t.TempDir(), "builds"), Sandbox: SandboxConfig{
+		User: "skyline-build",
+	}}, newMockLogger(t))
+	if warnings := b.SandboxWarnings(); len(warnings) != 0 {
+		t.Errorf("SandboxWarnings() with a build user = %q, want none", warnings)
+	}
+}
@@ -2,7 +2,9 @@ package deploy
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	"github.com/danbruder/skyline/pkg/errors"
+	"github.com/google/uuid"
 )
 
 // SourceFetcher defines the interface for fetching source code
@@ -19,6 +22,78 @@ type SourceFetcher interface {
 	CleanupSource(ctx context.Context, path string) error
 }
 
+// Git providers apps are fetched from and webhooks are received of
+const (
+	ProviderGitHub    = "github"
+	ProviderGitLab    = "gitlab"
+	ProviderGitea     = "gitea"
+	ProviderBitbucket = "bitbucket"
+)
+
+// providerDefaults are the hosted service of each provider and the user name
+// its access tokens are sent with. Gitea has no hosted service to default to,
+// and accepts tokens as the user name.
+var providerDefaults = map[string]struct{ host, username string }{
+	ProviderGitHub:    {"github.com", ""},
+	ProviderGitLab:    {"gitlab.com", "oauth2"},
+	ProviderGitea:     {"", ""},
+	ProviderBitbucket: {"bitbucket.org", "x-token-auth"},
+}
+
+// GitCredential authenticates fetches from the repositories of a git host
+// over HTTPS
+type GitCredential struct {
+	Provider string // github, gitlab, gitea or bitbucket
+	URL      string // Base URL of a self-hosted instance; defaults to the hosted service
+	Username string // User name the token is sent with; defaults to the provider's
+	Token    string
+}
+
+// host returns the host name the credential is for
+func (c GitCredential) host() string {
+	if c.URL == "" {
+		return providerDefaults[c.Provider].host
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// basicAuth returns the user name and password git sends the token as.
+// Without a user name the token is sent as the user name, which GitHub and
+// Gitea accept.
+func (c GitCredential) basicAuth() (string, string) {
+	username := c.Username
+	if username == "" {
+		username = providerDefaults[c.Provider].username
+	}
+	if username == "" {
+		return c.Token, "x-oauth-basic"
+	}
+	return username, c.Token
+}
+
+// credentialHelper answers git's requests for credentials from the
+// environment of the git command, so tokens never appear in remote URLs,
+// .git/config or process arguments
+const credentialHelper = `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$SKYLINE_GIT_USERNAME" "$SKYLINE_GIT_PASSWORD"; }; f`
+
+type gitCredentialKey struct{}
+
+// withGitCredential returns a context whose git commands authenticate with
+// a credential
+func withGitCredential(ctx context.Context, credential *GitCredential) context.Context {
+	return context.WithValue(ctx, gitCredentialKey{}, credential)
+}
+
+// gitCredentialFromContext returns the credential carried by ctx, or nil
+func gitCredentialFromContext(ctx context.Context) *GitCredential {
+	credential, _ := ctx.Value(gitCredentialKey{}).(*GitCredential)
+	return credential
+}
+
 // SourceFetchConfig contains configuration for the source fetcher
 type SourceFetchConfig struct {
 	GitBinary      string
@@ -26,13 +101,16 @@ type SourceFetchConfig struct {
 	FetchTimeout   time.Duration
 	CleanupOnError bool
 	GitHubToken    string
+	Credentials    []GitCredential // Tokens of further git hosts
 }
 
 // GitHubFetcher implements SourceFetcher for GitHub repositories
 type GitHubFetcher struct {
-	config SourceFetchConfig
-	logger errors.Logger
-	mu     sync.Mutex
+	config   SourceFetchConfig
+	logger   errors.Logger
+	redactor *Redactor
+	locks    map[string]*sync.Mutex // Locks of the mirrors, by repository
+	mu       sync.Mutex
 }
 
 // NewGitHubFetcher creates a new GitHubFetcher
@@ -47,14 +125,43 @@ func NewGitHubFetcher(config SourceFetchConfig, logger errors.Logger) *GitHubFet
 	if config.FetchTimeout == 0 {
 		config.FetchTimeout = 5 * time.Minute
 	}
+	// Worktrees are created from within their mirror
+	if abs, err := filepath.Abs(config.SourceDir); err == nil {
+		config.SourceDir = abs
+	}
+	if config.GitHubToken != "" {
+		config.Credentials = append(config.Credentials, GitCredential{Provider: ProviderGitHub, Token: config.GitHubToken})
+	}
+
+	var tokens []string
+	for _, credential := range config.Credentials {
+		tokens = append(tokens, credential.Token)
+	}
 
 	return &GitHubFetcher{
-		config: config,
-		logger: logger,
+		config:   config,
+		logger:   logger,
+		redactor: NewRedactor(tokens...),
+		locks:    make(map[string]*sync.Mutex),
 	}
 }
 
-// FetchSource fetches source code from GitHub
+// Sources are kept below SourceDir as one bare mirror per repository, which
+// every fetch updates, and a worktree per fetch for the build to read:
+//
+//	mirrors/<repo>.git     objects and branches fetched so far
+//	worktrees/<repo>/<id>  the checkout of a single deployment
+//
+// Git operations on a mirror hold its lock, so fetches of different
+// repositories, and builds in worktrees, run in parallel.
+const (
+	mirrorsDir   = "mirrors"
+	worktreesDir = "worktrees"
+)
+
+// FetchSource fetches a commit, or the tip of a branch, into the mirror of a
+// repository without its history, and checks it out in a new worktree.
+// Callers remove the worktree with CleanupSource when they are done with it.
 func (g *GitHubFetcher) FetchSource(ctx context.Context, repoURL, branch, commit string) (string, error) {
 	fields := errors.FieldMap{
 		"repo_url": repoURL,
@@ -69,23 +176,22 @@ func (g *GitHubFetcher) FetchSource(ctx context.Context, repoURL, branch, commit
 	}
 	fields["repo_name"] = repoName
 
-	// Create source directory
-	sourceDir := filepath.Join(g.config.SourceDir, repoName)
-	if err := os.MkdirAll(sourceDir, 0755); err != nil {
-		wrappedErr := errors.Wrap(err, "failed to create source directory")
-		g.logger.Error(ctx, wrappedErr, "Source directory creation failed", fields)
-		return "", wrappedErr
+	// Commits are fetched by their full hash; abbreviated ones cannot be
+	// asked of a server
+	if commit != "" && commit != "HEAD" && !isCommitSHA(commit) {
+		return "", fmt.Errorf("commit %q is not a full 40-character commit SHA", commit)
 	}
 
-	// Use mutex to prevent concurrent git operations on the same repo
-	g.mu.Lock()
-	defer g.mu.Unlock()
+	// Repositories of different hosts may share a name
+	sum := sha256.Sum256([]byte(normalizeRepoURL(repoURL)))
+	key := fmt.Sprintf("%s-%x", repoName, sum[:4])
+	mirrorDir := filepath.Join(g.config.SourceDir, mirrorsDir, key+".git")
+	worktreeDir := filepath.Join(g.config.SourceDir, worktreesDir, key, uuid.New().String())
+	fields["mirror_dir"] = mirrorDir
 
-	// Check if repo is already cloned
-	isCloned, err := g.isRepoCloned(sourceDir)
-	if err != nil {
-		wrappedErr := errors.Wrap(err, "failed to check if repo is cloned")
-		g.logger.Error(ctx, wrappedErr, "Repo check failed", fields)
+	if err := os.MkdirAll(filepath.Dir(worktreeDir), 0755); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to create source directory")
+		g.logger.Error(ctx, wrappedErr, "Source directory creation failed", fields)
 		return "", wrappedErr
 	}
 
@@ -93,50 +199,52 @@ func (g *GitHubFetcher) FetchSource(ctx context.Context, repoURL, branch, commit
 	timeoutCtx, cancel := context.WithTimeout(ctx, g.config.FetchTimeout)
 	defer cancel()
 
-	// Clone or update repository
-	if isCloned {
-		g.logger.Info(ctx, "Updating existing repository", fields)
-		if err := g.updateRepo(timeoutCtx, sourceDir, branch); err != nil {
-			wrappedErr := errors.Wrap(err, "failed to update repository")
-			g.logger.Error(ctx, wrappedErr, "Repository update failed", fields)
-
-			if g.config.CleanupOnError {
-				if cleanErr := g.CleanupSource(ctx, sourceDir); cleanErr != nil {
-					g.logger.Error(ctx, cleanErr, "Cleanup after failed update failed", fields)
-				}
-			}
-			return "", wrappedErr
-		}
-	} else {
-		g.logger.Info(ctx, "Cloning new repository", fields)
-		if err := g.cloneRepo(timeoutCtx, repoURL, sourceDir, branch); err != nil {
-			wrappedErr := errors.Wrap(err, "failed to clone repository")
-			g.logger.Error(ctx, wrappedErr, "Repository clone failed", fields)
-
-			if g.config.CleanupOnError {
-				if cleanErr := g.CleanupSource(ctx, sourceDir); cleanErr != nil {
-					g.logger.Error(ctx, cleanErr, "Cleanup after failed clone failed", fields)
-				}
+	// Authenticate if the host of the repository has a token
+	if credential := g.credentialFor(repoURL); credential != nil {
+		timeoutCtx = withGitCredential(timeoutCtx, credential)
+	}
+
+	// Prevent concurrent git operations on the same mirror
+	lock := g.repoLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	g.removeLegacyCheckout(ctx, repoName)
+
+	if err := g.updateMirror(timeoutCtx, repoURL, mirrorDir, branch, commit); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to update repository mirror")
+		g.logger.Error(ctx, wrappedErr, "Repository fetch failed", fields)
+
+		if g.config.CleanupOnError {
+			if cleanErr := g.CleanupSource(ctx, mirrorDir); cleanErr != nil {
+				g.logger.Error(ctx, cleanErr, "Cleanup after failed fetch failed", fields)
 			}
-			return "", wrappedErr
 		}
+		return "", wrappedErr
 	}
 
-	// Checkout specific commit if provided
+	// Check out the requested commit, or the branch just fetched
+	target := mirrorRef(branch)
 	if commit != "" && commit != "HEAD" {
-		g.logger.Info(ctx, "Checking out specific commit", fields)
-		if err := g.checkoutCommit(timeoutCtx, sourceDir, commit); err != nil {
-			wrappedErr := errors.Wrap(err, "failed to checkout commit")
-			g.logger.Error(ctx, wrappedErr, "Commit checkout failed", fields)
-			return "", wrappedErr
+		deployLogFromContext(ctx).Printf("Checking out commit %s", commit)
+		target = commit
+	}
+	if err := g.addWorktree(timeoutCtx, mirrorDir, worktreeDir, target); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to check out source")
+		g.logger.Error(ctx, wrappedErr, "Worktree creation failed", fields)
+
+		if cleanErr := g.CleanupSource(ctx, worktreeDir); cleanErr != nil {
+			g.logger.Error(ctx, cleanErr, "Cleanup after failed checkout failed", fields)
 		}
+		return "", wrappedErr
 	}
 
-	g.logger.Info(ctx, "Source code fetched successfully", fields)
-	return sourceDir, nil
+	g.logger.Info(ctx, "Source code fetched successfully", errors.WithField(fields, "worktree_dir", worktreeDir))
+	return worktreeDir, nil
 }
 
-// CleanupSource removes the source directory
+// CleanupSource removes the source directory. The mirror forgets removed
+// worktrees on its next fetch.
 func (g *GitHubFetcher) CleanupSource(ctx context.Context, path string) error {
 	fields := errors.FieldMap{"path": path}
 
@@ -150,103 +258,156 @@ func (g *GitHubFetcher) CleanupSource(ctx context.Context, path string) error {
 	return nil
 }
 
-// isRepoCloned checks if the repository is already cloned
-func (g *GitHubFetcher) isRepoCloned(dir string) (bool, error) {
-	gitDir := filepath.Join(dir, ".git")
-	_, err := os.Stat(gitDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
-		}
-		return false, err
+// repoLock returns the lock of the mirror of a repository
+func (g *GitHubFetcher) repoLock(key string) *sync.Mutex {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	lock, ok := g.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		g.locks[key] = lock
 	}
-	return true, nil
+	return lock
 }
 
-// cloneRepo clones a repository
-func (g *GitHubFetcher) cloneRepo(ctx context.Context, repoURL, dir, branch string) error {
-	// Prepare command arguments
-	args := []string{"clone"}
-
-	// Add authentication if token is provided
-	cloneURL := repoURL
-	if g.config.GitHubToken != "" {
-		// Insert token into URL
-		if strings.HasPrefix(repoURL, "https://github.com/") {
-			cloneURL = strings.Replace(repoURL, "https://", fmt.Sprintf("https://%s@", g.config.GitHubToken), 1)
+// removeLegacyCheckout removes the checkout earlier versions kept of a
+// repository in SourceDir, which may carry a token in its remote URL and
+// is no longer used
+func (g *GitHubFetcher) removeLegacyCheckout(ctx context.Context, repoName string) {
+	dir := filepath.Join(g.config.SourceDir, repoName)
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return
+	}
+
+	g.logger.Info(ctx, "Removing checkout replaced by repository mirror", errors.FieldMap{"path": dir})
+	g.CleanupSource(ctx, dir)
+}
+
+// updateMirror creates the bare mirror of a repository if it does not exist
+// yet and fetches a commit, or the tip of a branch, into it. Only the commit
+// itself is fetched, so branches that were force-pushed fetch like any other.
+func (g *GitHubFetcher) updateMirror(ctx context.Context, repoURL, dir, branch, commit string) error {
+	deployLog := deployLogFromContext(ctx)
+
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+		deployLog.Printf("Creating mirror of %s", repoURL)
+		cmd := g.gitCommand(ctx, "init", "--bare", dir)
+		if output, err := runCommand(ctx, cmd); err != nil {
+			return fmt.Errorf("git init failed: %w\nOutput: %s", err, g.redactor.Redact(string(output)))
 		}
+	} else {
+		deployLog.Printf("Updating mirror of %s", repoURL)
 	}
 
-	if branch != "" && branch != "main" && branch != "master" {
-		args = append(args, "-b", branch)
+	// Forget the worktrees CleanupSource removed
+	cmd := g.gitCommand(ctx, "worktree", "prune")
+	cmd.Dir = dir
+	if output, err := runCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("git worktree prune failed: %w\nOutput: %s", err, g.redactor.Redact(string(output)))
 	}
 
-	args = append(args, cloneURL, dir)
+	if isCommitSHA(commit) {
+		cmd = g.gitCommand(ctx, "fetch", "--depth", "1", "--no-tags", repoURL, commit)
+		cmd.Dir = dir
+		if _, err := runCommand(ctx, cmd); err == nil {
+			return nil
+		}
 
-	// Run git clone
-	cmd := exec.CommandContext(ctx, g.config.GitBinary, args...)
-	output, err := cmd.CombinedOutput()
+		// Servers may refuse to serve commits that are not named by a ref
+		deployLog.Printf("Fetching commit %s failed, fetching the tip of branch %s instead", commit, branch)
+	}
 
-	if err != nil {
-		// Mask token from error message for security
-		sanitizedOutput := string(output)
-		if g.config.GitHubToken != "" {
-			sanitizedOutput = strings.Replace(sanitizedOutput, g.config.GitHubToken, "***", -1)
+	ref := "HEAD"
+	if branch != "" {
+		ref = "refs/heads/" + branch
+	}
+	cmd = g.gitCommand(ctx, "fetch", "--depth", "1", "--no-tags", repoURL, "+"+ref+":"+mirrorRef(branch))
+	cmd.Dir = dir
+	if output, err := runCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("git fetch failed: %w\nOutput: %s", err, g.redactor.Redact(string(output)))
+	}
+
+	// The tip only stands in for the commit if it is that commit, or one
+	// fetched before
+	if isCommitSHA(commit) {
+		cmd = g.gitCommand(ctx, "cat-file", "-e", commit+"^{commit}")
+		cmd.Dir = dir
+		if _, err := runCommand(ctx, cmd); err != nil {
+			return fmt.Errorf("commit %s is not reachable from branch %s", commit, strings.TrimPrefix(ref, "refs/heads/"))
 		}
-		return fmt.Errorf("git clone failed: %w\nOutput: %s", err, sanitizedOutput)
 	}
 
 	return nil
 }
 
-// updateRepo updates a repository
-func (g *GitHubFetcher) updateRepo(ctx context.Context, dir, branch string) error {
-	// Make sure we're on the right branch
-	if branch != "" {
-		cmd := exec.CommandContext(ctx, g.config.GitBinary, "checkout", branch)
-		cmd.Dir = dir
-		if _, err := cmd.CombinedOutput(); err != nil {
-			// Try to fetch and checkout
-			fetchCmd := exec.CommandContext(ctx, g.config.GitBinary, "fetch", "origin")
-			fetchCmd.Dir = dir
-			if _, fetchErr := fetchCmd.CombinedOutput(); fetchErr != nil {
-				return fmt.Errorf("git fetch failed: %w", fetchErr)
-			}
+// addWorktree checks out a commit of a mirror in a new worktree. The
+// worktree is detached, so fetches can move the branch it came from.
+func (g *GitHubFetcher) addWorktree(ctx context.Context, mirrorDir, dir, commit string) error {
+	cmd := g.gitCommand(ctx, "worktree", "add", "--detach", dir, commit)
+	cmd.Dir = mirrorDir
+	output, err := runCommand(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("git worktree add failed: %w\nOutput: %s", err, g.redactor.Redact(string(output)))
+	}
 
-			// Try checkout again after fetch
-			checkoutCmd := exec.CommandContext(ctx, g.config.GitBinary, "checkout", branch)
-			checkoutCmd.Dir = dir
-			if checkoutOutput, checkoutErr := checkoutCmd.CombinedOutput(); checkoutErr != nil {
-				return fmt.Errorf("git checkout failed: %w\nOutput: %s", checkoutErr, checkoutOutput)
-			}
-		}
+	return nil
+}
+
+// gitCommand creates a git command that never prompts for credentials.
+// Repositories reached over SSH are fetched with the deploy key command
+// carried by ctx, and HTTPS repositories with the credential carried by ctx.
+func (g *GitHubFetcher) gitCommand(ctx context.Context, args ...string) *exec.Cmd {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if command := gitSSHCommandFromContext(ctx); command != "" {
+		env = append(env, "GIT_SSH_COMMAND="+command)
 	}
+	if credential := gitCredentialFromContext(ctx); credential != nil {
+		username, password := credential.basicAuth()
+		env = append(env, "SKYLINE_GIT_USERNAME="+username, "SKYLINE_GIT_PASSWORD="+password)
 
-	// Pull latest changes
-	cmd := exec.CommandContext(ctx, g.config.GitBinary, "pull", "origin", branch)
-	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("git pull failed: %w\nOutput: %s", err, output)
+		// The empty helper drops the helpers of the user's git config, so
+		// they neither answer for nor store the token
+		args = append([]string{"-c", "credential.helper=", "-c", "credential.helper=" + credentialHelper}, args...)
 	}
 
-	return nil
+	cmd := commandContext(ctx, g.config.GitBinary, args...)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
 }
 
-// checkoutCommit checks out a specific commit
-func (g *GitHubFetcher) checkoutCommit(ctx context.Context, dir, commit string) error {
-	cmd := exec.CommandContext(ctx, g.config.GitBinary, "checkout", commit)
-	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("git checkout commit failed: %w\nOutput: %s", err, output)
+// credentialFor returns the credential of the host of an HTTPS repository,
+// or nil if it has none. URLs that carry their own user are left alone.
+func (g *GitHubFetcher) credentialFor(repoURL string) *GitCredential {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Scheme != "https" || u.User != nil {
+		return nil
+	}
+
+	for i, credential := range g.config.Credentials {
+		if credential.Token != "" && strings.EqualFold(credential.host(), u.Host) {
+			return &g.config.Credentials[i]
+		}
 	}
 
 	return nil
 }
 
+// mirrorRef returns the ref of the mirror a branch is fetched into
+func mirrorRef(branch string) string {
+	if branch == "" {
+		return "refs/remotes/origin/HEAD"
+	}
+	return "refs/remotes/origin/" + branch
+}
+
 // parseRepoName extracts the repository name from the URL
 func parseRepoName(repoURL string) (string, error) {
+	// git@github.com:username/repo.git is named like its https:// URL
+	if isSSHURL(repoURL) && !strings.Contains(repoURL, "://") {
+		repoURL = strings.Replace(repoURL, ":", "/", 1)
+	}
+
 	// Handle GitHub URLs
 	// https://github.com/username/repo.git -> username-repo
 	// https://github.com/username/repo -> username-repo
@@ -3,7 +3,10 @@ package deploy
 import (
 	"context"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/danbruder/skyline/pkg/errors"
@@ -140,3 +143,200 @@ func TestGitHubFetcher_FetchSource(t *testing.T) {
 		t.Errorf("Source directory was not removed")
 	}
 }
+
+func TestCredentialFor(t *testing.T) {
+	fetcher := NewGitHubFetcher(SourceFetchConfig{
+		GitHubToken: "gh-token",
+		Credentials: []GitCredential{
+			{Provider: ProviderGitLab, Token: "gl-token"},
+			{Provider: ProviderGitea, URL: "https://git.example.com", Token: "gitea-token"},
+			{Provider: ProviderBitbucket, Username: "alice", Token: "app-password"},
+			{Provider: ProviderGitLab, URL: "https://gitlab.example.com"},
+		},
+	}, newMockLogger(t))
+
+	tests := []struct {
+		repoURL      string
+		wantUsername string
+		wantPassword string
+	}{
+		{"https://github.com/acme/app", "gh-token", "x-oauth-basic"},
+		{"https://gitlab.com/acme/app.git", "oauth2", "gl-token"},
+		{"https://git.example.com/acme/app", "gitea-token", "x-oauth-basic"},
+		{"https://bitbucket.org/acme/app", "alice", "app-password"},
+		{"https://gitlab.example.com/acme/app", "", ""},
+		{"https://user@gitlab.com/acme/app", "", ""},
+		{"git@gitlab.com:acme/app.git", "", ""},
+	}
+
+	for _, tt := range tests {
+		credential := fetcher.credentialFor(tt.repoURL)
+		if credential == nil {
+			if tt.wantUsername != "" {
+				t.Errorf("credentialFor(%q) = nil, want %s", tt.repoURL, tt.wantUsername)
+			}
+			continue
+		}
+		if username, password := credential.basicAuth(); username != tt.wantUsername || password != tt.wantPassword {
+			t.Errorf("credentialFor(%q) = %s:%s, want %s:%s", tt.repoURL, username, password, tt.wantUsername, tt.wantPassword)
+		}
+	}
+}
+
+func TestGitCommandCredentials(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	fetcher := NewGitHubFetcher(SourceFetchConfig{
+		Credentials: []GitCredential{{Provider: ProviderGitLab, Token: "gl-token"}},
+	}, newMockLogger(t))
+	ctx := withGitCredential(context.Background(), fetcher.credentialFor("https://gitlab.com/acme/app"))
+
+	// git asks the credential helper, which answers from the environment
+	cmd := fetcher.gitCommand(ctx, "credential", "fill")
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=gitlab.com\n\n")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git credential fill error = %v", err)
+	}
+	if !strings.Contains(string(output), "username=oauth2\npassword=gl-token\n") {
+		t.Errorf("git credential fill = %q, want the GitLab token", output)
+	}
+
+	// The token is neither an argument of git nor stored anywhere
+	if strings.Contains(strings.Join(cmd.Args, " "), "gl-token") {
+		t.Errorf("git arguments %q contain the token", cmd.Args)
+	}
+}
+
+func TestGitHubFetcher_Worktrees(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	// A local repository with a branch per app
+	repoDir := filepath.Join(t.TempDir(), "repo")
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repoDir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v error = %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	commit := func(branch, content string) string {
+		t.Helper()
+		git("checkout", "-q", "-B", branch)
+		writeFiles(t, repoDir, map[string]string{"branch.txt": content})
+		git("add", ".")
+		git("commit", "-q", "-m", content)
+		return git("rev-parse", "HEAD")
+	}
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git("init", "-q")
+	first := commit("main", "main 1")
+	commit("staging", "staging 1")
+
+	sourceDir := t.TempDir()
+	fetcher := NewGitHubFetcher(SourceFetchConfig{SourceDir: sourceDir}, newMockLogger(t))
+	ctx := context.Background()
+
+	// Deployments of different branches of the same repository run at once
+	branches := []string{"main", "staging", "main"}
+	dirs := make([]string, len(branches))
+	errs := make([]error, len(branches))
+	var wg sync.WaitGroup
+	for i, branch := range branches {
+		wg.Add(1)
+		go func(i int, branch string) {
+			defer wg.Done()
+			dirs[i], errs[i] = fetcher.FetchSource(ctx, repoDir, branch, "")
+		}(i, branch)
+	}
+	wg.Wait()
+
+	for i, branch := range branches {
+		if errs[i] != nil {
+			t.Fatalf("FetchSource(%s) error = %v", branch, errs[i])
+		}
+		content, err := os.ReadFile(filepath.Join(dirs[i], "branch.txt"))
+		if err != nil || string(content) != branch+" 1" {
+			t.Errorf("worktree of %s holds %q, %v", branch, content, err)
+		}
+	}
+	if dirs[0] == dirs[2] {
+		t.Errorf("deployments of main share worktree %s", dirs[0])
+	}
+	mirrors, _ := filepath.Glob(filepath.Join(sourceDir, mirrorsDir, "*"))
+	if len(mirrors) != 1 {
+		t.Errorf("mirrors = %v, want one for the repository", mirrors)
+	}
+
+	// Removed worktrees are pruned, and earlier commits can be checked out
+	// after the branch moved on
+	for _, dir := range dirs {
+		if err := fetcher.CleanupSource(ctx, dir); err != nil {
+			t.Fatalf("CleanupSource() error = %v", err)
+		}
+	}
+	commit("main", "main 2")
+	dir, err := fetcher.FetchSource(ctx, repoDir, "main", first)
+	if err != nil {
+		t.Fatalf("FetchSource(%s) error = %v", first, err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "branch.txt")); string(content) != "main 1" {
+		t.Errorf("worktree of %s holds %q, want main 1", first, content)
+	}
+
+	cmd := exec.Command("git", "worktree", "list", "--porcelain")
+	cmd.Dir = mirrors[0]
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git worktree list error = %v", err)
+	}
+	if got := strings.Count(string(output), "worktree "); got != 2 {
+		t.Errorf("mirror has %d worktrees, want itself and %s:\n%s", got, dir, output)
+	}
+
+	// Only the deployed commits are fetched, without their history
+	cmd = exec.Command("git", "rev-parse", "--is-shallow-repository")
+	cmd.Dir = mirrors[0]
+	if output, err := cmd.Output(); err != nil || strings.TrimSpace(string(output)) != "true" {
+		t.Errorf("mirror is shallow = %q, %v, want true", output, err)
+	}
+
+	// A force-pushed branch fetches its new tip
+	git("reset", "-q", "--hard", first)
+	forced := commit("main", "main 3")
+	dir, err = fetcher.FetchSource(ctx, repoDir, "main", "")
+	if err != nil {
+		t.Fatalf("FetchSource() after force push error = %v", err)
+	}
+	if resolved, err := sourceCommit(ctx, dir); err != nil || resolved != forced {
+		t.Errorf("worktree after force push is at %s, %v, want %s", resolved, err, forced)
+	}
+
+	// Commits are fetched by their hash, even when no branch points at them
+	fetcher = NewGitHubFetcher(SourceFetchConfig{SourceDir: t.TempDir()}, newMockLogger(t))
+	dir, err = fetcher.FetchSource(ctx, repoDir, "main", first)
+	if err != nil {
+		t.Fatalf("FetchSource(%s) into a new mirror error = %v", first, err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "branch.txt")); string(content) != "main 1" {
+		t.Errorf("worktree of %s holds %q, want main 1", first, content)
+	}
+
+	// Abbreviated and unknown commits fail with an error that names them
+	if _, err := fetcher.FetchSource(ctx, repoDir, "main", first[:7]); err == nil || !strings.Contains(err.Error(), "not a full") {
+		t.Errorf("FetchSource(%s) error = %v, want a full SHA error", first[:7], err)
+	}
+	missing := strings.Repeat("0", 40)
+	if _, err := fetcher.FetchSource(ctx, repoDir, "main", missing); err == nil || !strings.Contains(err.Error(), "not reachable from branch main") {
+		t.Errorf("FetchSource(%s) error = %v, want an unreachable commit error", missing, err)
+	}
+}
@@ -0,0 +1,114 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/danbruder/skyline/internal/db"
+	"github.com/danbruder/skyline/pkg/errors"
+)
+
+// Static sites are published as they are. The proxy serves the site directory
+// of the current release, so there is no process to supervise.
+const staticSiteDir = "site"
+
+// staticBuilder builds static sites
+type staticBuilder struct {
+	b *Builder
+}
+
+func (s *staticBuilder) Name() string {
+	return "static"
+}
+
+func (s *staticBuilder) Detect(ctx context.Context, sourceDir string) bool {
+	return findSiteDir(sourceDir) != ""
+}
+
+func (s *staticBuilder) Build(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
+	return s.b.buildStaticSite(ctx, sourceDir, outputDir, manifest, settings)
+}
+
+// buildStaticSite runs the build command of a static site, if it has one, and
+// copies the site to the output directory
+func (b *Builder) buildStaticSite(ctx context.Context, sourceDir, outputDir string, manifest *Manifest, settings db.BuildSettings) (BuildResult, error) {
+	fields := errors.FieldMap{
+		"source_dir": sourceDir,
+		"output_dir": outputDir,
+		"type":       "static",
+	}
+
+	b.logger.Info(ctx, "Building static site", fields)
+
+	// A target names the project directory, e.g. in a monorepo
+	appRoot := sourceDir
+	if manifest.Build.Target != "" {
+		appRoot = filepath.Join(sourceDir, manifest.Build.Target)
+	}
+
+	if manifest.Build.Command != "" {
+		deployLogFromContext(ctx).Printf("$ %s", manifest.Build.Command)
+		cmd := commandContext(ctx, "sh", "-c", manifest.Build.Command)
+		cmd.Dir = appRoot
+		cmd.Env = b.buildEnv(settings)
+		output, err := runCommand(ctx, cmd)
+		if err != nil {
+			wrappedErr := errors.Wrap(err, fmt.Sprintf("build command failed: %s", output))
+			b.logger.Error(ctx, wrappedErr, "Static site build failed", fields)
+			return BuildResult{}, wrappedErr
+		}
+	}
+
+	// Find the directory to publish
+	siteDir := findSiteDir(appRoot)
+	if manifest.Static != "" {
+		siteDir = filepath.Join(sourceDir, manifest.Static)
+		if info, err := os.Stat(siteDir); err != nil || !info.IsDir() {
+			err := fmt.Errorf("static directory %q not found after the build", manifest.Static)
+			b.logger.Error(ctx, err, "Static site not found", fields)
+			return BuildResult{}, err
+		}
+	}
+	if siteDir == "" {
+		err := errors.New("no index.html found to publish")
+		b.logger.Error(ctx, err, "Static site not found", fields)
+		return BuildResult{}, err
+	}
+	fields["site_dir"] = siteDir
+
+	outputSiteDir := filepath.Join(outputDir, staticSiteDir)
+	if err := copyPublishedDir(siteDir, outputSiteDir, sourceDir, ".git", ManifestFile); err != nil {
+		wrappedErr := errors.Wrap(err, "failed to copy static site")
+		b.logger.Error(ctx, wrappedErr, "Static site copy failed", fields)
+		return BuildResult{}, wrappedErr
+	}
+
+	result := BuildResult{
+		Type:        "static",
+		SiteDir:     outputSiteDir,
+		Environment: make(map[string]string),
+		Manifest:    manifest,
+	}
+
+	b.logger.Info(ctx, "Static site built successfully", fields)
+	return result, nil
+}
+
+// findSiteDir returns the directory of a static site: the source tree itself
+// if it has an index.html, or the first static assets directory with one
+func findSiteDir(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
+		return dir
+	}
+
+	for _, name := range []string{"dist", "public", "www", "static", "build"} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(filepath.Join(path, "index.html")); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,166 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danbruder/skyline/internal/db"
+)
+
+func TestFindSiteDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"Root", map[string]string{"index.html": "", "dist/index.html": ""}, "."},
+		{"Dist", map[string]string{"README.md": "", "dist/index.html": ""}, "dist"},
+		{"Assets without a page", map[string]string{"public/logo.png": ""}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			want := ""
+			if tt.want != "" {
+				want = filepath.Join(dir, tt.want)
+			}
+			if got := findSiteDir(dir); got != want {
+				t.Errorf("findSiteDir() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBuildStaticSite(t *testing.T) {
+	ctx := context.Background()
+	b := NewBuilder(BuildConfig{OutputDir: t.TempDir(), Sandbox: testSandbox}, newMockLogger(t))
+
+	// Plain sites are detected and published as they are
+	sourceDir := t.TempDir()
+	writeFiles(t, sourceDir, map[string]string{
+		"index.html":  "<h1>Hello</h1>",
+		"css/app.css": "",
+		ManifestFile:  "spa: false\n",
+		".git/HEAD":   "ref: refs/heads/main",
+	})
+
+	result, err := b.DetectAndBuild(ctx, sourceDir, "site/1", nil, db.BuildSettings{})
+	if err != nil {
+		t.Fatalf("DetectAndBuild() error = %v", err)
+	}
+	if result.Type != "static" || result.BinaryPath != "" || result.AppDir != "" {
+		t.Errorf("DetectAndBuild() = %+v, want a static site", result)
+	}
+	for name, want := range map[string]bool{"index.html": true, "css/app.css": true, ManifestFile: false, ".git": false} {
+		if _, err := os.Stat(filepath.Join(result.SiteDir, name)); (err == nil) != want {
+			t.Errorf("%s published = %v, want %v", name, err == nil, want)
+		}
+	}
+
+	// Declared sites run their build command first
+	sourceDir = t.TempDir()
+	manifest := &Manifest{
+		Build:  ManifestBuild{Type: "static", Command: "mkdir -p out && echo built > out/index.html"},
+		Static: "out",
+		SPA:    true,
+	}
+	result, err = b.DetectAndBuild(ctx, sourceDir, "site/2", manifest, db.BuildSettings{})
+	if err != nil {
+		t.Fatalf("DetectAndBuild() with build command error = %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(result.SiteDir, "index.html")); err != nil || string(content) != "built\n" {
+		t.Errorf("index.html = %q (%v), want the built page", content, err)
+	}
+
+	manifest.Build.Command = "exit 3"
+	manifest.Static = "missing"
+	if _, err := b.DetectAndBuild(ctx, sourceDir, "site/3", manifest, db.BuildSettings{}); err == nil {
+		t.Error("DetectAndBuild() with a failing build command succeeded")
+	}
+}
+
+func TestCopyPublishedDir(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret.key")
+	if err := os.WriteFile(secret, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	newSite := func(t *testing.T) string {
+		sourceDir := t.TempDir()
+		writeFiles(t, sourceDir, map[string]string{
+			"site/index.html":   "<h1>Hello</h1>",
+			"shared/logo.png":   "logo",
+			"site/css/app.css":  "",
+			"outside/notes.txt": "",
+		})
+		return sourceDir
+	}
+	link := func(t *testing.T, target, path string) {
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Links inside the source tree are replaced by what they point to
+	sourceDir := newSite(t)
+	link(t, "../shared/logo.png", filepath.Join(sourceDir, "site", "logo.png"))
+	link(t, "css", filepath.Join(sourceDir, "site", "styles"))
+	link(t, "..", filepath.Join(sourceDir, "site", "css", "loop"))
+	link(t, "missing.html", filepath.Join(sourceDir, "site", "broken.html"))
+
+	dst := filepath.Join(t.TempDir(), "site")
+	err := copyPublishedDir(filepath.Join(sourceDir, "site"), dst, sourceDir)
+	if err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Errorf("copyPublishedDir() with a loop error = %v, want a loop error", err)
+	}
+	os.Remove(filepath.Join(sourceDir, "site", "css", "loop"))
+
+	dst = filepath.Join(t.TempDir(), "site")
+	if err := copyPublishedDir(filepath.Join(sourceDir, "site"), dst, sourceDir); err != nil {
+		t.Fatalf("copyPublishedDir() error = %v", err)
+	}
+	if info, err := os.Lstat(filepath.Join(dst, "logo.png")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("logo.png published as %v, %v, want a copy of the file", info, err)
+	}
+	if info, err := os.Lstat(filepath.Join(dst, "styles", "app.css")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("styles/app.css published as %v, %v, want a copy of the file", info, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "broken.html")); !os.IsNotExist(err) {
+		t.Errorf("broken link published: %v", err)
+	}
+
+	// Links leaving the source tree fail the copy
+	for name, target := range map[string]string{
+		"Absolute file":      secret,
+		"Absolute directory": filepath.Dir(secret),
+		"Relative":           "../..",
+	} {
+		t.Run(name, func(t *testing.T) {
+			sourceDir := newSite(t)
+			link(t, target, filepath.Join(sourceDir, "site", "leak"))
+
+			dst := filepath.Join(t.TempDir(), "site")
+			if err := copyPublishedDir(filepath.Join(sourceDir, "site"), dst, sourceDir); err == nil {
+				t.Error("copyPublishedDir() published a link outside the source tree")
+			}
+			if content, err := os.ReadFile(filepath.Join(dst, "leak")); err == nil {
+				t.Errorf("leak published with %q", content)
+			}
+		})
+	}
+
+	// Sites built from such a tree fail to build
+	b := NewBuilder(BuildConfig{OutputDir: t.TempDir(), Sandbox: testSandbox}, newMockLogger(t))
+	sourceDir = t.TempDir()
+	writeFiles(t, sourceDir, map[string]string{"index.html": "<h1>Hello</h1>"})
+	link(t, secret, filepath.Join(sourceDir, "secret.key"))
+	if _, err := b.DetectAndBuild(context.Background(), sourceDir, "site/1", nil, db.BuildSettings{}); err == nil {
+		t.Error("DetectAndBuild() published a link outside the source tree")
+	}
+}
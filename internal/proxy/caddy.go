@@ -9,16 +9,22 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/danbruder/skyline/internal/config"
 )
 
-// RouteConfig represents a Caddy route configuration
+// RouteConfig represents a Caddy route configuration. Routes either proxy to
+// an upstream or serve files from Root.
 type RouteConfig struct {
-	Domain    string
-	TargetURL string
+	Domain       string
+	Upstream     string
+	Root         string // Directory of a static site
+	SPA          bool   // Serve index.html for paths without a file
+	AssetsPrefix string // Path prefix served from AssetsRoot instead of the upstream
+	AssetsRoot   string
 }
 
 // CaddyManager manages Caddy configuration
@@ -42,6 +48,9 @@ func NewCaddyManager(cfg config.ProxyConfig, logger *log.Logger) *CaddyManager {
 	if cfg.ReloadTimeout == 0 {
 		cfg.ReloadTimeout = 10 * time.Second
 	}
+	if cfg.StaticMaxAge == 0 {
+		cfg.StaticMaxAge = time.Hour
+	}
 
 	return &CaddyManager{
 		cfg:      cfg,
@@ -108,8 +117,38 @@ func (c *CaddyManager) AddRoute(appID, domain string, port int) error {
 	defer c.mu.Unlock()
 
 	c.routes[appID] = RouteConfig{
-		Domain:    domain,
-		TargetURL: fmt.Sprintf("http://localhost:%d", port),
+		Domain:   domain,
+		Upstream: fmt.Sprintf("localhost:%d", port),
+	}
+
+	return c.reloadConfig()
+}
+
+// AddAssetsRoute adds a route to Caddy that serves paths below a prefix from
+// a directory and proxies everything else to the app
+func (c *CaddyManager) AddAssetsRoute(appID, domain string, port int, prefix, root string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.routes[appID] = RouteConfig{
+		Domain:       domain,
+		Upstream:     fmt.Sprintf("localhost:%d", port),
+		AssetsPrefix: prefix,
+		AssetsRoot:   root,
+	}
+
+	return c.reloadConfig()
+}
+
+// AddStaticRoute adds a route serving a static site from a directory
+func (c *CaddyManager) AddStaticRoute(appID, domain, root string, spa bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.routes[appID] = RouteConfig{
+		Domain: domain,
+		Root:   root,
+		SPA:    spa,
 	}
 
 	return c.reloadConfig()
@@ -182,16 +221,7 @@ func (c *CaddyManager) generateConfig() error {
 					"host": []string{route.Domain},
 				},
 			},
-			"handle": []interface{}{
-				map[string]interface{}{
-					"handler": "reverse_proxy",
-					"upstreams": []interface{}{
-						map[string]interface{}{
-							"dial": route.TargetURL,
-						},
-					},
-				},
-			},
+			"handle": c.routeHandlers(route),
 		})
 	}
 
@@ -210,6 +240,99 @@ func (c *CaddyManager) generateConfig() error {
 	return nil
 }
 
+// routeHandlers returns the Caddy handlers of a route
+func (c *CaddyManager) routeHandlers(route RouteConfig) []interface{} {
+	if route.Root == "" {
+		reverseProxy := map[string]interface{}{
+			"handler": "reverse_proxy",
+			"upstreams": []interface{}{
+				map[string]interface{}{
+					"dial": route.Upstream,
+				},
+			},
+		}
+		if route.AssetsPrefix == "" {
+			return []interface{}{reverseProxy}
+		}
+
+		// Serve assets from disk with cache headers, and proxy the rest
+		prefix := strings.TrimSuffix(route.AssetsPrefix, "/")
+		return []interface{}{
+			map[string]interface{}{
+				"handler": "subroute",
+				"routes": []interface{}{
+					map[string]interface{}{
+						"match": []interface{}{
+							map[string]interface{}{
+								"path": []string{prefix + "/*"},
+							},
+						},
+						"handle": []interface{}{
+							map[string]interface{}{
+								"handler": "headers",
+								"response": map[string]interface{}{
+									"set": map[string]interface{}{
+										"Cache-Control": []string{fmt.Sprintf("public, max-age=%d", int(c.cfg.StaticMaxAge.Seconds()))},
+									},
+								},
+							},
+							map[string]interface{}{
+								"handler":           "rewrite",
+								"strip_path_prefix": prefix,
+							},
+							map[string]interface{}{
+								"handler": "file_server",
+								"root":    route.AssetsRoot,
+							},
+						},
+						"terminal": true,
+					},
+					map[string]interface{}{
+						"handle": []interface{}{reverseProxy},
+					},
+				},
+			},
+		}
+	}
+
+	fileServer := map[string]interface{}{
+		"handler": "file_server",
+		"root":    route.Root,
+	}
+	if !route.SPA {
+		return []interface{}{fileServer}
+	}
+
+	// Rewrite paths without a file to /index.html, like try_files in a
+	// Caddyfile
+	return []interface{}{
+		map[string]interface{}{
+			"handler": "subroute",
+			"routes": []interface{}{
+				map[string]interface{}{
+					"match": []interface{}{
+						map[string]interface{}{
+							"file": map[string]interface{}{
+								"root":      route.Root,
+								"try_files": []string{"{http.request.uri.path}", "{http.request.uri.path}/", "/index.html"},
+							},
+						},
+					},
+					"handle": []interface{}{
+						map[string]interface{}{
+							"handler": "rewrite",
+							"uri":     "{http.matchers.file.relative}",
+						},
+					},
+				},
+				map[string]interface{}{
+					"handle": []interface{}{fileServer},
+				},
+			},
+		},
+	}
+}
+
 func (c *CaddyManager) reloadConfig() error {
 	// Generate new config
 	if err := c.generateConfig(); err != nil {
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danbruder/skyline/internal/config"
+)
+
+func TestGenerateConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "caddy.json")
+	c := NewCaddyManager(config.ProxyConfig{ConfigPath: configPath}, log.New(os.Stderr, "", 0))
+
+	if err := c.AddRoute("api", "api.example.com", 8081); err != nil {
+		t.Fatalf("AddRoute() error = %v", err)
+	}
+	if err := c.AddAssetsRoute("shop", "shop.example.com", 8082, "/static", "/srv/shop/static"); err != nil {
+		t.Fatalf("AddAssetsRoute() error = %v", err)
+	}
+	if err := c.AddStaticRoute("docs", "docs.example.com", "/srv/docs", false); err != nil {
+		t.Fatalf("AddStaticRoute() error = %v", err)
+	}
+	if err := c.AddStaticRoute("web", "www.example.com", "/srv/web", true); err != nil {
+		t.Fatalf("AddStaticRoute() with SPA error = %v", err)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+
+	var cfg struct {
+		Apps struct {
+			HTTP struct {
+				Servers map[string]struct {
+					Routes []struct {
+						Match []struct {
+							Host []string `json:"host"`
+						} `json:"match"`
+						Handle []struct {
+							Handler   string `json:"handler"`
+							Root      string `json:"root"`
+							Upstreams []struct {
+								Dial string `json:"dial"`
+							} `json:"upstreams"`
+							Routes []json.RawMessage `json:"routes"`
+						} `json:"handle"`
+					} `json:"routes"`
+				} `json:"servers"`
+			} `json:"http"`
+		} `json:"apps"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	handlers := make(map[string]string)
+	for _, route := range cfg.Apps.HTTP.Servers["main"].Routes {
+		handle := route.Handle[0]
+		switch handle.Handler {
+		case "reverse_proxy":
+			handlers[route.Match[0].Host[0]] = handle.Upstreams[0].Dial
+		case "file_server":
+			handlers[route.Match[0].Host[0]] = handle.Root
+		case "subroute":
+			// Assets or the fallback to index.html come before the catch-all
+			if len(handle.Routes) != 2 {
+				t.Errorf("subroute has %d routes, want 2", len(handle.Routes))
+				continue
+			}
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, handle.Routes[0]); err != nil {
+				t.Fatalf("Failed to compact route: %v", err)
+			}
+			handlers[route.Match[0].Host[0]] = compact.String()
+		}
+	}
+
+	want := map[string]string{
+		"api.example.com":  "localhost:8081",
+		"docs.example.com": "/srv/docs",
+	}
+	for host, handler := range want {
+		if handlers[host] != handler {
+			t.Errorf("route for %s = %q, want %q", host, handlers[host], handler)
+		}
+	}
+
+	// Assets are served from disk with cache headers
+	for _, part := range []string{`"/static/*"`, `"public, max-age=3600"`, `"strip_path_prefix":"/static"`, `"root":"/srv/shop/static"`} {
+		if !strings.Contains(handlers["shop.example.com"], part) {
+			t.Errorf("assets route %s does not contain %s", handlers["shop.example.com"], part)
+		}
+	}
+	if !strings.Contains(handlers["www.example.com"], `"/index.html"`) {
+		t.Errorf("SPA route %s does not fall back to /index.html", handlers["www.example.com"])
+	}
+}
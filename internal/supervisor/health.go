@@ -0,0 +1,284 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danbruder/skyline/pkg/events"
+)
+
+// Health check defaults
+const (
+	defaultHealthInterval    = 10 * time.Second
+	defaultHealthTimeout     = 5 * time.Second
+	defaultFailureThreshold  = 3
+	defaultHealthGracePeriod = 30 * time.Second
+	maxHealthHistory         = 20
+)
+
+// HealthCheck configures how the health of an app is checked
+type HealthCheck struct {
+	Type             string // http, tcp
+	Path             string
+	ExpectedStatus   int
+	Interval         time.Duration
+	Timeout          time.Duration
+	FailureThreshold int
+	GracePeriod      time.Duration
+}
+
+// HealthResult is the outcome of a single health check
+type HealthResult struct {
+	Time     time.Time `json:"time"`
+	Healthy  bool      `json:"healthy"`
+	Error    string    `json:"error,omitempty"`
+	Duration string    `json:"duration"`
+}
+
+// HealthStatus is the health of an app and its recent check results
+type HealthStatus struct {
+	Status              string         `json:"status"` // unknown, healthy, unhealthy
+	ConsecutiveFailures int            `json:"consecutive_failures"`
+	History             []HealthResult `json:"history"`
+}
+
+// WithDefaults returns the check with defaults filled in for unset settings
+func (check HealthCheck) WithDefaults() HealthCheck {
+	if check.Type == "" {
+		check.Type = "http"
+	}
+	if check.Type == "http" && check.Path == "" {
+		check.Path = "/"
+	}
+	if check.Type == "http" && check.ExpectedStatus == 0 {
+		check.ExpectedStatus = http.StatusOK
+	}
+	if check.Interval == 0 {
+		check.Interval = defaultHealthInterval
+	}
+	if check.Timeout == 0 {
+		check.Timeout = defaultHealthTimeout
+	}
+	if check.FailureThreshold == 0 {
+		check.FailureThreshold = defaultFailureThreshold
+	}
+	if check.GracePeriod == 0 {
+		check.GracePeriod = defaultHealthGracePeriod
+	}
+
+	return check
+}
+
+// SetHealthCheck configures the health check of an application
+func (s *Supervisor) SetHealthCheck(appID string, check HealthCheck) {
+	check = check.WithDefaults()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks[appID] = check
+	s.health[appID] = &HealthStatus{Status: "unknown"}
+}
+
+// RemoveHealthCheck stops checking the health of an application
+func (s *Supervisor) RemoveHealthCheck(appID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.checks, appID)
+	delete(s.health, appID)
+}
+
+// GetHealth returns a snapshot of the health of an application
+func (s *Supervisor) GetHealth(appID string) (HealthStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	health, exists := s.health[appID]
+	if !exists {
+		return HealthStatus{}, fmt.Errorf("app %s has no health check", appID)
+	}
+
+	status := *health
+	status.History = append([]HealthResult(nil), health.History...)
+	return status, nil
+}
+
+// monitorHealth checks the health of a managed process until it exits,
+// restarting it once it has failed too many checks in a row
+func (s *Supervisor) monitorHealth(proc *ProcessInfo) {
+	for {
+		interval := defaultHealthInterval
+		s.mu.RLock()
+		if check, exists := s.checks[proc.AppID]; exists {
+			interval = check.Interval
+		}
+		s.mu.RUnlock()
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-proc.done:
+			return
+		case <-time.After(interval):
+		}
+
+		s.mu.RLock()
+		check, exists := s.checks[proc.AppID]
+		managed := s.procs[proc.AppID] == proc && proc.Status == "running"
+		s.mu.RUnlock()
+
+		if !managed {
+			return
+		}
+
+		// Give the process time to start up
+		if !exists || time.Since(proc.StartTime) < check.GracePeriod {
+			continue
+		}
+
+		started := time.Now()
+		err := checkHealth(s.ctx, check, proc.Port)
+		if s.recordHealth(proc.AppID, check, started, err) {
+			if s.restartUnhealthy(proc, err) {
+				return
+			}
+		}
+	}
+}
+
+// recordHealth stores the result of a health check and reports whether the
+// app crossed its failure threshold
+func (s *Supervisor) recordHealth(appID string, check HealthCheck, started time.Time, err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	health, exists := s.health[appID]
+	if !exists {
+		return false
+	}
+
+	result := HealthResult{
+		Time:     started,
+		Healthy:  err == nil,
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	health.History = append(health.History, result)
+	if len(health.History) > maxHealthHistory {
+		health.History = health.History[len(health.History)-maxHealthHistory:]
+	}
+
+	if err == nil {
+		health.Status = "healthy"
+		health.ConsecutiveFailures = 0
+		return false
+	}
+
+	health.ConsecutiveFailures++
+	if health.ConsecutiveFailures < check.FailureThreshold {
+		return false
+	}
+
+	health.Status = "unhealthy"
+	health.ConsecutiveFailures = 0
+	return true
+}
+
+// restartUnhealthy publishes an event for an unhealthy process and restarts
+// it, reporting whether a restart was attempted
+func (s *Supervisor) restartUnhealthy(proc *ProcessInfo, err error) bool {
+	s.eventBus.Publish(events.Event{
+		Type:    events.AppFailed,
+		AppID:   proc.AppID,
+		Message: fmt.Sprintf("App %s is unhealthy: %v", proc.AppID, err),
+	})
+
+	s.mu.Lock()
+	restarts := proc.Restarts
+	if restarts < s.cfg.MaxRestarts {
+		proc.Restarts++
+	}
+	s.mu.Unlock()
+
+	if restarts >= s.cfg.MaxRestarts {
+		s.logger.Printf("Unhealthy app %s exceeded maximum restart attempts (%d)",
+			proc.AppID, s.cfg.MaxRestarts)
+		return false
+	}
+
+	s.logger.Printf("Restarting unhealthy app %s (attempt %d/%d)...",
+		proc.AppID, restarts+1, s.cfg.MaxRestarts)
+
+	if err := s.RestartApp(proc.AppID); err != nil {
+		s.logger.Printf("Failed to restart app %s: %v", proc.AppID, err)
+	}
+
+	return true
+}
+
+// checkHealth runs a single health check against a local port
+func checkHealth(ctx context.Context, check HealthCheck, port int) error {
+	if port == 0 {
+		return fmt.Errorf("app has no port")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	addr := fmt.Sprintf("localhost:%d", port)
+
+	if check.Type == "tcp" {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+check.Path, nil)
+	if err != nil {
+		return err
+	}
+
+	// Redirects count as responses of their own
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != check.ExpectedStatus {
+		return fmt.Errorf("unexpected status %d, want %d", resp.StatusCode, check.ExpectedStatus)
+	}
+
+	return nil
+}
+
+// envPort returns the port a process was given in its environment
+func envPort(env []string) int {
+	port := 0
+	for _, e := range env {
+		if value, ok := strings.CutPrefix(e, "PORT="); ok {
+			if parsed, err := strconv.Atoi(value); err == nil {
+				port = parsed
+			}
+		}
+	}
+	return port
+}
@@ -0,0 +1,97 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/danbruder/skyline/internal/config"
+	"github.com/danbruder/skyline/pkg/events"
+)
+
+func TestCheckHealth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusOK)
+		case "/old":
+			http.Redirect(w, r, "/healthz", http.StatusMovedPermanently)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(serverURL.Port())
+
+	tests := []struct {
+		name    string
+		check   HealthCheck
+		port    int
+		wantErr bool
+	}{
+		{name: "HTTP healthy", check: HealthCheck{Type: "http", Path: "/healthz"}, port: port},
+		{name: "HTTP unexpected status", check: HealthCheck{Type: "http", Path: "/broken"}, port: port, wantErr: true},
+		{name: "HTTP redirect not followed", check: HealthCheck{Type: "http", Path: "/old"}, port: port, wantErr: true},
+		{name: "HTTP expected redirect", check: HealthCheck{Type: "http", Path: "/old", ExpectedStatus: http.StatusMovedPermanently}, port: port},
+		{name: "TCP listening", check: HealthCheck{Type: "tcp"}, port: port},
+		{name: "No port", check: HealthCheck{Type: "tcp"}, port: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkHealth(context.Background(), tt.check.WithDefaults(), tt.port)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkHealth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecordHealth(t *testing.T) {
+	s := New(context.Background(), config.SupervisorConfig{}, log.Default(), events.NewEventBus())
+	s.SetHealthCheck("app", HealthCheck{Type: "tcp", FailureThreshold: 2})
+	check := s.checks["app"]
+
+	failed := errors.New("connection refused")
+	steps := []struct {
+		err           error
+		wantUnhealthy bool
+		wantStatus    string
+	}{
+		{err: nil, wantStatus: "healthy"},
+		{err: failed, wantStatus: "healthy"},
+		{err: failed, wantUnhealthy: true, wantStatus: "unhealthy"},
+		{err: nil, wantStatus: "healthy"},
+	}
+
+	for i, step := range steps {
+		if got := s.recordHealth("app", check, time.Now(), step.err); got != step.wantUnhealthy {
+			t.Errorf("step %d: recordHealth() = %v, want %v", i, got, step.wantUnhealthy)
+		}
+
+		health, err := s.GetHealth("app")
+		if err != nil {
+			t.Fatalf("GetHealth() error = %v", err)
+		}
+		if health.Status != step.wantStatus {
+			t.Errorf("step %d: status = %q, want %q", i, health.Status, step.wantStatus)
+		}
+	}
+
+	health, _ := s.GetHealth("app")
+	if len(health.History) != len(steps) {
+		t.Errorf("history has %d results, want %d", len(health.History), len(steps))
+	}
+
+	if got := envPort([]string{"PORT=1", "HOME=/tmp", "PORT=8081"}); got != 8081 {
+		t.Errorf("envPort() = %d, want 8081", got)
+	}
+}
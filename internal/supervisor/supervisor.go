@@ -15,13 +15,24 @@ import (
 	"github.com/danbruder/skyline/pkg/events"
 )
 
+// Command describes how to run the process of an application
+type Command struct {
+	Path string   // Executable, looked up in PATH if it has no separators
+	Args []string // Arguments, without the executable
+	Env  []string // Environment in addition to the supervisor's own
+	Dir  string   // Working directory, defaults to the app directory
+}
+
 // ProcessInfo stores information about a running process
 type ProcessInfo struct {
 	AppID     string
 	Cmd       *exec.Cmd
 	StartTime time.Time
+	Port      int
 	Restarts  int
 	Status    string // running, stopped, crashed
+	command   Command
+	done      chan struct{}
 }
 
 // Supervisor manages application processes
@@ -31,6 +42,9 @@ type Supervisor struct {
 	logger   *log.Logger
 	eventBus *events.EventBus
 	procs    map[string]*ProcessInfo
+	staged   map[string]*ProcessInfo
+	checks   map[string]HealthCheck
+	health   map[string]*HealthStatus
 	mu       sync.RWMutex
 }
 
@@ -42,6 +56,9 @@ func New(ctx context.Context, cfg config.SupervisorConfig, logger *log.Logger, e
 		logger:   logger,
 		eventBus: eventBus,
 		procs:    make(map[string]*ProcessInfo),
+		staged:   make(map[string]*ProcessInfo),
+		checks:   make(map[string]HealthCheck),
+		health:   make(map[string]*HealthStatus),
 	}
 }
 
@@ -65,18 +82,39 @@ func (s *Supervisor) Stop() {
 	s.logger.Println("Stopping supervisor...")
 
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	var staged, running []*ProcessInfo
+	for _, proc := range s.staged {
+		if markStopped(proc) {
+			staged = append(staged, proc)
+		}
+	}
+	for _, proc := range s.procs {
+		if markStopped(proc) {
+			running = append(running, proc)
+		}
+	}
+	s.mu.Unlock()
 
-	for appID, proc := range s.procs {
-		s.logger.Printf("Stopping app %s...", appID)
+	for _, proc := range staged {
+		if err := s.terminate(proc); err != nil {
+			s.logger.Printf("Error stopping staged process of app %s: %v", proc.AppID, err)
+		}
+	}
+
+	for _, proc := range running {
+		s.logger.Printf("Stopping app %s...", proc.AppID)
 		if err := s.stopProcess(proc); err != nil {
-			s.logger.Printf("Error stopping app %s: %v", appID, err)
+			s.logger.Printf("Error stopping app %s: %v", proc.AppID, err)
 		}
 	}
 }
 
 // StartApp starts an application
-func (s *Supervisor) StartApp(appID, execPath string, env []string) error {
+func (s *Supervisor) StartApp(appID string, command Command) error {
+	return s.startApp(appID, command, 0)
+}
+
+func (s *Supervisor) startApp(appID string, command Command, restarts int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -85,63 +123,137 @@ func (s *Supervisor) StartApp(appID, execPath string, env []string) error {
 		return fmt.Errorf("app %s is already running", appID)
 	}
 
-	// Start the process
-	cmd := exec.CommandContext(s.ctx, execPath)
-	cmd.Env = append(os.Environ(), env...)
-	cmd.Dir = filepath.Join(s.cfg.AppsDir, appID)
+	proc, err := s.spawn(appID, command)
+	if err != nil {
+		return err
+	}
+	proc.Restarts = restarts
+	s.procs[appID] = proc
+	go s.monitorHealth(proc)
 
-	// Setup stdout and stderr
-	logFile, err := os.OpenFile(
-		filepath.Join(cmd.Dir, "app.log"),
-		os.O_CREATE|os.O_WRONLY|os.O_APPEND,
-		0644,
-	)
+	// Publish event
+	s.eventBus.Publish(events.Event{
+		Type:    events.AppStarted,
+		AppID:   appID,
+		Message: fmt.Sprintf("App %s started with PID %d", appID, proc.Cmd.Process.Pid),
+	})
+
+	return nil
+}
+
+// StageApp starts a new process for an application next to the one that is
+// currently running. The staged process takes over once it is promoted.
+func (s *Supervisor) StageApp(appID string, command Command) error {
+	// Replace any leftover staged process
+	s.mu.Lock()
+	leftover, exists := s.staged[appID]
+	if exists {
+		delete(s.staged, appID)
+		exists = markStopped(leftover)
+	}
+	s.mu.Unlock()
+
+	if exists {
+		if err := s.terminate(leftover); err != nil {
+			return fmt.Errorf("failed to stop previously staged process: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proc, err := s.spawn(appID, command)
 	if err != nil {
-		return fmt.Errorf("failed to open log file: %w", err)
+		return err
 	}
+	s.staged[appID] = proc
 
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
+	s.logger.Printf("Staged app %s with PID %d", appID, proc.Cmd.Process.Pid)
+	return nil
+}
 
-	// Start the process
-	if err := cmd.Start(); err != nil {
-		logFile.Close()
-		return fmt.Errorf("failed to start app: %w", err)
+// GetStagedStatus returns the status of the staged process of an application
+func (s *Supervisor) GetStagedStatus(appID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	proc, exists := s.staged[appID]
+	if !exists {
+		return "", fmt.Errorf("app %s has no staged process", appID)
 	}
 
-	// Store process info
-	proc := &ProcessInfo{
-		AppID:     appID,
-		Cmd:       cmd,
-		StartTime: time.Now(),
-		Status:    "running",
-		Restarts:  0,
+	return proc.Status, nil
+}
+
+// PromoteApp makes the staged process of an application the managed one.
+// The previously running process is stopped in the background after the
+// drain period, giving it time to finish in-flight requests.
+func (s *Supervisor) PromoteApp(appID string, drain time.Duration) error {
+	s.mu.Lock()
+	proc, exists := s.staged[appID]
+	if !exists {
+		s.mu.Unlock()
+		return fmt.Errorf("app %s has no staged process", appID)
 	}
+	if proc.Status != "running" {
+		delete(s.staged, appID)
+		s.mu.Unlock()
+		return fmt.Errorf("staged process of app %s is %s", appID, proc.Status)
+	}
+
+	delete(s.staged, appID)
+	old := s.procs[appID]
 	s.procs[appID] = proc
+	s.mu.Unlock()
 
 	// Publish event
 	s.eventBus.Publish(events.Event{
 		Type:    events.AppStarted,
 		AppID:   appID,
-		Message: fmt.Sprintf("App %s started with PID %d", appID, cmd.Process.Pid),
+		Message: fmt.Sprintf("App %s started with PID %d", appID, proc.Cmd.Process.Pid),
 	})
 
-	// Monitor process in background
-	go s.waitForProcess(proc, logFile)
+	go s.monitorHealth(proc)
+	if old != nil {
+		go s.drain(old, drain)
+	}
 
 	return nil
 }
 
+// DiscardApp stops the staged process of an application, leaving the
+// running process untouched
+func (s *Supervisor) DiscardApp(appID string) error {
+	s.mu.Lock()
+	proc, exists := s.staged[appID]
+	if !exists {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.staged, appID)
+	running := markStopped(proc)
+	s.mu.Unlock()
+
+	if !running {
+		return nil
+	}
+	return s.terminate(proc)
+}
+
 // StopApp stops an application
 func (s *Supervisor) StopApp(appID string) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	proc, exists := s.procs[appID]
 	if !exists {
+		s.mu.Unlock()
 		return fmt.Errorf("app %s is not managed by supervisor", appID)
 	}
+	running := markStopped(proc)
+	s.mu.Unlock()
 
+	if !running {
+		return nil
+	}
 	return s.stopProcess(proc)
 }
 
@@ -156,8 +268,10 @@ func (s *Supervisor) RestartApp(appID string) error {
 	}
 
 	// Get app details
-	execPath := proc.Cmd.Path
-	env := proc.Cmd.Env
+	s.mu.RLock()
+	command := proc.command
+	restarts := proc.Restarts
+	s.mu.RUnlock()
 
 	// Stop the app
 	if err := s.StopApp(appID); err != nil {
@@ -166,7 +280,7 @@ func (s *Supervisor) RestartApp(appID string) error {
 
 	// Start the app again
 	time.Sleep(500 * time.Millisecond) // Small delay to ensure cleanup
-	return s.StartApp(appID, execPath, env)
+	return s.startApp(appID, command, restarts)
 }
 
 // GetStatus returns the status of an application
@@ -182,6 +296,19 @@ func (s *Supervisor) GetStatus(appID string) (string, error) {
 	return proc.Status, nil
 }
 
+// GetProcessInfo returns a snapshot of the process information of an application
+func (s *Supervisor) GetProcessInfo(appID string) (ProcessInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	proc, exists := s.procs[appID]
+	if !exists {
+		return ProcessInfo{}, fmt.Errorf("app %s is not managed by supervisor", appID)
+	}
+
+	return *proc, nil
+}
+
 // ListApps returns a list of managed applications
 func (s *Supervisor) ListApps() []string {
 	s.mu.RLock()
@@ -197,11 +324,85 @@ func (s *Supervisor) ListApps() []string {
 
 // Private methods
 
+// spawn starts the process of an application and monitors it in the background
+func (s *Supervisor) spawn(appID string, command Command) (*ProcessInfo, error) {
+	appDir := filepath.Join(s.cfg.AppsDir, appID)
+
+	cmd := exec.CommandContext(s.ctx, command.Path, command.Args...)
+	cmd.Env = append(os.Environ(), command.Env...)
+	cmd.Dir = command.Dir
+	if cmd.Dir == "" {
+		cmd.Dir = appDir
+	}
+
+	// Setup stdout and stderr
+	logFile, err := os.OpenFile(
+		filepath.Join(appDir, "app.log"),
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND,
+		0644,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	// Start the process
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("failed to start app: %w", err)
+	}
+
+	proc := &ProcessInfo{
+		AppID:     appID,
+		Cmd:       cmd,
+		StartTime: time.Now(),
+		Port:      envPort(command.Env),
+		Status:    "running",
+		command:   command,
+		done:      make(chan struct{}),
+	}
+
+	// Monitor process in background
+	go s.waitForProcess(proc, logFile)
+
+	return proc, nil
+}
+
+// stopProcess stops the managed process of an app that was marked stopped
+// and publishes that it stopped
 func (s *Supervisor) stopProcess(proc *ProcessInfo) error {
+	if err := s.terminate(proc); err != nil {
+		return err
+	}
+
+	// Publish event
+	s.eventBus.Publish(events.Event{
+		Type:    events.AppStopped,
+		AppID:   proc.AppID,
+		Message: fmt.Sprintf("App %s stopped", proc.AppID),
+	})
+
+	return nil
+}
+
+// markStopped marks a running process as stopped, so waitForProcess does
+// not treat its exit as a crash, and reports whether it was running. Callers
+// hold s.mu and terminate the process once they released it.
+func markStopped(proc *ProcessInfo) bool {
 	if proc.Status != "running" {
-		return nil
+		return false
 	}
 
+	proc.Status = "stopped"
+	return true
+}
+
+// terminate stops a process marked stopped, killing it if it does not exit
+// in time. Waiting for the process takes up to 5 seconds, so callers must not
+// hold s.mu.
+func (s *Supervisor) terminate(proc *ProcessInfo) error {
 	// Send SIGTERM
 	if err := proc.Cmd.Process.Signal(syscall.SIGTERM); err != nil {
 		s.logger.Printf("Failed to send SIGTERM to app %s: %v", proc.AppID, err)
@@ -212,35 +413,39 @@ func (s *Supervisor) stopProcess(proc *ProcessInfo) error {
 		}
 	}
 
-	// Wait for process to exit (with timeout)
-	done := make(chan error, 1)
-	go func() {
-		_, err := proc.Cmd.Process.Wait()
-		done <- err
-	}()
-
+	// Wait for waitForProcess to reap the process (with timeout)
 	select {
-	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("error waiting for process to exit: %w", err)
-		}
+	case <-proc.done:
 	case <-time.After(5 * time.Second):
 		// Force kill after timeout
 		if err := proc.Cmd.Process.Kill(); err != nil {
 			return fmt.Errorf("failed to force kill process: %w", err)
 		}
+		<-proc.done
 	}
 
-	proc.Status = "stopped"
+	return nil
+}
 
-	// Publish event
-	s.eventBus.Publish(events.Event{
-		Type:    events.AppStopped,
-		AppID:   proc.AppID,
-		Message: fmt.Sprintf("App %s stopped", proc.AppID),
-	})
+// drain stops a replaced process once the drain period has passed
+func (s *Supervisor) drain(proc *ProcessInfo, period time.Duration) {
+	select {
+	case <-s.ctx.Done():
+		return
+	case <-time.After(period):
+	}
 
-	return nil
+	s.mu.Lock()
+	running := markStopped(proc)
+	s.mu.Unlock()
+	if !running {
+		return
+	}
+
+	s.logger.Printf("Stopping replaced process %d of app %s", proc.Cmd.Process.Pid, proc.AppID)
+	if err := s.terminate(proc); err != nil {
+		s.logger.Printf("Error stopping replaced process of app %s: %v", proc.AppID, err)
+	}
 }
 
 func (s *Supervisor) waitForProcess(proc *ProcessInfo, logFile *os.File) {
@@ -248,6 +453,7 @@ func (s *Supervisor) waitForProcess(proc *ProcessInfo, logFile *os.File) {
 
 	// Wait for process to exit
 	err := proc.Cmd.Wait()
+	close(proc.done)
 
 	s.mu.Lock()
 	if s.ctx.Err() != nil || proc.Status == "stopped" {
@@ -258,6 +464,13 @@ func (s *Supervisor) waitForProcess(proc *ProcessInfo, logFile *os.File) {
 
 	// Process exited unexpectedly
 	proc.Status = "crashed"
+
+	// Staged and replaced processes are not restarted
+	if s.procs[proc.AppID] != proc {
+		s.mu.Unlock()
+		s.logger.Printf("Process %d of app %s exited: %v", proc.Cmd.Process.Pid, proc.AppID, err)
+		return
+	}
 	s.mu.Unlock()
 
 	// Publish event
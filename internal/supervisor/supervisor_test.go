@@ -0,0 +1,64 @@
+package supervisor
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danbruder/skyline/internal/config"
+	"github.com/danbruder/skyline/pkg/events"
+)
+
+func TestDrainDoesNotBlock(t *testing.T) {
+	appsDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(appsDir, "app"), 0755); err != nil {
+		t.Fatalf("Failed to create app directory: %v", err)
+	}
+
+	s := New(context.Background(), config.SupervisorConfig{AppsDir: appsDir}, log.Default(), events.NewEventBus())
+	defer s.Stop()
+
+	// The replaced process ignores SIGTERM, so stopping it takes until it
+	// is killed
+	stubborn := Command{Path: "sh", Args: []string{"-c", `trap "" TERM; while :; do sleep 0.1; done`}}
+	if err := s.StartApp("app", stubborn); err != nil {
+		t.Fatalf("StartApp() error = %v", err)
+	}
+	old := s.procs["app"]
+
+	if err := s.StageApp("app", Command{Path: "sleep", Args: []string{"30"}}); err != nil {
+		t.Fatalf("StageApp() error = %v", err)
+	}
+	if err := s.PromoteApp("app", 0); err != nil {
+		t.Fatalf("PromoteApp() error = %v", err)
+	}
+
+	// Wait for the drain to start stopping the replaced process
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.RLock()
+		status := old.Status
+		s.mu.RUnlock()
+		if status == "stopped" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if status, err := s.GetStatus("app"); err != nil || status != "running" {
+		t.Errorf("GetStatus() = %q, %v, want running", status, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetStatus() took %s while a replaced process was stopped", elapsed)
+	}
+
+	select {
+	case <-old.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("replaced process was not killed")
+	}
+}